@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
 	"os"
@@ -34,6 +35,22 @@ func main() {
 		w.Write([]byte("OK"))
 	})
 
+	// Token usage and cost of saved sessions, per repository
+	http.HandleFunc("/usage", func(w http.ResponseWriter, r *http.Request) {
+		store := hub.Sessions()
+		if store == nil {
+			http.Error(w, "sessions are not being saved", http.StatusServiceUnavailable)
+			return
+		}
+		usage, err := store.UsageByRepository()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(usage)
+	})
+
 	port := cfg.ServerPort
 	if port == "" {
 		port = "8080"
@@ -2,29 +2,30 @@ package api
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/jack/klaudkod/backend/internal/config"
 	"github.com/jack/klaudkod/backend/internal/llm"
+	"github.com/jack/klaudkod/backend/internal/session"
 )
 
 const (
-	securitySystemPrompt = `SECURITY RESTRICTIONS - CRITICAL:
-1. .env files and all variants (.env.*, .envrc, etc.) are STRICTLY FORBIDDEN from being read or accessed
-2. This restriction applies to ALL tools including bash, cat, read, and any file operations
-3. DO NOT attempt any workarounds or indirect methods to access .env files
-4. You are restricted to working within the current working directory and its subdirectories
-5. Use the 'read' tool for file access - do not use bash commands like 'cat' to read files
-6. Any attempt to violate these restrictions will be blocked
-These rules are enforced at the tool level and cannot be bypassed.`
+	continuePrompt = `Continue. If you were stopped for repeating a tool call, try a different approach.`
+
+	titleSystemPrompt = `Write a title of at most six words for a conversation that starts with the user's message below. Reply with the title only, without quotes or punctuation at the end.`
 
 	writeWait      = 10 * time.Second
 	pongWait       = 60 * time.Second
 	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 512 * 1024 // 512KB
+	maxMessageSize = 16 * 1024 * 1024 // 16MB, for image attachments
 )
 
 var upgrader = websocket.Upgrader{
@@ -39,12 +40,32 @@ type Client struct {
 	hub  *Hub
 	conn *websocket.Conn
 	send chan []byte
+	// background tracks work such as title generation that may still send
+	// messages after the read loop has ended.
+	background sync.WaitGroup
 }
 
 type IncomingMessage struct {
 	Type      string `json:"type"`
 	Content   string `json:"content,omitempty"`
 	SessionID string `json:"session_id,omitempty"`
+	// Profile picks the system prompt profile for a new session. It is
+	// read from the first prompt and ignored afterwards.
+	Profile     string       `json:"profile,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// ResponseSchema asks for the final answer of this prompt as JSON
+	// matching a JSON Schema, for scripts that parse it. The answer is
+	// sent in a "structured" message.
+	ResponseSchema *llm.ResponseSchema `json:"response_schema,omitempty"`
+}
+
+// Attachment is an image sent with a prompt, such as a pasted screenshot.
+// Data is base64 encoded. The media type is detected from the data when
+// it is not given.
+type Attachment struct {
+	Name      string `json:"name,omitempty"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data"`
 }
 
 type ToolCallMsg struct {
@@ -54,9 +75,49 @@ type ToolCallMsg struct {
 }
 
 type ToolResultMsg struct {
-	ToolCallID string `json:"toolCallId"`
-	Content    string `json:"content"`
-	IsError    bool   `json:"isError"`
+	ToolCallID string                 `json:"toolCallId"`
+	Content    string                 `json:"content"`
+	IsError    bool                   `json:"isError"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// RetryMsg reports that a failed LLM request is about to be retried.
+// Discarded and DiscardedReasoning hold text and reasoning that were
+// streamed by the failed attempt and should be removed from the display.
+type RetryMsg struct {
+	Attempt            int    `json:"attempt"`
+	MaxAttempts        int    `json:"maxAttempts"`
+	DelayMs            int64  `json:"delayMs"`
+	Discarded          string `json:"discarded,omitempty"`
+	DiscardedReasoning string `json:"discardedReasoning,omitempty"`
+}
+
+// FallbackMsg reports that a failing model was replaced by the next one in
+// the fallback chain. Discarded works as in RetryMsg.
+type FallbackMsg struct {
+	From               string `json:"from"`
+	To                 string `json:"to"`
+	Discarded          string `json:"discarded,omitempty"`
+	DiscardedReasoning string `json:"discardedReasoning,omitempty"`
+}
+
+// UsageMsg reports the tokens and cost of the step that just finished,
+// together with running totals for the turn and the session.
+type UsageMsg struct {
+	SessionID string    `json:"sessionId"`
+	Model     string    `json:"model"`
+	Step      llm.Usage `json:"step"`
+	Turn      llm.Usage `json:"turn"`
+	Session   llm.Usage `json:"session"`
+}
+
+// CompactionMsg reports that the conversation history was compacted.
+// Token counts are estimates.
+type CompactionMsg struct {
+	TokensBefore int `json:"tokensBefore"`
+	TokensAfter  int `json:"tokensAfter"`
+	Elided       int `json:"elided"`
+	Summarized   int `json:"summarized"`
 }
 
 type OutgoingMessage struct {
@@ -66,10 +127,17 @@ type OutgoingMessage struct {
 	IsFirst *bool          `json:"isFirst,omitempty"`
 	ToolCall   *ToolCallMsg   `json:"toolCall,omitempty"`
 	ToolResult *ToolResultMsg `json:"toolResult,omitempty"`
+	Retry      *RetryMsg      `json:"retry,omitempty"`
+	Fallback   *FallbackMsg   `json:"fallback,omitempty"`
+	Usage      *UsageMsg      `json:"usage,omitempty"`
+	Compaction *CompactionMsg `json:"compaction,omitempty"`
+	Profiles   []string       `json:"profiles,omitempty"`
+	Structured json.RawMessage `json:"structured,omitempty"`
 }
 
 func (c *Client) readPump() {
 	defer func() {
+		c.background.Wait()
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
@@ -83,6 +151,8 @@ func (c *Client) readPump() {
 
 	// Track conversation history
 	var messages []llm.Message
+	// sess is saved after every turn; it is started by the first prompt.
+	var sess *session.Session
 
 	for {
 		_, message, err := c.conn.ReadMessage()
@@ -99,47 +169,100 @@ func (c *Client) readPump() {
 			continue
 		}
 
+		// Continuing a turn that hit the step limit is a new turn that
+		// tells the model to go on.
+		if incoming.Type == "continue" {
+			if len(messages) == 0 {
+				c.sendError("Nothing to continue")
+				continue
+			}
+			incoming.Type = "prompt"
+			incoming.Content = continuePrompt
+		}
+
 		switch incoming.Type {
 		case "prompt":
+			images, err := decodeAttachments(incoming.Attachments)
+			if err != nil {
+				c.sendError(err.Error())
+				continue
+			}
+			if incoming.ResponseSchema != nil && len(incoming.ResponseSchema.Schema) == 0 {
+				c.sendError("response_schema needs a JSON Schema object in its schema field")
+				continue
+			}
+
+			if sess == nil {
+				sess = c.startSession(incoming.SessionID)
+				messages = append(messages, sess.Messages...)
+			}
+
 			// Add system prompt if this is the first message
 			if len(messages) == 0 {
+				profile := incoming.Profile
+				if profile == "" {
+					profile = c.hub.config.PromptProfile
+				}
+				systemPrompt, err := c.hub.SystemPrompt(profile)
+				if err != nil {
+					c.sendError(err.Error())
+					sess = nil
+					continue
+				}
+				sess.Profile = profile
 				messages = append(messages, llm.Message{
 					Role:    "system",
-					Content: securitySystemPrompt,
+					Content: systemPrompt,
 				})
+
+				// Name the conversation in the background while the
+				// first turn runs.
+				c.background.Add(1)
+				go func(sess *session.Session, prompt string) {
+					defer c.background.Done()
+					c.nameSession(sess, prompt)
+				}(sess, incoming.Content)
 			}
 
 			// Add user message to history
 			messages = append(messages, llm.Message{
 				Role:    "user",
 				Content: incoming.Content,
+				Images:  images,
 			})
 
 			// Get tool definitions
-			toolDefs := c.hub.ToolRegistry().GetOpenAITools()
+			toolDefs := c.hub.ToolDefinitions()
 
 			// Create tool executor function
-			executor := func(name, argsJSON string) (content string, isError bool) {
+			executor := func(name, argsJSON string) llm.ToolOutput {
 				result, err := c.hub.ToolRegistry().Execute(context.Background(), name, argsJSON)
 				if err != nil {
-					return err.Error(), true
+					return llm.ToolOutput{Content: err.Error(), IsError: true}
+				}
+				return llm.ToolOutput{
+					Content:  result.Content,
+					IsError:  result.IsError,
+					Metadata: result.Metadata,
+					Images:   result.Images,
 				}
-				return result.Content, result.IsError
 			}
 
 			// Stream response with tools
 			eventChan := make(chan llm.StreamEvent)
 			ctx := context.Background()
 
-			go c.hub.llmClient.StreamWithTools(ctx, messages, toolDefs, executor, eventChan)
+			go c.hub.llmClient.StreamStructured(ctx, messages, toolDefs, incoming.ResponseSchema, executor, eventChan)
 
-			var assistantContent string
-			var toolCalls []llm.ToolCall
+			// Text and reasoning streamed since the last completed
+			// message, which a retry may have to discard.
+			var stepContent, stepReasoning string
+			var turnUsage llm.Usage
 			firstChunk := true
 			for event := range eventChan {
 				switch event.Type {
 				case "chunk":
-					assistantContent += event.Content
+					stepContent += event.Content
 					isFirst := firstChunk
 					c.sendJSON(OutgoingMessage{
 						Type:    "chunk",
@@ -149,9 +272,19 @@ func (c *Client) readPump() {
 					if firstChunk {
 						firstChunk = false
 					}
+				case "reasoning":
+					// Reasoning comes before the reply text and starts
+					// the assistant message in the same way.
+					stepReasoning += event.Content
+					isFirst := firstChunk
+					c.sendJSON(OutgoingMessage{
+						Type:    "reasoning",
+						Content: event.Content,
+						IsFirst: &isFirst,
+					})
+					firstChunk = false
 				case "tool_call":
 					if event.ToolCall != nil {
-						toolCalls = append(toolCalls, *event.ToolCall)
 						c.sendJSON(OutgoingMessage{
 							Type: "tool_call",
 							ToolCall: &ToolCallMsg{
@@ -162,13 +295,97 @@ func (c *Client) readPump() {
 						})
 					}
 				case "tool_result":
+					var toolCallID string
+					if event.ToolCall != nil {
+						toolCallID = event.ToolCall.ID
+					}
 					c.sendJSON(OutgoingMessage{
 						Type: "tool_result",
 						ToolResult: &ToolResultMsg{
-							Content: event.Content,
-							IsError: event.Error != "",
+							ToolCallID: toolCallID,
+							Content:    event.Content,
+							IsError:    event.Error != "",
+							Metadata:   event.Metadata,
 						},
 					})
+				case "message":
+					// Keep history identical to what the model has seen,
+					// including tool results.
+					if event.Message != nil {
+						messages = append(messages, *event.Message)
+					}
+					stepContent, stepReasoning = "", ""
+				case "retrying":
+					retry := &RetryMsg{
+						Attempt:     metadataInt(event.Metadata, "attempt"),
+						MaxAttempts: metadataInt(event.Metadata, "max_attempts"),
+						DelayMs:     int64(metadataInt(event.Metadata, "delay_ms")),
+					}
+					if discard, _ := event.Metadata["discard_partial"].(bool); discard {
+						retry.Discarded, retry.DiscardedReasoning = stepContent, stepReasoning
+						stepContent, stepReasoning = "", ""
+					}
+					c.sendJSON(OutgoingMessage{
+						Type:  "retrying",
+						Error: event.Error,
+						Retry: retry,
+					})
+				case "fallback":
+					from, _ := event.Metadata["from"].(string)
+					to, _ := event.Metadata["to"].(string)
+					fallback := &FallbackMsg{From: from, To: to}
+					if discard, _ := event.Metadata["discard_partial"].(bool); discard {
+						fallback.Discarded, fallback.DiscardedReasoning = stepContent, stepReasoning
+						stepContent, stepReasoning = "", ""
+					}
+					c.sendJSON(OutgoingMessage{
+						Type:     "fallback",
+						Error:    event.Error,
+						Fallback: fallback,
+					})
+				case "usage":
+					if event.Usage == nil {
+						break
+					}
+					model, _ := event.Metadata["model"].(string)
+					turnUsage.Add(*event.Usage)
+					sessionUsage := sess.AddUsage(model, *event.Usage)
+					c.sendJSON(OutgoingMessage{
+						Type: "usage",
+						Usage: &UsageMsg{
+							SessionID: sess.ID,
+							Model:     model,
+							Step:      *event.Usage,
+							Turn:      turnUsage,
+							Session:   sessionUsage,
+						},
+					})
+				case "compacted":
+					messages = event.Messages
+					c.sendJSON(OutgoingMessage{
+						Type: "compacted",
+						Compaction: &CompactionMsg{
+							TokensBefore: metadataInt(event.Metadata, "tokens_before"),
+							TokensAfter:  metadataInt(event.Metadata, "tokens_after"),
+							Elided:       metadataInt(event.Metadata, "elided"),
+							Summarized:   metadataInt(event.Metadata, "summarized"),
+						},
+					})
+				case "step_limit":
+					c.sendJSON(OutgoingMessage{
+						Type:    "step_limit",
+						Content: event.Content,
+					})
+				case "schema_invalid":
+					c.sendJSON(OutgoingMessage{
+						Type:    "schema_invalid",
+						Content: event.Content,
+					})
+				case "structured":
+					c.sendJSON(OutgoingMessage{
+						Type:       "structured",
+						Structured: json.RawMessage(event.Content),
+					})
 				case "error":
 					c.sendError(event.Error)
 				case "done":
@@ -178,14 +395,40 @@ func (c *Client) readPump() {
 				}
 			}
 
-			// Add assistant response to history
-			if assistantContent != "" || len(toolCalls) > 0 {
-				messages = append(messages, llm.Message{
-					Role:      "assistant",
-					Content:   assistantContent,
-					ToolCalls: toolCalls,
-				})
+			sess.SetMessages(messages)
+			c.saveSession(sess)
+
+		case "profiles":
+			c.sendJSON(OutgoingMessage{
+				Type:     "profiles",
+				Profiles: c.hub.PromptProfiles(),
+			})
+
+		case "compact":
+			if sess == nil {
+				c.sendError("Nothing to compact")
+				continue
+			}
+			compacted, result, err := c.hub.llmClient.Compact(context.Background(), messages, c.hub.ToolDefinitions())
+			if result.Usage != nil {
+				sess.AddUsage(result.Model, *result.Usage)
+			}
+			if err != nil {
+				c.sendError(err.Error())
+				continue
 			}
+			messages = compacted
+			sess.SetMessages(messages)
+			c.saveSession(sess)
+			c.sendJSON(OutgoingMessage{
+				Type: "compacted",
+				Compaction: &CompactionMsg{
+					TokensBefore: result.TokensBefore,
+					TokensAfter:  result.TokensAfter,
+					Elided:       result.Elided,
+					Summarized:   result.Summarized,
+				},
+			})
 
 		case "cancel":
 			// TODO: Implement cancellation
@@ -244,6 +487,110 @@ func (c *Client) sendError(errMsg string) {
 	})
 }
 
+// generateTitle asks the title model for a short name for a conversation
+// that starts with prompt. The reply's content is empty on failure.
+func (c *Client) generateTitle(prompt string) llm.Message {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	reply, err := c.hub.llmClient.Complete(ctx, config.TaskTitle, []llm.Message{
+		{Role: "system", Content: titleSystemPrompt},
+		{Role: "user", Content: prompt},
+	})
+	if err != nil {
+		log.Printf("Title generation failed: %v", err)
+		return llm.Message{}
+	}
+	reply.Content = strings.Trim(strings.TrimSpace(reply.Content), `"'`)
+	return reply
+}
+
+// nameSession generates a title for sess, which starts with prompt, and
+// sends it to the client. The session is saved again so the title isn't
+// lost if the first turn was already over.
+func (c *Client) nameSession(sess *session.Session, prompt string) {
+	reply := c.generateTitle(prompt)
+	if reply.Usage != nil {
+		sess.AddUsage(reply.Model, *reply.Usage)
+	}
+	if reply.Content == "" {
+		return
+	}
+	sess.SetTitle(reply.Content)
+	c.saveSession(sess)
+	c.sendJSON(OutgoingMessage{
+		Type:    "title",
+		Content: reply.Content,
+	})
+}
+
+// startSession resumes the saved session with the given ID, or starts a new
+// one if id is empty or can't be loaded.
+func (c *Client) startSession(id string) *session.Session {
+	store := c.hub.Sessions()
+	if id != "" && store != nil {
+		sess, err := store.Load(id)
+		if err == nil {
+			return sess
+		}
+		log.Printf("Starting a new session: %v", err)
+	}
+	return session.New(c.hub.workingDir)
+}
+
+// saveSession writes sess to the session store, if there is one.
+func (c *Client) saveSession(sess *session.Session) {
+	store := c.hub.Sessions()
+	if store == nil {
+		return
+	}
+	if err := store.Save(sess); err != nil {
+		log.Printf("Failed to save session %s: %v", sess.ID, err)
+	}
+}
+
+// decodeAttachments turns prompt attachments into images for the model.
+func decodeAttachments(attachments []Attachment) ([]llm.Image, error) {
+	var images []llm.Image
+	for i, att := range attachments {
+		name := att.Name
+		if name == "" {
+			name = fmt.Sprintf("attachment %d", i+1)
+		}
+
+		data, err := base64.StdEncoding.DecodeString(att.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", name, err)
+		}
+		if len(data) > llm.MaxImageBytes {
+			return nil, fmt.Errorf("%s is too large: %d bytes, the limit is %d", name, len(data), llm.MaxImageBytes)
+		}
+
+		mediaType := att.MediaType
+		if mediaType == "" {
+			mediaType = http.DetectContentType(data)
+		}
+		if !llm.IsSupportedImageType(mediaType) {
+			return nil, fmt.Errorf("%s has unsupported type %s; use PNG, JPEG, GIF or WebP", name, mediaType)
+		}
+		images = append(images, llm.Image{MediaType: mediaType, Data: data})
+	}
+	return images, nil
+}
+
+// metadataInt reads an integer value from event metadata.
+func metadataInt(metadata map[string]interface{}, key string) int {
+	switch v := metadata[key].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
 func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -1,9 +1,16 @@
 package api
 
 import (
+	"fmt"
+	"log"
 	"os"
+	"sort"
+	"time"
+
 	"github.com/jack/klaudkod/backend/internal/config"
 	"github.com/jack/klaudkod/backend/internal/llm"
+	"github.com/jack/klaudkod/backend/internal/prompt"
+	"github.com/jack/klaudkod/backend/internal/session"
 	"github.com/jack/klaudkod/backend/internal/tools"
 )
 
@@ -15,16 +22,51 @@ type Hub struct {
 	register      chan *Client
 	unregister    chan *Client
 	toolRegistry  *tools.Registry
+	// sessions is nil when the session directory is unavailable, in which
+	// case conversations aren't saved.
+	sessions   *session.Store
+	workingDir string
+	profiles   *prompt.Profiles
 }
 
 func NewHub(cfg *config.Config) *Hub {
 	workingDir, _ := os.Getwd()
 	registry := tools.NewRegistry(workingDir, tools.PermissionModeAuto)
-	registry.Register(tools.NewReadFileTool(workingDir))
+
+	readTool := tools.NewReadFileTool(workingDir)
+	bashTool := tools.NewBashTool(workingDir)
+	bashTool.SetOutputLimits(cfg.BashMaxOutput, cfg.BashHeadLines, cfg.BashTailLines)
+	if cfg.BashSaveOutput {
+		if scratchDir, err := scratchDir(cfg); err != nil {
+			log.Printf("Scratch directory unavailable, full bash output will not be saved: %v", err)
+		} else {
+			bashTool.SetScratchDir(scratchDir, int64(cfg.ScratchMaxBytes), time.Duration(cfg.ScratchMaxAge)*time.Hour)
+			readTool.AllowDir(scratchDir)
+		}
+	}
+
+	registry.Register(readTool)
 	registry.Register(tools.NewWriteFileTool(workingDir))
+	registry.Register(tools.NewNotebookEditTool(workingDir))
 	registry.Register(tools.NewGlobTool(workingDir))
 	registry.Register(tools.NewGrepTool(workingDir))
-	registry.Register(tools.NewBashTool(workingDir))
+	registry.Register(tools.NewLsTool(workingDir))
+	registry.Register(tools.NewOutlineTool(workingDir))
+	registry.Register(bashTool)
+
+	sessions, err := session.NewStore(cfg.SessionDir)
+	if err != nil {
+		log.Printf("Sessions will not be saved: %v", err)
+	}
+
+	profiles, err := prompt.LoadProfiles(cfg.PromptDir)
+	if err != nil {
+		log.Printf("Some prompt profiles were not loaded: %v", err)
+	}
+	if !profiles.Has(cfg.PromptProfile) {
+		log.Printf("Unknown prompt profile %q, using %q", cfg.PromptProfile, prompt.DefaultProfile)
+		cfg.PromptProfile = prompt.DefaultProfile
+	}
 	
 	return &Hub{
 		config:       cfg,
@@ -34,6 +76,9 @@ func NewHub(cfg *config.Config) *Hub {
 		unregister:   make(chan *Client),
 		clients:      make(map[*Client]bool),
 		toolRegistry: registry,
+		sessions:     sessions,
+		workingDir:   workingDir,
+		profiles:     profiles,
 	}
 }
 
@@ -62,4 +107,56 @@ func (h *Hub) Run() {
 
 func (h *Hub) ToolRegistry() *tools.Registry {
 	return h.toolRegistry
-}
\ No newline at end of file
+}
+
+// Sessions returns the session store, or nil if sessions aren't saved.
+func (h *Hub) Sessions() *session.Store {
+	return h.sessions
+}
+
+// SystemPrompt renders a system prompt profile for a new conversation,
+// filling in the environment and any instruction files for the working
+// directory.
+func (h *Hub) SystemPrompt(profile string) (string, error) {
+	names := h.toolRegistry.List()
+	sort.Strings(names)
+
+	env := prompt.DetectEnvironment(h.workingDir, names)
+	instructions := prompt.FindInstructions(h.workingDir, h.config.DataDir, prompt.DefaultMaxFileBytes, h.config.InstructionsMaxBytes)
+	for _, inst := range instructions {
+		log.Printf("Using instructions from %s", inst.Path)
+	}
+	return h.profiles.Render(profile, prompt.NewData(env, instructions))
+}
+
+// PromptProfiles returns the names of the available system prompt profiles.
+func (h *Hub) PromptProfiles() []string {
+	return h.profiles.Names()
+}
+
+// ToolDefinitions describes the registered tools for the LLM, sorted by name
+// so that requests are stable across calls.
+func (h *Hub) ToolDefinitions() []llm.ToolDefinition {
+	names := h.toolRegistry.List()
+	sort.Strings(names)
+
+	defs := make([]llm.ToolDefinition, 0, len(names))
+	for _, name := range names {
+		tool, _ := h.toolRegistry.Get(name)
+		defs = append(defs, llm.ToolDefinition{
+			Name:        tool.Name(),
+			Description: tool.Description(),
+			Parameters:  tool.Parameters(),
+		})
+	}
+	return defs
+}
+
+// scratchDir returns the directory for oversized tool output, creating it
+// if needed.
+func scratchDir(cfg *config.Config) (string, error) {
+	if cfg.ScratchDir == "" {
+		return "", fmt.Errorf("no scratch directory configured")
+	}
+	return cfg.ScratchDir, os.MkdirAll(cfg.ScratchDir, 0755)
+}
@@ -1,32 +1,153 @@
 package config
 
 import (
+	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 )
 
 type Config struct {
-	LLMBaseURL       string
-	LLMAPIKey        string
-	LLMModel         string
-	ServerPort       string
-	ToolsEnabled     bool
-	PermissionMode   string
-	CommandTimeout   int
-	WorkingDirectory string
+	// LLMProfile names the Profile the LLM settings were defaulted from.
+	LLMProfile string
+	// LLMProvider selects the model API: "openai" for OpenAI-compatible chat
+	// completions or "anthropic" for the Anthropic Messages API.
+	LLMProvider  string
+	LLMBaseURL   string
+	LLMAPIKey    string
+	LLMModel     string
+	LLMMaxTokens int
+	// LLMToolCallsInContent makes the client look for tool calls written as
+	// JSON in the reply text, for servers that don't fill in tool_calls.
+	LLMToolCallsInContent bool
+	// Retries for transient LLM errors. LLMMaxAttempts counts the first
+	// attempt; delays are in milliseconds.
+	LLMMaxAttempts    int
+	LLMRetryBaseDelay int
+	LLMRetryMaxDelay  int
+	// LLMFallbacks are tried in order when the main model is unavailable,
+	// over quota or keeps failing.
+	LLMFallbacks []ModelSpec
+	// LLMRoutes sends background tasks such as title generation to a
+	// cheaper model, keyed by task name.
+	LLMRoutes map[string]ModelSpec
+	// LLMPriceTable is a JSON file of per-model prices that extends and
+	// overrides the built-in ones.
+	LLMPriceTable string
+	// LLMContextWindow overrides the main model's context length in tokens.
+	// Conversations are compacted once a request would fill
+	// LLMCompactThreshold percent of it.
+	LLMContextWindow    int
+	LLMCompactThreshold int
+	// LLMMaxSteps caps the model requests in one turn, and
+	// LLMMaxRepeatedCalls how often the same tool call may run in a row.
+	// Zero disables the limit.
+	LLMMaxSteps         int
+	LLMMaxRepeatedCalls int
+	// LLMReasoningEffort asks reasoning models to think "low", "medium" or
+	// "high"; empty leaves the model's default, which for Anthropic models
+	// means no extended thinking.
+	LLMReasoningEffort string
+	ServerPort         string
+	ToolsEnabled       bool
+	PermissionMode     string
+	CommandTimeout     int
+	WorkingDirectory   string
+	// Bash output truncation. Output beyond BashMaxOutput bytes or
+	// BashHeadLines+BashTailLines lines keeps only its head and tail.
+	BashMaxOutput  int
+	BashHeadLines  int
+	BashTailLines  int
+	BashSaveOutput bool
+	// ScratchDir holds tool output that was too large to return inline.
+	// Files older than ScratchMaxAge hours are removed, and the oldest go
+	// first once the directory grows past ScratchMaxBytes.
+	ScratchDir      string
+	ScratchMaxBytes int
+	ScratchMaxAge   int
+	// SessionDir holds saved conversations together with their usage.
+	SessionDir string
+	// DataDir holds klaudkod's own files, including the user's instruction
+	// file. InstructionsMaxBytes caps the instruction files added to the
+	// system prompt.
+	DataDir              string
+	InstructionsMaxBytes int
+	// PromptDir holds system prompt profiles as <name>.tmpl files, and
+	// PromptProfile is the one used when a session doesn't pick one.
+	PromptDir     string
+	PromptProfile string
 }
 
 func Load() *Config {
-	return &Config{
-		LLMBaseURL:       getEnv("LLM_BASE_URL", "https://api.openai.com/v1"),
-		LLMAPIKey:        getEnv("LLM_API_KEY", ""),
-		LLMModel:         getEnv("LLM_MODEL", "gpt-4"),
-		ServerPort:       getEnv("SERVER_PORT", "8080"),
-		ToolsEnabled:     getEnvBool("TOOLS_ENABLED", true),
-		PermissionMode:   getEnv("PERMISSION_MODE", "auto"),
-		CommandTimeout:   getEnvInt("COMMAND_TIMEOUT", 120),
-		WorkingDirectory: getEnv("WORKING_DIR", ""),
+	profileName := getEnv("LLM_PROFILE", getEnv("LLM_PROVIDER", "openai"))
+	profile, ok := LookupProfile(profileName)
+	if !ok {
+		log.Printf("Unknown LLM profile %q, using openai defaults", profileName)
+		profile, _ = LookupProfile("openai")
 	}
+
+	dataDir := defaultDataDir()
+	cfg := &Config{
+		LLMProfile:            profileName,
+		LLMProvider:           getEnv("LLM_PROVIDER", profile.Provider),
+		LLMBaseURL:            getEnv("LLM_BASE_URL", profile.BaseURL),
+		LLMAPIKey:             getEnv("LLM_API_KEY", profile.APIKey),
+		LLMModel:              getEnv("LLM_MODEL", profile.Model),
+		LLMMaxTokens:          getEnvInt("LLM_MAX_TOKENS", 8192),
+		LLMToolCallsInContent: getEnvBool("LLM_TOOL_CALLS_IN_CONTENT", profile.ToolCallsInContent),
+		LLMMaxAttempts:        getEnvInt("LLM_MAX_ATTEMPTS", 5),
+		LLMRetryBaseDelay:     getEnvInt("LLM_RETRY_BASE_DELAY_MS", 1000),
+		LLMRetryMaxDelay:      getEnvInt("LLM_RETRY_MAX_DELAY_MS", 30000),
+		ServerPort:            getEnv("SERVER_PORT", "8080"),
+		ToolsEnabled:          getEnvBool("TOOLS_ENABLED", true),
+		PermissionMode:        getEnv("PERMISSION_MODE", "auto"),
+		CommandTimeout:        getEnvInt("COMMAND_TIMEOUT", 120),
+		WorkingDirectory:      getEnv("WORKING_DIR", ""),
+		BashMaxOutput:         getEnvInt("BASH_MAX_OUTPUT", 30000),
+		BashHeadLines:         getEnvInt("BASH_HEAD_LINES", 100),
+		BashTailLines:         getEnvInt("BASH_TAIL_LINES", 100),
+		BashSaveOutput:        getEnvBool("BASH_SAVE_OUTPUT", true),
+		ScratchDir:            getEnv("SCRATCH_DIR", filepath.Join(dataDir, "scratch")),
+		ScratchMaxBytes:       getEnvInt("SCRATCH_MAX_BYTES", 256*1024*1024),
+		ScratchMaxAge:         getEnvInt("SCRATCH_MAX_AGE_HOURS", 24),
+		LLMPriceTable:         getEnv("LLM_PRICE_TABLE", filepath.Join(dataDir, "prices.json")),
+		LLMContextWindow:      getEnvInt("LLM_CONTEXT_WINDOW", 0),
+		LLMCompactThreshold:   getEnvInt("LLM_COMPACT_THRESHOLD", 80),
+		LLMMaxSteps:           getEnvInt("LLM_MAX_STEPS", 50),
+		LLMMaxRepeatedCalls:   getEnvInt("LLM_MAX_REPEATED_CALLS", 3),
+		LLMReasoningEffort:    getEnv("LLM_REASONING_EFFORT", ""),
+		SessionDir:            getEnv("SESSION_DIR", filepath.Join(dataDir, "sessions")),
+		DataDir:               dataDir,
+		InstructionsMaxBytes:  getEnvInt("INSTRUCTIONS_MAX_BYTES", 48*1024),
+		PromptDir:             getEnv("PROMPT_DIR", filepath.Join(dataDir, "prompts")),
+		PromptProfile:         getEnv("PROMPT_PROFILE", "default"),
+	}
+
+	switch cfg.LLMReasoningEffort {
+	case "", "low", "medium", "high":
+	default:
+		log.Printf("Unknown LLM_REASONING_EFFORT %q, using the model's default", cfg.LLMReasoningEffort)
+		cfg.LLMReasoningEffort = ""
+	}
+
+	primary := cfg.PrimaryModel()
+	cfg.LLMFallbacks = parseModelList(getEnv("LLM_FALLBACK_MODELS", ""), primary)
+	cfg.LLMRoutes = parseRoutes(primary)
+
+	return cfg
+}
+
+// defaultDataDir is where klaudkod keeps its own files: KLAUDKOD_DATA_DIR,
+// or a klaudkod directory under the user's config directory.
+func defaultDataDir() string {
+	if dir := os.Getenv("KLAUDKOD_DATA_DIR"); dir != "" {
+		return dir
+	}
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return ".klaudkod"
+	}
+	return filepath.Join(base, "klaudkod")
 }
 
 func getEnv(key, defaultValue string) string {
@@ -52,4 +173,4 @@ func getEnvInt(key string, defaultValue int) int {
 		}
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
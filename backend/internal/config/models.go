@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Tasks that can be routed to a model other than the main one.
+const (
+	TaskTitle   = "title"
+	TaskSummary = "summary"
+)
+
+// ModelSpec identifies a model and the server that hosts it.
+type ModelSpec struct {
+	Provider           string
+	BaseURL            string
+	APIKey             string
+	Model              string
+	ToolCallsInContent bool
+	// ContextWindow is the model's context length in tokens; zero means
+	// the built-in value for the model.
+	ContextWindow int
+	// ReasoningEffort is "low", "medium" or "high" for models that can
+	// think before answering, or empty for the model's default.
+	ReasoningEffort string
+}
+
+// PrimaryModel returns the main model configured through the LLM_* variables.
+func (c *Config) PrimaryModel() ModelSpec {
+	return ModelSpec{
+		Provider:           c.LLMProvider,
+		BaseURL:            c.LLMBaseURL,
+		APIKey:             c.LLMAPIKey,
+		Model:              c.LLMModel,
+		ToolCallsInContent: c.LLMToolCallsInContent,
+		ContextWindow:      c.LLMContextWindow,
+		ReasoningEffort:    c.LLMReasoningEffort,
+	}
+}
+
+// ParseModelSpec parses "profile:model" or a bare "model". A bare model is
+// served by the same server as primary. A profile prefix uses that profile's
+// server, whose API key and base URL can be set with <PROFILE>_API_KEY and
+// <PROFILE>_BASE_URL, e.g. ANTHROPIC_API_KEY.
+func ParseModelSpec(s string, primary ModelSpec) (ModelSpec, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ModelSpec{}, fmt.Errorf("empty model")
+	}
+
+	name, model, found := strings.Cut(s, ":")
+	if !found {
+		spec := primary
+		spec.Model = s
+		spec.ContextWindow = 0
+		return spec, nil
+	}
+
+	profile, ok := LookupProfile(name)
+	if !ok {
+		return ModelSpec{}, fmt.Errorf("unknown profile %q in %q", name, s)
+	}
+	if model == "" {
+		model = profile.Model
+	}
+
+	prefix := strings.ToUpper(name)
+	return ModelSpec{
+		Provider:           profile.Provider,
+		BaseURL:            getEnv(prefix+"_BASE_URL", profile.BaseURL),
+		APIKey:             getEnv(prefix+"_API_KEY", profile.APIKey),
+		Model:              model,
+		ToolCallsInContent: profile.ToolCallsInContent,
+	}, nil
+}
+
+// parseModelList parses a comma-separated list of model specs, skipping
+// and logging invalid entries.
+func parseModelList(list string, primary ModelSpec) []ModelSpec {
+	var specs []ModelSpec
+	for _, entry := range strings.Split(list, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		spec, err := ParseModelSpec(entry, primary)
+		if err != nil {
+			log.Printf("Ignoring fallback model: %v", err)
+			continue
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// parseRoutes reads the per-task model routes from LLM_ROUTE_<TASK>.
+func parseRoutes(primary ModelSpec) map[string]ModelSpec {
+	routes := make(map[string]ModelSpec)
+	for _, task := range []string{TaskTitle, TaskSummary} {
+		value := getEnv("LLM_ROUTE_"+strings.ToUpper(task), "")
+		if value == "" {
+			continue
+		}
+		spec, err := ParseModelSpec(value, primary)
+		if err != nil {
+			log.Printf("Ignoring %s route: %v", task, err)
+			continue
+		}
+		routes[task] = spec
+	}
+	return routes
+}
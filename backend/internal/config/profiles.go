@@ -0,0 +1,50 @@
+package config
+
+// Profile holds the defaults for a family of model servers. Any value can
+// still be overridden through its own environment variable.
+type Profile struct {
+	Provider string
+	BaseURL  string
+	APIKey   string
+	Model    string
+	// ToolCallsInContent enables recovering tool calls that the server
+	// wrote into the message text instead of the tool_calls field.
+	ToolCallsInContent bool
+}
+
+// profiles are selected with LLM_PROFILE. Local servers get a placeholder API
+// key because they ignore it but some clients refuse to send an empty one.
+var profiles = map[string]Profile{
+	"openai": {
+		Provider: "openai",
+		BaseURL:  "https://api.openai.com/v1",
+		Model:    "gpt-4",
+	},
+	"anthropic": {
+		Provider: "anthropic",
+		BaseURL:  "https://api.anthropic.com/v1",
+		Model:    "claude-sonnet-4-5",
+	},
+	"ollama": {
+		Provider:           "openai",
+		BaseURL:            "http://localhost:11434/v1",
+		APIKey:             "ollama",
+		Model:              "qwen2.5-coder",
+		ToolCallsInContent: true,
+	},
+	// llama-server listens on 8080 by default, which is also our own port,
+	// so the profile expects it to be started with --port 8081.
+	"llamacpp": {
+		Provider:           "openai",
+		BaseURL:            "http://localhost:8081/v1",
+		APIKey:             "llamacpp",
+		Model:              "default",
+		ToolCallsInContent: true,
+	},
+}
+
+// LookupProfile returns the named profile and whether it exists.
+func LookupProfile(name string) (Profile, bool) {
+	profile, ok := profiles[name]
+	return profile, ok
+}
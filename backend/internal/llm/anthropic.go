@@ -0,0 +1,467 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/jack/klaudkod/backend/internal/config"
+)
+
+const anthropicVersion = "2023-06-01"
+
+// anthropicProvider talks to the Anthropic Messages API.
+type anthropicProvider struct {
+	baseURL   string
+	apiKey    string
+	maxTokens int
+	// thinkingBudget enables extended thinking with this many tokens;
+	// zero leaves it off.
+	thinkingBudget int
+	httpClient     *http.Client
+}
+
+func newAnthropicProvider(spec config.ModelSpec, maxTokens int) *anthropicProvider {
+	return &anthropicProvider{
+		baseURL:        strings.TrimRight(spec.BaseURL, "/"),
+		apiKey:         spec.APIKey,
+		maxTokens:      maxTokens,
+		thinkingBudget: anthropicThinkingBudgets[spec.ReasoningEffort],
+		httpClient:     http.DefaultClient,
+	}
+}
+
+// anthropicThinkingBudgets maps reasoning efforts to extended thinking
+// budgets, in tokens.
+var anthropicThinkingBudgets = map[string]int{
+	"low":    2048,
+	"medium": 8192,
+	"high":   24576,
+}
+
+type anthropicRequest struct {
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	System    string             `json:"system,omitempty"`
+	Messages  []anthropicMessage `json:"messages"`
+	Tools     []anthropicTool    `json:"tools,omitempty"`
+	Thinking  *anthropicThinking `json:"thinking,omitempty"`
+	Stream    bool               `json:"stream"`
+}
+
+type anthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	// Content is a tool result's text, or a []anthropicBlock when the
+	// result includes images.
+	Content   interface{}           `json:"content,omitempty"`
+	Source    *anthropicImageSource `json:"source,omitempty"`
+	Thinking  string                `json:"thinking,omitempty"`
+	Signature string                `json:"signature,omitempty"`
+	// Data is the encrypted content of a redacted_thinking block.
+	Data    string `json:"data,omitempty"`
+	IsError bool   `json:"is_error,omitempty"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type anthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+type anthropicUsage struct {
+	InputTokens              int64 `json:"input_tokens"`
+	CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+	OutputTokens             int64 `json:"output_tokens"`
+}
+
+// anthropicEvent is the union of the server-sent events in a streamed
+// Messages API response.
+type anthropicEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Model string         `json:"model"`
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Index        int            `json:"index"`
+	ContentBlock anthropicBlock `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		Thinking    string `json:"thinking"`
+		Signature   string `json:"signature"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage anthropicUsage `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *anthropicProvider) Stream(ctx context.Context, req Request, eventChan chan<- StreamEvent) (Response, error) {
+	body, err := json.Marshal(p.buildRequest(req))
+	if err != nil {
+		return Response{}, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return Response{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	httpReq.Header.Set("X-Api-Key", p.apiKey)
+	httpReq.Header.Set("Anthropic-Version", anthropicVersion)
+
+	httpResp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return Response{}, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return Response{}, anthropicHTTPError(httpResp)
+	}
+
+	return p.readStream(httpResp.Body, eventChan)
+}
+
+// buildRequest converts a Request into the Messages API format. System
+// messages move to the top-level system field and tool results become
+// tool_result blocks in a user turn, as the API requires.
+func (p *anthropicProvider) buildRequest(req Request) anthropicRequest {
+	var systemParts []string
+	var messages []anthropicMessage
+
+	appendBlocks := func(role string, blocks ...anthropicBlock) {
+		if len(blocks) == 0 {
+			return
+		}
+		// The API expects alternating roles, so merge consecutive turns.
+		if n := len(messages); n > 0 && messages[n-1].Role == role {
+			messages[n-1].Content = append(messages[n-1].Content, blocks...)
+			return
+		}
+		messages = append(messages, anthropicMessage{Role: role, Content: blocks})
+	}
+
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case "system":
+			systemParts = append(systemParts, msg.Content)
+		case "assistant":
+			var blocks []anthropicBlock
+			// Thinking has to be sent back with its signature for the
+			// model to carry on after a tool call, and redacted thinking
+			// as it came. Reasoning from other providers has no signature
+			// and can't be.
+			for _, rb := range msg.ReasoningBlocks {
+				if rb.Redacted != "" {
+					blocks = append(blocks, anthropicBlock{Type: "redacted_thinking", Data: rb.Redacted})
+					continue
+				}
+				if rb.Signature == "" {
+					continue
+				}
+				blocks = append(blocks, anthropicBlock{
+					Type:      "thinking",
+					Thinking:  rb.Text,
+					Signature: rb.Signature,
+				})
+			}
+			if msg.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: msg.Content})
+			}
+			for _, tc := range msg.ToolCalls {
+				input := json.RawMessage(tc.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicBlock{
+					Type:  "tool_use",
+					ID:    tc.ID,
+					Name:  tc.Name,
+					Input: input,
+				})
+			}
+			appendBlocks("assistant", blocks...)
+		case "tool":
+			result := anthropicBlock{
+				Type:      "tool_result",
+				ToolUseID: msg.ToolCallID,
+				Content:   msg.Content,
+				IsError:   msg.IsError,
+			}
+			if len(msg.Images) > 0 {
+				result.Content = anthropicContentBlocks(msg)
+			}
+			appendBlocks("user", result)
+		default:
+			appendBlocks("user", anthropicContentBlocks(msg)...)
+		}
+	}
+
+	// The Messages API has no response format, so the schema is given as
+	// an instruction and the answer is checked by Client.
+	if req.ResponseSchema != nil {
+		systemParts = append(systemParts, req.ResponseSchema.instruction())
+	}
+
+	var tools []anthropicTool
+	for _, tool := range req.Tools {
+		tools = append(tools, anthropicTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.Parameters,
+		})
+	}
+
+	request := anthropicRequest{
+		Model:     req.Model,
+		MaxTokens: p.maxTokens,
+		System:    strings.Join(systemParts, "\n\n"),
+		Messages:  messages,
+		Tools:     tools,
+		Stream:    true,
+	}
+	// The thinking budget counts toward max_tokens, so add it on top to
+	// leave the reply its usual room.
+	if p.thinkingBudget > 0 {
+		request.Thinking = &anthropicThinking{Type: "enabled", BudgetTokens: p.thinkingBudget}
+		request.MaxTokens += p.thinkingBudget
+	}
+	return request
+}
+
+// anthropicContentBlocks converts a message's text and images to blocks.
+func anthropicContentBlocks(msg Message) []anthropicBlock {
+	var blocks []anthropicBlock
+	if msg.Content != "" {
+		blocks = append(blocks, anthropicBlock{Type: "text", Text: msg.Content})
+	}
+	for _, img := range msg.Images {
+		blocks = append(blocks, anthropicBlock{
+			Type: "image",
+			Source: &anthropicImageSource{
+				Type:      "base64",
+				MediaType: img.MediaType,
+				Data:      img.base64(),
+			},
+		})
+	}
+	return blocks
+}
+
+// readStream consumes the server-sent events of a streamed response and
+// assembles the content blocks into a Response.
+func (p *anthropicProvider) readStream(body io.Reader, eventChan chan<- StreamEvent) (Response, error) {
+	type blockState struct {
+		block anthropicBlock
+		// args collects a tool call's input, or a thinking block's text.
+		args      strings.Builder
+		signature strings.Builder
+	}
+
+	var blocks []*blockState
+	var resp Response
+	var content strings.Builder
+	var reasoning strings.Builder
+	stopped := false
+
+	err := readSSE(body, func(data []byte) error {
+		var event anthropicEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("failed to decode stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			resp.Model = event.Message.Model
+			// input_tokens excludes cached tokens, which are counted
+			// separately and are part of the prompt.
+			u := event.Message.Usage
+			resp.Usage.PromptTokens = u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+			resp.Usage.CachedTokens = u.CacheReadInputTokens
+			resp.Usage.CompletionTokens = u.OutputTokens
+		case "content_block_start":
+			for len(blocks) <= event.Index {
+				blocks = append(blocks, &blockState{})
+			}
+			blocks[event.Index].block = event.ContentBlock
+			// Separate the thinking blocks in the reasoning shown to the
+			// user.
+			if event.ContentBlock.Type == "thinking" && reasoning.Len() > 0 {
+				reasoning.WriteString("\n\n")
+				eventChan <- StreamEvent{
+					Type:    "reasoning",
+					Content: "\n\n",
+				}
+			}
+		case "content_block_delta":
+			if event.Index >= len(blocks) {
+				return fmt.Errorf("delta for unknown content block %d", event.Index)
+			}
+			state := blocks[event.Index]
+			switch event.Delta.Type {
+			case "text_delta":
+				content.WriteString(event.Delta.Text)
+				eventChan <- StreamEvent{
+					Type:    "chunk",
+					Content: event.Delta.Text,
+				}
+			case "input_json_delta":
+				state.args.WriteString(event.Delta.PartialJSON)
+			case "thinking_delta":
+				state.args.WriteString(event.Delta.Thinking)
+				reasoning.WriteString(event.Delta.Thinking)
+				eventChan <- StreamEvent{
+					Type:    "reasoning",
+					Content: event.Delta.Thinking,
+				}
+			case "signature_delta":
+				state.signature.WriteString(event.Delta.Signature)
+			}
+		case "message_delta":
+			if event.Delta.StopReason != "" {
+				resp.StopReason = event.Delta.StopReason
+			}
+			// output_tokens here is cumulative for the message.
+			if event.Usage.OutputTokens > 0 {
+				resp.Usage.CompletionTokens = event.Usage.OutputTokens
+			}
+		case "message_stop":
+			stopped = true
+		case "error":
+			return &APIError{
+				Type:    event.Error.Type,
+				Message: event.Error.Message,
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Response{}, err
+	}
+	// A stream cut off before message_stop would otherwise pass for a
+	// complete reply, with its text or tool input cut short.
+	if !stopped {
+		return Response{}, fmt.Errorf("stream ended before message_stop: %w", io.ErrUnexpectedEOF)
+	}
+
+	for _, state := range blocks {
+		if state.block.Type == "redacted_thinking" {
+			resp.ReasoningBlocks = append(resp.ReasoningBlocks, ReasoningBlock{Redacted: state.block.Data})
+			continue
+		}
+		if state.block.Type == "thinking" {
+			resp.ReasoningBlocks = append(resp.ReasoningBlocks, ReasoningBlock{
+				Text:      state.args.String(),
+				Signature: state.signature.String(),
+			})
+			continue
+		}
+		if state.block.Type != "tool_use" {
+			continue
+		}
+		args := state.args.String()
+		if args == "" {
+			args = "{}"
+		}
+		resp.ToolCalls = append(resp.ToolCalls, ToolCall{
+			ID:        state.block.ID,
+			Name:      state.block.Name,
+			Arguments: args,
+		})
+	}
+	resp.Content = content.String()
+	resp.Reasoning = reasoning.String()
+
+	return resp, nil
+}
+
+// readSSE calls handle with the data of every event in a server-sent event
+// stream. Multi-line data fields are joined with newlines.
+func readSSE(body io.Reader, handle func(data []byte) error) error {
+	reader := bufio.NewReader(body)
+	var data bytes.Buffer
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		trimmed := strings.TrimRight(line, "\r\n")
+		switch {
+		case trimmed == "":
+			if data.Len() > 0 {
+				if herr := handle(data.Bytes()); herr != nil {
+					return herr
+				}
+				data.Reset()
+			}
+		case strings.HasPrefix(trimmed, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(trimmed, "data:"), " "))
+		}
+
+		if err == io.EOF {
+			if data.Len() > 0 {
+				return handle(data.Bytes())
+			}
+			return nil
+		}
+	}
+}
+
+func anthropicHTTPError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	var payload struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+		RetryAfter: parseRetryAfter(resp.Header),
+	}
+	if err := json.Unmarshal(body, &payload); err == nil && payload.Error.Message != "" {
+		apiErr.Type = payload.Error.Type
+		apiErr.Message = payload.Error.Type + ": " + payload.Error.Message
+	}
+
+	return apiErr
+}
@@ -0,0 +1,264 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/jack/klaudkod/backend/internal/config"
+)
+
+// replayServer serves recorded SSE streams from testdata, one per request in
+// order, and keeps the decoded request bodies for inspection.
+type replayServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []anthropicRequest
+}
+
+func newReplayServer(t *testing.T, files ...string) *replayServer {
+	t.Helper()
+
+	rs := &replayServer{}
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/messages" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("X-Api-Key") != "test-key" || r.Header.Get("Anthropic-Version") != anthropicVersion {
+			http.Error(w, `{"type":"error","error":{"type":"authentication_error","message":"bad headers"}}`, http.StatusUnauthorized)
+			return
+		}
+
+		var req anthropicRequest
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rs.mu.Lock()
+		n := len(rs.requests)
+		rs.requests = append(rs.requests, req)
+		rs.mu.Unlock()
+
+		if n >= len(files) {
+			http.Error(w, "no more recorded responses", http.StatusInternalServerError)
+			return
+		}
+		data, err := os.ReadFile(filepath.Join("testdata", files[n]))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write(data)
+	}))
+	t.Cleanup(rs.Close)
+	return rs
+}
+
+func newTestAnthropicClient(url string) *Client {
+	return NewClient(&config.Config{
+		LLMProvider:  "anthropic",
+		LLMBaseURL:   url,
+		LLMAPIKey:    "test-key",
+		LLMModel:     "claude-sonnet-4-5",
+		LLMMaxTokens: 1024,
+	})
+}
+
+func collectEvents(ch <-chan StreamEvent) []StreamEvent {
+	var events []StreamEvent
+	for event := range ch {
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestAnthropicProvider_StreamText(t *testing.T) {
+	server := newReplayServer(t, "anthropic_text.sse")
+	client := newTestAnthropicClient(server.URL)
+
+	eventChan := make(chan StreamEvent)
+	go client.Stream(context.Background(), []Message{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "Say hello"},
+	}, eventChan)
+
+	var text string
+	var done bool
+	for _, event := range collectEvents(eventChan) {
+		switch event.Type {
+		case "chunk":
+			text += event.Content
+		case "done":
+			done = true
+		case "error":
+			t.Fatalf("unexpected error event: %s", event.Error)
+		}
+	}
+
+	if text != "Hello, world!" {
+		t.Errorf("streamed text = %q, want %q", text, "Hello, world!")
+	}
+	if !done {
+		t.Error("missing done event")
+	}
+
+	req := server.requests[0]
+	if req.System != "Be brief." {
+		t.Errorf("system = %q, want top-level system prompt", req.System)
+	}
+	if len(req.Messages) != 1 || req.Messages[0].Role != "user" {
+		t.Fatalf("messages = %+v, want a single user message", req.Messages)
+	}
+	if !req.Stream || req.MaxTokens != 1024 {
+		t.Errorf("stream = %v, max_tokens = %d", req.Stream, req.MaxTokens)
+	}
+}
+
+func TestAnthropicProvider_ToolUse(t *testing.T) {
+	server := newReplayServer(t, "anthropic_tool_use.sse")
+	provider := newTestAnthropicClient(server.URL).models[0].provider
+
+	eventChan := make(chan StreamEvent, 16)
+	resp, err := provider.Stream(context.Background(), Request{
+		Model:    "claude-sonnet-4-5",
+		Messages: []Message{{Role: "user", Content: "Find the Go files"}},
+		Tools: []ToolDefinition{{
+			Name:        "read",
+			Description: "Read a file",
+			Parameters:  map[string]interface{}{"type": "object"},
+		}},
+	}, eventChan)
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	if resp.Content != "Let me look at that file." {
+		t.Errorf("content = %q", resp.Content)
+	}
+	if resp.StopReason != "tool_use" {
+		t.Errorf("stop reason = %q, want tool_use", resp.StopReason)
+	}
+
+	want := []ToolCall{
+		{ID: "toolu_01T1x1fJ34qAmk2tNTrN7Up6", Name: "read", Arguments: `{"filePath": "main.go"}`},
+		{ID: "toolu_01VWzCvjB8NVDjqfFTyZ7dDt", Name: "glob", Arguments: `{"pattern": "**/*.go"}`},
+	}
+	if len(resp.ToolCalls) != len(want) {
+		t.Fatalf("got %d tool calls, want %d: %+v", len(resp.ToolCalls), len(want), resp.ToolCalls)
+	}
+	for i := range want {
+		if resp.ToolCalls[i] != want[i] {
+			t.Errorf("tool call %d = %+v, want %+v", i, resp.ToolCalls[i], want[i])
+		}
+	}
+
+	if tools := server.requests[0].Tools; len(tools) != 1 || tools[0].Name != "read" || tools[0].InputSchema["type"] != "object" {
+		t.Errorf("tools = %+v", tools)
+	}
+}
+
+func TestAnthropicProvider_AgenticLoop(t *testing.T) {
+	server := newReplayServer(t, "anthropic_tool_use.sse", "anthropic_text.sse")
+	client := newTestAnthropicClient(server.URL)
+
+	var executed []string
+	executor := func(name, argsJSON string) ToolOutput {
+		executed = append(executed, name)
+		// The first call fails.
+		return ToolOutput{Content: "result of " + name, IsError: len(executed) == 1}
+	}
+
+	eventChan := make(chan StreamEvent)
+	go client.StreamWithTools(context.Background(), []Message{
+		{Role: "system", Content: "You are a coding agent."},
+		{Role: "user", Content: "Find the Go files"},
+	}, nil, executor, eventChan)
+
+	events := collectEvents(eventChan)
+	if last := events[len(events)-1]; last.Type != "done" {
+		t.Fatalf("last event = %+v, want done", last)
+	}
+	if len(executed) != 2 {
+		t.Fatalf("executed %v, want two tool calls", executed)
+	}
+
+	// The second request must carry the tool_use blocks and answer them with
+	// tool_result blocks in a single user turn.
+	req := server.requests[1]
+	if len(req.Messages) != 3 {
+		t.Fatalf("second request has %d messages, want 3: %+v", len(req.Messages), req.Messages)
+	}
+	assistant, results := req.Messages[1], req.Messages[2]
+	if assistant.Role != "assistant" || len(assistant.Content) != 3 || assistant.Content[1].Type != "tool_use" {
+		t.Errorf("assistant turn = %+v", assistant)
+	}
+	if results.Role != "user" || len(results.Content) != 2 {
+		t.Fatalf("tool result turn = %+v", results)
+	}
+	for i, block := range results.Content {
+		if block.Type != "tool_result" || block.ToolUseID != assistant.Content[i+1].ID || block.IsError != (i == 0) {
+			t.Errorf("tool result %d = %+v", i, block)
+		}
+	}
+}
+
+func TestAnthropicProvider_Errors(t *testing.T) {
+	t.Run("stream error event", func(t *testing.T) {
+		server := newReplayServer(t, "anthropic_overloaded.sse")
+		provider := newTestAnthropicClient(server.URL).models[0].provider
+
+		_, err := provider.Stream(context.Background(), Request{
+			Messages: []Message{{Role: "user", Content: "hi"}},
+		}, make(chan StreamEvent, 16))
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("stream cut off", func(t *testing.T) {
+		server := newReplayServer(t, "anthropic_truncated.sse")
+		provider := newTestAnthropicClient(server.URL).models[0].provider
+
+		_, err := provider.Stream(context.Background(), Request{
+			Messages: []Message{{Role: "user", Content: "hi"}},
+		}, make(chan StreamEvent, 16))
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("error = %v, want io.ErrUnexpectedEOF", err)
+		}
+		if retryable, _ := isRetryable(err); !retryable {
+			t.Error("a stream cut off should be retried")
+		}
+	})
+
+	t.Run("http status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`))
+		}))
+		defer server.Close()
+		provider := newTestAnthropicClient(server.URL).models[0].provider
+
+		_, err := provider.Stream(context.Background(), Request{
+			Messages: []Message{{Role: "user", Content: "hi"}},
+		}, make(chan StreamEvent, 16))
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("error = %v, want *APIError", err)
+		}
+		if apiErr.StatusCode != http.StatusTooManyRequests || apiErr.Message != "rate_limit_error: slow down" {
+			t.Errorf("APIError = %+v", apiErr)
+		}
+	})
+}
@@ -2,23 +2,65 @@ package llm
 
 import (
 	"context"
+	"fmt"
+	"log"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/jack/klaudkod/backend/internal/config"
-	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/option"
 )
 
 type Client struct {
-	client openai.Client
-	model  string
+	// models holds the main model followed by its fallbacks.
+	models []modelEndpoint
+	// routes holds the models for background tasks, keyed by task name.
+	routes map[string]modelEndpoint
+	retry  RetryPolicy
+	prices PriceTable
+	// compactThreshold is the percentage of a model's input limit at
+	// which conversations are compacted.
+	compactThreshold int
+	// overheadWarning logs once that the fixed part of requests doesn't
+	// fit the model, which rules out compaction.
+	overheadWarning sync.Once
+	// maxSteps caps the model requests in one turn and maxRepeats the
+	// identical tool calls in a row; zero means no limit.
+	maxSteps   int
+	maxRepeats int
 }
 
 type Message struct {
-	Role      string     `json:"role"`
-	Content   string     `json:"content"`
-	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
-	ToolCallID string    `json:"tool_call_id,omitempty"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	// IsError marks the result of a tool call that failed.
+	IsError bool `json:"is_error,omitempty"`
+	// Images are sent with Content, from user attachments or from tools
+	// that return images.
+	Images []Image `json:"images,omitempty"`
+	// Model records which model produced an assistant message.
+	Model string `json:"model,omitempty"`
+	// Usage records what producing an assistant message cost.
+	Usage *Usage `json:"usage,omitempty"`
+	// Reasoning is the thinking behind an assistant message. Providers
+	// decide whether to send it back: most reject it, while Anthropic
+	// needs each of its thinking blocks, with their signatures, to
+	// continue after tool calls.
+	Reasoning       string           `json:"reasoning,omitempty"`
+	ReasoningBlocks []ReasoningBlock `json:"reasoning_blocks,omitempty"`
+}
+
+// ReasoningBlock is one block of a model's thinking together with the
+// signature it has to be sent back with.
+type ReasoningBlock struct {
+	Text      string `json:"text"`
+	Signature string `json:"signature"`
+	// Redacted is the encrypted content of thinking the provider
+	// withheld, which has no text or signature but still has to be sent
+	// back.
+	Redacted string `json:"redacted,omitempty"`
 }
 
 type ToolCall struct {
@@ -28,68 +70,98 @@ type ToolCall struct {
 }
 
 type StreamEvent struct {
-	Type      string    `json:"type"`
-	Content   string    `json:"content,omitempty"`
-	Error     string    `json:"error,omitempty"`
-	ToolCall  *ToolCall `json:"tool_call,omitempty"`
+	Type     string                 `json:"type"`
+	Content  string                 `json:"content,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+	ToolCall *ToolCall              `json:"tool_call,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	// Message is set on "message" events, which report each message added
+	// to the conversation during a turn so callers can keep their history
+	// in sync.
+	Message *Message `json:"message,omitempty"`
+	// Usage is set on "usage" events, which follow every completed step
+	// with the tokens it used and their cost.
+	Usage *Usage `json:"usage,omitempty"`
+	// Messages is set on "compacted" events to the conversation that
+	// replaces the caller's history.
+	Messages []Message `json:"messages,omitempty"`
+}
+
+// ToolOutput is what a ToolExecutor returns for a single tool call.
+// Metadata carries structured details, such as a command's exit code, that
+// are reported to clients alongside the text given to the model.
+type ToolOutput struct {
+	Content  string
+	IsError  bool
+	Metadata map[string]interface{}
+	Images   []Image
 }
 
-type ToolExecutor func(name, argsJSON string) (content string, isError bool)
+type ToolExecutor func(name, argsJSON string) ToolOutput
 
 func NewClient(cfg *config.Config) *Client {
-	opts := []option.RequestOption{
-		option.WithAPIKey(cfg.LLMAPIKey),
+	retry := DefaultRetryPolicy()
+	if cfg.LLMMaxAttempts > 0 {
+		retry.MaxAttempts = cfg.LLMMaxAttempts
 	}
-
-	if cfg.LLMBaseURL != "" {
-		opts = append(opts, option.WithBaseURL(cfg.LLMBaseURL))
+	if cfg.LLMRetryBaseDelay > 0 {
+		retry.BaseDelay = time.Duration(cfg.LLMRetryBaseDelay) * time.Millisecond
+	}
+	if cfg.LLMRetryMaxDelay > 0 {
+		retry.MaxDelay = time.Duration(cfg.LLMRetryMaxDelay) * time.Millisecond
 	}
 
-	client := openai.NewClient(opts...)
+	models := []modelEndpoint{newModelEndpoint(cfg.PrimaryModel(), cfg.LLMMaxTokens)}
+	for _, spec := range cfg.LLMFallbacks {
+		models = append(models, newModelEndpoint(spec, cfg.LLMMaxTokens))
+	}
 
-	return &Client{
-		client: client,
-		model:  cfg.LLMModel,
+	prices, err := LoadPriceTable(cfg.LLMPriceTable)
+	if err != nil {
+		log.Printf("Using default prices: %v", err)
 	}
-}
 
-func (c *Client) Stream(ctx context.Context, messages []Message, eventChan chan<- StreamEvent) {
-	defer close(eventChan)
+	routes := make(map[string]modelEndpoint)
+	for task, spec := range cfg.LLMRoutes {
+		routes[task] = newModelEndpoint(spec, cfg.LLMMaxTokens)
+	}
 
-	// Convert messages to OpenAI format
-	openaiMessages := make([]openai.ChatCompletionMessageParamUnion, len(messages))
-	for i, msg := range messages {
-		switch msg.Role {
-		case "user":
-			openaiMessages[i] = openai.UserMessage(msg.Content)
-		case "assistant":
-			openaiMessages[i] = openai.AssistantMessage(msg.Content)
-		case "system":
-			openaiMessages[i] = openai.SystemMessage(msg.Content)
-		default:
-			openaiMessages[i] = openai.UserMessage(msg.Content)
-		}
+	compactThreshold := cfg.LLMCompactThreshold
+	if compactThreshold <= 0 || compactThreshold > 100 {
+		compactThreshold = 80
 	}
 
-	// Create streaming request
-	stream := c.client.Chat.Completions.NewStreaming(ctx, openai.ChatCompletionNewParams{
-		Model:    openai.ChatModel(c.model),
-		Messages: openaiMessages,
-	})
+	return &Client{
+		models:           models,
+		routes:           routes,
+		retry:            retry,
+		prices:           prices,
+		compactThreshold: compactThreshold,
+		maxSteps:         cfg.LLMMaxSteps,
+		maxRepeats:       cfg.LLMMaxRepeatedCalls,
+	}
+}
 
-	for stream.Next() {
-		chunk := stream.Current()
-		for _, choice := range chunk.Choices {
-			if choice.Delta.Content != "" {
-				eventChan <- StreamEvent{
-					Type:    "chunk",
-					Content: choice.Delta.Content,
-				}
-			}
-		}
+// newProvider returns the Provider for spec.Provider.
+func newProvider(spec config.ModelSpec, maxTokens int) Provider {
+	switch spec.Provider {
+	case "anthropic":
+		return newAnthropicProvider(spec, maxTokens)
+	case "openai", "":
+		return newOpenAIProvider(spec)
+	default:
+		log.Printf("Unknown LLM provider %q, using the OpenAI-compatible API", spec.Provider)
+		return newOpenAIProvider(spec)
 	}
+}
 
-	if err := stream.Err(); err != nil {
+func (c *Client) Stream(ctx context.Context, messages []Message, eventChan chan<- StreamEvent) {
+	defer close(eventChan)
+
+	resp, _, err := c.streamWithFallback(ctx, 0, Request{
+		Messages: messages,
+	}, eventChan)
+	if err != nil {
 		eventChan <- StreamEvent{
 			Type:  "error",
 			Error: err.Error(),
@@ -97,104 +169,142 @@ func (c *Client) Stream(ctx context.Context, messages []Message, eventChan chan<
 		return
 	}
 
+	eventChan <- StreamEvent{
+		Type:     "usage",
+		Usage:    &resp.Usage,
+		Metadata: map[string]interface{}{"model": resp.Model},
+	}
+
 	eventChan <- StreamEvent{
 		Type: "done",
 	}
 }
 
-func (c *Client) StreamWithTools(ctx context.Context, messages []Message, tools []openai.ChatCompletionToolParam, executor ToolExecutor, eventChan chan<- StreamEvent) {
+func (c *Client) StreamWithTools(ctx context.Context, messages []Message, tools []ToolDefinition, executor ToolExecutor, eventChan chan<- StreamEvent) {
+	c.StreamStructured(ctx, messages, tools, nil, executor, eventChan)
+}
+
+// StreamStructured runs a turn like StreamWithTools, and when schema is set
+// requires the final answer to be JSON that conforms to it. An answer that
+// doesn't is reported in a "schema_invalid" event and the model is asked to
+// correct it, up to maxSchemaRepairs times. A valid answer is sent in a
+// "structured" event before "done".
+func (c *Client) StreamStructured(ctx context.Context, messages []Message, tools []ToolDefinition, schema *ResponseSchema, executor ToolExecutor, eventChan chan<- StreamEvent) {
 	defer close(eventChan)
 
 	currentMessages := make([]Message, len(messages))
 	copy(currentMessages, messages)
 
-	for {
-		// Convert messages to OpenAI format
-		openaiMessages := c.convertMessagesToOpenAI(currentMessages)
-
-		// Create streaming request with tools
-		stream := c.client.Chat.Completions.NewStreaming(ctx, openai.ChatCompletionNewParams{
-			Model:    openai.ChatModel(c.model),
-			Messages: openaiMessages,
-			Tools:    tools,
-		})
-
-		var contentBuilder strings.Builder
-		var toolCalls []ToolCall
-		var currentToolCall *ToolCall
-		var currentArgsBuilder strings.Builder
-
-		for stream.Next() {
-			chunk := stream.Current()
-			for _, choice := range chunk.Choices {
-				// Handle content chunks
-				if choice.Delta.Content != "" {
-					contentBuilder.WriteString(choice.Delta.Content)
-					eventChan <- StreamEvent{
-						Type:    "chunk",
-						Content: choice.Delta.Content,
-					}
-				}
-
-				// Handle tool calls
-				for _, deltaToolCall := range choice.Delta.ToolCalls {
-					// Initialize new tool call if needed
-					if currentToolCall == nil || currentToolCall.ID != deltaToolCall.ID {
-						if currentToolCall != nil && currentToolCall.Arguments != "" {
-							toolCalls = append(toolCalls, *currentToolCall)
-						}
-						currentToolCall = &ToolCall{
-							ID:   deltaToolCall.ID,
-							Name: deltaToolCall.Function.Name,
-						}
-						currentArgsBuilder.Reset()
-					}
-
-					// Update tool call name if provided
-					if deltaToolCall.Function.Name != "" {
-						currentToolCall.Name = deltaToolCall.Function.Name
-					}
+	// Once a turn has fallen back to another model it stays there, so the
+	// remaining steps don't keep hitting a model that is down.
+	active := 0
+	guard := newLoopGuard(c.maxSteps, c.maxRepeats)
+	repairs := 0
 
-					// Accumulate arguments
-					if deltaToolCall.Function.Arguments != "" {
-						currentArgsBuilder.WriteString(deltaToolCall.Function.Arguments)
-						currentToolCall.Arguments = currentArgsBuilder.String()
-					}
-				}
-			}
+	for {
+		compacted, result, err := c.compact(ctx, c.models[active], currentMessages, tools, false)
+		if err != nil {
+			log.Printf("Context compaction failed: %v", err)
 		}
-
-		// Add the last tool call if exists
-		if currentToolCall != nil && currentToolCall.Arguments != "" {
-			toolCalls = append(toolCalls, *currentToolCall)
+		if result.Changed() {
+			currentMessages = compacted
+			c.emitCompacted(compacted, result, eventChan)
 		}
 
-		if err := stream.Err(); err != nil {
+		resp, used, err := c.streamWithFallback(ctx, active, Request{
+			Messages:       currentMessages,
+			Tools:          tools,
+			ResponseSchema: schema,
+		}, eventChan)
+		active = used
+		if err != nil {
 			eventChan <- StreamEvent{
 				Type:  "error",
 				Error: err.Error(),
 			}
 			return
 		}
+		toolCalls := resp.ToolCalls
+		usage := resp.Usage
+		eventChan <- StreamEvent{
+			Type:     "usage",
+			Usage:    &usage,
+			Metadata: map[string]interface{}{"model": resp.Model},
+		}
 
 		// Add assistant message to history
 		assistantMsg := Message{
 			Role:      "assistant",
-			Content:   contentBuilder.String(),
+			Content:   resp.Content,
 			ToolCalls: toolCalls,
+			Model:     resp.Model,
+			Usage:     &usage,
+
+			Reasoning:       resp.Reasoning,
+			ReasoningBlocks: resp.ReasoningBlocks,
 		}
 		currentMessages = append(currentMessages, assistantMsg)
+		eventChan <- StreamEvent{
+			Type:    "message",
+			Message: &assistantMsg,
+		}
 
 		// If no tool calls, we're done
 		if len(toolCalls) == 0 {
+			if schema != nil {
+				structured, errs := schema.parse(resp.Content)
+				if len(errs) > 0 {
+					if repairs >= maxSchemaRepairs {
+						eventChan <- StreamEvent{
+							Type:  "error",
+							Error: fmt.Sprintf("final answer does not conform to the response schema after %d attempts: %s", repairs+1, strings.Join(errs, "; ")),
+						}
+						return
+					}
+					repairs++
+					eventChan <- StreamEvent{
+						Type:    "schema_invalid",
+						Content: strings.Join(errs, "\n"),
+						Metadata: map[string]interface{}{
+							"attempt":      repairs + 1,
+							"max_attempts": maxSchemaRepairs + 1,
+						},
+					}
+					repairMsg := Message{Role: "user", Content: schema.repairPrompt(errs)}
+					currentMessages = append(currentMessages, repairMsg)
+					eventChan <- StreamEvent{
+						Type:    "message",
+						Message: &repairMsg,
+					}
+					continue
+				}
+				eventChan <- StreamEvent{
+					Type:    "structured",
+					Content: string(structured),
+				}
+			}
 			eventChan <- StreamEvent{
 				Type: "done",
 			}
 			return
 		}
 
+		// Once a limit is hit, the remaining calls are answered with a
+		// warning instead of being run, so that every call still has a
+		// result when the user continues the conversation.
+		var limit string
+		var limitCall ToolCall
+		if guard.step() {
+			limit = limitMaxSteps
+		}
+
 		// Execute tools and add responses
 		for _, toolCall := range toolCalls {
+			if limit == "" && guard.repeated(toolCall) {
+				limit = limitRepeatedCall
+				limitCall = toolCall
+			}
+
 			// Emit tool call event
 			eventChan <- StreamEvent{
 				Type:     "tool_call",
@@ -202,59 +312,164 @@ func (c *Client) StreamWithTools(ctx context.Context, messages []Message, tools
 			}
 
 			// Execute tool
-			content, isError := executor(toolCall.Name, toolCall.Arguments)
+			var output ToolOutput
+			if limit != "" {
+				output = ToolOutput{
+					Content: guard.limitWarning(limit, limitCall),
+					IsError: true,
+				}
+			} else {
+				output = executor(toolCall.Name, toolCall.Arguments)
+			}
 
 			// Emit tool result event
 			eventChan <- StreamEvent{
 				Type:    "tool_result",
-				Content: content,
-				Error:   func() string { if isError { return "error" } else { return "" } }(),
+				Content: output.Content,
+				Error: func() string {
+					if output.IsError {
+						return "error"
+					} else {
+						return ""
+					}
+				}(),
+				ToolCall: &toolCall,
+				Metadata: output.Metadata,
 			}
 
 			// Add tool response message
 			toolMsg := Message{
 				Role:       "tool",
-				Content:    content,
+				Content:    output.Content,
 				ToolCallID: toolCall.ID,
+				IsError:    output.IsError,
+				Images:     output.Images,
 			}
 			currentMessages = append(currentMessages, toolMsg)
+			eventChan <- StreamEvent{
+				Type:    "message",
+				Message: &toolMsg,
+			}
+		}
+
+		if limit != "" {
+			c.emitStepLimit(limit, guard, limitCall, eventChan)
+			eventChan <- StreamEvent{
+				Type: "done",
+			}
+			return
 		}
 	}
 }
 
-func (c *Client) convertMessagesToOpenAI(messages []Message) []openai.ChatCompletionMessageParamUnion {
-	openaiMessages := make([]openai.ChatCompletionMessageParamUnion, len(messages))
-	for i, msg := range messages {
-		switch msg.Role {
-		case "user":
-			openaiMessages[i] = openai.UserMessage(msg.Content)
-		case "assistant":
-			if len(msg.ToolCalls) > 0 {
-				toolCalls := make([]openai.ChatCompletionMessageToolCallParam, len(msg.ToolCalls))
-				for j, tc := range msg.ToolCalls {
-					toolCalls[j] = openai.ChatCompletionMessageToolCallParam{
-						ID: tc.ID,
-						Function: openai.ChatCompletionMessageToolCallFunctionParam{
-							Name:      tc.Name,
-							Arguments: tc.Arguments,
-						},
-					}
-				}
-				openaiMessages[i] = openai.ChatCompletionMessageParamUnion{
-					OfAssistant: &openai.ChatCompletionAssistantMessageParam{
-						ToolCalls: toolCalls,
-					},
+// emitStepLimit reports that a turn was stopped by the loop guard.
+func (c *Client) emitStepLimit(reason string, guard *loopGuard, call ToolCall, eventChan chan<- StreamEvent) {
+	content := fmt.Sprintf("Step limit reached: stopped after %d steps.", guard.steps)
+	metadata := map[string]interface{}{
+		"reason":    reason,
+		"steps":     guard.steps,
+		"max_steps": guard.maxSteps,
+	}
+	if reason == limitRepeatedCall {
+		content = fmt.Sprintf("Step limit reached: %s was called more than %d times in a row with the same arguments.", call.Name, guard.maxRepeats)
+		metadata["tool"] = call.Name
+	}
+	log.Print(content)
+	eventChan <- StreamEvent{
+		Type:     "step_limit",
+		Content:  content,
+		Metadata: metadata,
+	}
+}
+
+// streamStep sends one request to a model, retrying transient failures
+// according to the retry policy. A "retrying" event is emitted before every
+// new attempt. Tool calls only run once a reply is complete, so a failed
+// attempt never has side effects; if it had already streamed text or
+// reasoning, the event's discard_partial flag tells clients to drop it
+// because the new attempt will stream its own. The returned flag reports
+// whether the last attempt streamed anything.
+func (c *Client) streamStep(ctx context.Context, endpoint modelEndpoint, req Request, eventChan chan<- StreamEvent) (Response, bool, error) {
+	req.Model = endpoint.name
+
+	for attempt := 1; ; attempt++ {
+		streamed := false
+		stepChan := make(chan StreamEvent)
+		forwarded := make(chan struct{})
+		go func() {
+			defer close(forwarded)
+			for event := range stepChan {
+				if event.Type == "chunk" || event.Type == "reasoning" {
+					streamed = true
 				}
-			} else {
-				openaiMessages[i] = openai.AssistantMessage(msg.Content)
+				eventChan <- event
+			}
+		}()
+
+		resp, err := endpoint.provider.Stream(ctx, req, stepChan)
+		close(stepChan)
+		<-forwarded
+
+		if err == nil {
+			if resp.Model == "" {
+				resp.Model = endpoint.name
 			}
-		case "system":
-			openaiMessages[i] = openai.SystemMessage(msg.Content)
-		case "tool":
-			openaiMessages[i] = openai.ToolMessage(msg.Content, msg.ToolCallID)
-		default:
-			openaiMessages[i] = openai.UserMessage(msg.Content)
+			resp.Usage.Cost = c.cost(endpoint, resp)
+			return resp, streamed, nil
+		}
+
+		retryable, retryAfter := isRetryable(err)
+		if !retryable || attempt >= c.retry.MaxAttempts || ctx.Err() != nil {
+			return Response{}, streamed, err
+		}
+
+		delay := c.retry.delay(attempt, retryAfter)
+		log.Printf("LLM request failed (attempt %d/%d), retrying in %v: %v", attempt, c.retry.MaxAttempts, delay, err)
+		eventChan <- StreamEvent{
+			Type:  "retrying",
+			Error: err.Error(),
+			Metadata: map[string]interface{}{
+				"attempt":         attempt + 1,
+				"max_attempts":    c.retry.MaxAttempts,
+				"delay_ms":        delay.Milliseconds(),
+				"discard_partial": streamed,
+			},
+		}
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return Response{}, false, err
 		}
 	}
-	return openaiMessages
-}
\ No newline at end of file
+}
+
+// cost prices a response by the model the API reported, or by the model that
+// was requested when the reported name, such as a deployment alias, isn't in
+// the price table.
+func (c *Client) cost(endpoint modelEndpoint, resp Response) float64 {
+	if _, ok := c.prices.Lookup(resp.Model); ok {
+		return c.prices.Cost(resp.Model, resp.Usage)
+	}
+	return c.prices.Cost(endpoint.name, resp.Usage)
+}
+
+// emitCompacted reports a compaction, and the usage of its summary request
+// if there was one.
+func (c *Client) emitCompacted(messages []Message, result CompactResult, eventChan chan<- StreamEvent) {
+	if result.Usage != nil {
+		eventChan <- StreamEvent{
+			Type:     "usage",
+			Usage:    result.Usage,
+			Metadata: map[string]interface{}{"model": result.Model},
+		}
+	}
+	eventChan <- StreamEvent{
+		Type:     "compacted",
+		Messages: messages,
+		Metadata: map[string]interface{}{
+			"tokens_before": result.TokensBefore,
+			"tokens_after":  result.TokensAfter,
+			"elided":        result.Elided,
+			"summarized":    result.Summarized,
+		},
+	}
+}
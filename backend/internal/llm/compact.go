@@ -0,0 +1,294 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jack/klaudkod/backend/internal/config"
+)
+
+const (
+	// defaultContextWindow is assumed for models missing from
+	// contextWindows.
+	defaultContextWindow = 32768
+	// keepTurns is how many of the latest user turns compaction leaves
+	// untouched.
+	keepTurns = 2
+	// keepToolResults is how many of the latest tool results are never
+	// elided, whatever their size.
+	keepToolResults = 3
+	// elideMinBytes is the size above which older tool results are elided.
+	elideMinBytes = 2000
+	// transcriptMaxBytes caps each message in the transcript sent to the
+	// summarizer.
+	transcriptMaxBytes = 4000
+	// imageTokens is a rough cost of one image; providers charge by
+	// resolution, up to about this much for a large image.
+	imageTokens = 1600
+
+	summaryPrefix = "Summary of the earlier conversation, which was compacted to save context:\n\n"
+
+	summarySystemPrompt = `You compress coding-agent conversations. Summarize the transcript below so the agent can continue the work without it. Keep the user's goals and constraints, decisions made, files read or changed with the relevant details, commands run and their outcomes, errors still unresolved, and any work that was started but not finished. Leave out pleasantries and anything superseded. Write plain prose or short lists, no preamble.`
+)
+
+// contextWindows are context lengths in tokens, matched by longest prefix.
+var contextWindows = map[string]int{
+	"gpt-4":         8192,
+	"gpt-4-turbo":   128000,
+	"gpt-4o":        128000,
+	"gpt-4.1":       1047576,
+	"o1":            200000,
+	"o3":            200000,
+	"o4-mini":       200000,
+	"claude-":       200000,
+	"qwen2.5-coder": 32768,
+	"llama3":        8192,
+	"llama3.1":      131072,
+}
+
+// contextWindowFor returns the context length of model.
+func contextWindowFor(model string) int {
+	var best string
+	for name := range contextWindows {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return defaultContextWindow
+	}
+	return contextWindows[best]
+}
+
+// inputLimit is how many prompt tokens a model can take while leaving room
+// for a reply of maxTokens.
+func inputLimit(spec config.ModelSpec, maxTokens int) int {
+	window := spec.ContextWindow
+	if window <= 0 {
+		window = contextWindowFor(spec.Model)
+	}
+	if maxTokens <= 0 || maxTokens > window/2 {
+		return window / 2
+	}
+	return window - maxTokens
+}
+
+// estimateTokens approximates the prompt size of a request at about four
+// bytes per token plus a small overhead per message. It only decides when
+// to compact, so it doesn't need a real tokenizer.
+func estimateTokens(messages []Message, tools []ToolDefinition) int {
+	bytes := 0
+	for _, msg := range messages {
+		bytes += len(msg.Content) + 16 + len(msg.Images)*imageTokens*4
+		for _, tc := range msg.ToolCalls {
+			bytes += len(tc.Name) + len(tc.Arguments) + 16
+		}
+	}
+	if len(tools) > 0 {
+		if data, err := json.Marshal(tools); err == nil {
+			bytes += len(data)
+		}
+	}
+	return bytes / 4
+}
+
+// fixedOverhead estimates the part of a request that compaction can't
+// shrink: the leading system prompts and the tool definitions.
+func fixedOverhead(messages []Message, tools []ToolDefinition) int {
+	n := 0
+	for n < len(messages) && messages[n].Role == "system" && !strings.HasPrefix(messages[n].Content, summaryPrefix) {
+		n++
+	}
+	return estimateTokens(messages[:n], tools)
+}
+
+// CompactResult describes what a compaction did.
+type CompactResult struct {
+	TokensBefore int
+	TokensAfter  int
+	// Elided counts tool results replaced by a placeholder; Summarized
+	// counts messages replaced by a summary.
+	Elided     int
+	Summarized int
+	// Usage and Model are set when a summary was requested.
+	Usage *Usage
+	Model string
+}
+
+// Changed reports whether the messages were modified.
+func (r CompactResult) Changed() bool {
+	return r.Elided > 0 || r.Summarized > 0
+}
+
+// Compact shrinks a conversation for the main model, regardless of how full
+// its context is. It is what the manual compact command runs.
+func (c *Client) Compact(ctx context.Context, messages []Message, tools []ToolDefinition) ([]Message, CompactResult, error) {
+	return c.compact(ctx, c.models[0], messages, tools, true)
+}
+
+// compact shrinks messages once the conversation fills more than the
+// compaction threshold of what endpoint's context has left after the system
+// prompts and tool definitions, or unconditionally when force is set. If
+// those alone don't fit, there is nothing compaction can do and it only
+// warns.
+// Bulky tool results outside the latest few are elided first; if that isn't
+// enough, everything before the latest turns is replaced by a summary from
+// the summary model. System prompts are always kept, and since turns start
+// at user messages, tool calls are never separated from their results.
+// On error the messages are returned with whatever elision was done.
+func (c *Client) compact(ctx context.Context, endpoint modelEndpoint, messages []Message, tools []ToolDefinition, force bool) ([]Message, CompactResult, error) {
+	result := CompactResult{TokensBefore: estimateTokens(messages, tools)}
+	result.TokensAfter = result.TokensBefore
+
+	overhead := fixedOverhead(messages, tools)
+	available := endpoint.inputLimit - overhead
+	if available <= 0 {
+		c.overheadWarning.Do(func() {
+			log.Printf("The system prompt and tool definitions take about %d tokens, more than %s can take (%d); conversations will not be compacted automatically", overhead, endpoint.name, endpoint.inputLimit)
+		})
+		if !force {
+			return messages, result, nil
+		}
+	}
+	if !force && (c.compactThreshold <= 0 || result.TokensBefore-overhead <= available*c.compactThreshold/100) {
+		return messages, result, nil
+	}
+	target := overhead + available/2
+
+	out := make([]Message, len(messages))
+	copy(out, messages)
+
+	result.Elided = elideToolResults(out)
+	result.TokensAfter = estimateTokens(out, tools)
+	if !force && result.TokensAfter <= target {
+		return out, result, nil
+	}
+
+	head, old, recent := splitForSummary(out)
+	if len(old) == 0 {
+		return out, result, nil
+	}
+
+	reply, err := c.Complete(ctx, config.TaskSummary, []Message{
+		{Role: "system", Content: summarySystemPrompt},
+		{Role: "user", Content: transcript(old)},
+	})
+	if err != nil {
+		return out, result, fmt.Errorf("failed to summarize conversation: %w", err)
+	}
+	result.Usage = reply.Usage
+	result.Model = reply.Model
+
+	compacted := make([]Message, 0, len(head)+1+len(recent))
+	compacted = append(compacted, head...)
+	compacted = append(compacted, Message{
+		Role:    "system",
+		Content: summaryPrefix + strings.TrimSpace(reply.Content),
+	})
+	compacted = append(compacted, recent...)
+
+	result.Summarized = len(old)
+	result.TokensAfter = estimateTokens(compacted, tools)
+	return compacted, result, nil
+}
+
+// elideToolResults replaces large tool results, other than the latest
+// keepToolResults, with a short placeholder and returns how many it
+// replaced.
+func elideToolResults(messages []Message) int {
+	elided := 0
+	seen := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != "tool" {
+			continue
+		}
+		seen++
+		if seen <= keepToolResults || len(messages[i].Content) <= elideMinBytes && len(messages[i].Images) == 0 {
+			continue
+		}
+		size := len(messages[i].Content)
+		for _, img := range messages[i].Images {
+			size += len(img.Data)
+		}
+		messages[i].Content = fmt.Sprintf("[Tool output elided to save context (%d bytes). Run the tool again if it is still needed.]", size)
+		messages[i].Images = nil
+		elided++
+	}
+	return elided
+}
+
+// splitForSummary splits messages into the leading system prompts, the
+// messages to summarize and the latest turns to keep. Summaries from earlier
+// compactions are summarized again rather than kept. old is empty when
+// there is nothing but the latest turns to summarize.
+func splitForSummary(messages []Message) (head, old, recent []Message) {
+	start := 0
+	for start < len(messages) && messages[start].Role == "system" {
+		if strings.HasPrefix(messages[start].Content, summaryPrefix) {
+			old = append(old, messages[start])
+		} else {
+			head = append(head, messages[start])
+		}
+		start++
+	}
+
+	var turnStarts []int
+	for i := start; i < len(messages); i++ {
+		if messages[i].Role == "user" {
+			turnStarts = append(turnStarts, i)
+		}
+	}
+	keep := keepTurns
+	if len(turnStarts) <= keep {
+		keep = 1
+	}
+	if len(turnStarts) < keep || turnStarts[len(turnStarts)-keep] == start {
+		return messages[:start], nil, messages[start:]
+	}
+
+	boundary := turnStarts[len(turnStarts)-keep]
+	old = append(old, messages[start:boundary]...)
+	return head, old, messages[boundary:]
+}
+
+// transcript renders messages as plain text for the summarizer.
+func transcript(messages []Message) string {
+	var sb strings.Builder
+	for _, msg := range messages {
+		switch msg.Role {
+		case "system":
+			sb.WriteString("EARLIER SUMMARY:\n")
+		case "user":
+			sb.WriteString("USER:\n")
+		case "assistant":
+			sb.WriteString("ASSISTANT:\n")
+		case "tool":
+			sb.WriteString("TOOL RESULT:\n")
+		}
+		sb.WriteString(clip(strings.TrimPrefix(msg.Content, summaryPrefix), transcriptMaxBytes))
+		sb.WriteString("\n")
+		if len(msg.Images) > 0 {
+			fmt.Fprintf(&sb, "[%d image(s)]\n", len(msg.Images))
+		}
+		for _, tc := range msg.ToolCalls {
+			fmt.Fprintf(&sb, "[called %s %s]\n", tc.Name, clip(tc.Arguments, transcriptMaxBytes))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// clip shortens s to at most max bytes, cutting on a rune boundary.
+func clip(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max] + " [...]"
+}
@@ -0,0 +1,180 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jack/klaudkod/backend/internal/config"
+)
+
+func longHistory() []Message {
+	bulky := strings.Repeat("x", 5000)
+	return []Message{
+		{Role: "system", Content: "You are a coding agent."},
+		{Role: "user", Content: "Read the big file"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "1", Name: "read", Arguments: `{}`}}},
+		{Role: "tool", ToolCallID: "1", Content: bulky},
+		{Role: "assistant", Content: "It is big."},
+		{Role: "user", Content: "Read more"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "2", Name: "read"}, {ID: "3", Name: "read"}}},
+		{Role: "tool", ToolCallID: "2", Content: bulky},
+		{Role: "tool", ToolCallID: "3", Content: bulky},
+		{Role: "user", Content: "And one more"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "4", Name: "read"}}},
+		{Role: "tool", ToolCallID: "4", Content: bulky},
+	}
+}
+
+func TestElideToolResults(t *testing.T) {
+	messages := longHistory()
+	if n := elideToolResults(messages); n != 1 {
+		t.Fatalf("elided %d results, want 1", n)
+	}
+	if !strings.HasPrefix(messages[3].Content, "[Tool output elided") {
+		t.Errorf("oldest result not elided: %.40q", messages[3].Content)
+	}
+	for _, i := range []int{7, 8, 11} {
+		if len(messages[i].Content) != 5000 {
+			t.Errorf("message %d should be kept", i)
+		}
+	}
+}
+
+func TestSplitForSummary(t *testing.T) {
+	messages := longHistory()
+	messages = append(messages[:1], append([]Message{{Role: "system", Content: summaryPrefix + "earlier"}}, messages[1:]...)...)
+
+	head, old, recent := splitForSummary(messages)
+	if len(head) != 1 || head[0].Content != "You are a coding agent." {
+		t.Errorf("head = %+v", head)
+	}
+	// The earlier summary and the first turn are summarized.
+	if len(old) != 5 || old[0].Content != summaryPrefix+"earlier" {
+		t.Errorf("old = %+v", old)
+	}
+	if len(recent) != 7 || recent[0].Content != "Read more" {
+		t.Errorf("recent starts with %+v, want the last two turns", recent[0])
+	}
+
+	// A single turn has nothing to summarize.
+	_, old, recent = splitForSummary(messages[10:])
+	if len(old) != 0 || len(recent) != 3 {
+		t.Errorf("single turn: old = %d, recent = %d", len(old), len(recent))
+	}
+}
+
+func TestStreamWithTools_CompactsLongHistory(t *testing.T) {
+	provider := &scriptedProvider{resp: Response{Content: "Summary or answer"}}
+	client := newScriptedClient(provider, 1)
+	client.models[0].inputLimit = 4000
+	client.compactThreshold = 80
+
+	eventChan := make(chan StreamEvent)
+	go client.StreamWithTools(context.Background(), longHistory(), nil, nil, eventChan)
+
+	var compacted *StreamEvent
+	for event := range eventChan {
+		if event.Type == "compacted" {
+			e := event
+			compacted = &e
+		}
+	}
+
+	if compacted == nil {
+		t.Fatal("expected a compacted event")
+	}
+	if provider.calls != 2 {
+		t.Errorf("provider called %d times, want a summary and a reply", provider.calls)
+	}
+	messages := compacted.Messages
+	if len(messages) < 2 || messages[0].Role != "system" || !strings.HasPrefix(messages[1].Content, summaryPrefix) {
+		t.Fatalf("compacted history = %+v", messages)
+	}
+	if compacted.Metadata["tokens_after"].(int) >= compacted.Metadata["tokens_before"].(int) {
+		t.Errorf("compaction did not shrink the history: %v", compacted.Metadata)
+	}
+	for _, msg := range messages {
+		if msg.Role == "tool" && msg.ToolCallID == "1" {
+			t.Error("the first turn should have been summarized")
+		}
+	}
+}
+
+func TestStreamWithTools_NoCompactionBelowThreshold(t *testing.T) {
+	provider := &scriptedProvider{resp: Response{Content: "hi"}}
+	client := newScriptedClient(provider, 1)
+	client.models[0].inputLimit = 100000
+	client.compactThreshold = 80
+
+	eventChan := make(chan StreamEvent)
+	go client.StreamWithTools(context.Background(), longHistory(), nil, nil, eventChan)
+	for event := range eventChan {
+		if event.Type == "compacted" {
+			t.Error("unexpected compaction")
+		}
+	}
+}
+
+// agentRequest returns the first step of a conversation with a system prompt
+// and tool definitions of about the size klaudkod sends.
+func agentRequest() ([]Message, []ToolDefinition) {
+	var tools []ToolDefinition
+	for i := 0; i < 10; i++ {
+		tools = append(tools, ToolDefinition{
+			Name:        fmt.Sprintf("tool%d", i),
+			Description: strings.Repeat("Describes the tool. ", 100),
+			Parameters:  map[string]interface{}{"type": "object"},
+		})
+	}
+	return []Message{
+		{Role: "system", Content: strings.Repeat("Follow these rules. ", 800)},
+		{Role: "user", Content: "Fix the failing test"},
+	}, tools
+}
+
+func TestStreamWithTools_DefaultConfigDoesNotCompactFirstStep(t *testing.T) {
+	for _, key := range []string{"LLM_PROFILE", "LLM_PROVIDER", "LLM_MODEL", "LLM_MAX_TOKENS", "LLM_CONTEXT_WINDOW", "LLM_COMPACT_THRESHOLD", "LLM_FALLBACK_MODELS"} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("KLAUDKOD_DATA_DIR", t.TempDir())
+
+	provider := &scriptedProvider{resp: Response{Content: "Done"}}
+	client := NewClient(config.Load())
+	client.models[0].provider = provider
+
+	// A resumed conversation with some bulky tool output in it.
+	messages, tools := agentRequest()
+	messages = append(messages[:1], longHistory()[1:]...)
+	eventChan := make(chan StreamEvent)
+	go client.StreamWithTools(context.Background(), messages, tools, nil, eventChan)
+	for event := range eventChan {
+		if event.Type == "compacted" {
+			t.Errorf("first step of %s was compacted: %v", client.models[0].name, event.Metadata)
+		}
+	}
+	if provider.calls != 1 {
+		t.Errorf("provider called %d times, want 1", provider.calls)
+	}
+}
+
+func TestStreamWithTools_NoCompactionWhenOverheadDoesNotFit(t *testing.T) {
+	provider := &scriptedProvider{resp: Response{Content: "Done"}}
+	client := newScriptedClient(provider, 1)
+	client.models[0].inputLimit = 4096
+	client.compactThreshold = 80
+
+	messages, tools := agentRequest()
+	messages = append(messages, longHistory()[1:]...)
+	eventChan := make(chan StreamEvent)
+	go client.StreamWithTools(context.Background(), messages, tools, nil, eventChan)
+	for event := range eventChan {
+		if event.Type == "compacted" {
+			t.Errorf("unexpected compaction: %v", event.Metadata)
+		}
+	}
+	if provider.calls != 1 {
+		t.Errorf("provider called %d times, want no summary request", provider.calls)
+	}
+}
@@ -0,0 +1,53 @@
+package llm
+
+import "encoding/base64"
+
+// MaxImageBytes is the largest image sent to the model; providers reject
+// larger ones.
+const MaxImageBytes = 5 * 1024 * 1024
+
+// Image is an image part of a message, sent along with its text content.
+// Saved sessions keep only a reference to the data: SHA256 is set and Data
+// is empty until the session store loads it again.
+type Image struct {
+	MediaType string `json:"media_type"`
+	Data      []byte `json:"data,omitempty"`
+	SHA256    string `json:"sha256,omitempty"`
+}
+
+// imageTypes maps the file extensions of the image formats every provider
+// accepts to their media types.
+var imageTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// ImageTypeForExtension returns the media type of images with the file
+// extension ext, such as ".png", if they can be sent to the model.
+func ImageTypeForExtension(ext string) (string, bool) {
+	mediaType, ok := imageTypes[ext]
+	return mediaType, ok
+}
+
+// IsSupportedImageType reports whether images of mediaType can be sent to
+// the model.
+func IsSupportedImageType(mediaType string) bool {
+	for _, t := range imageTypes {
+		if t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// dataURL encodes the image as a data: URL.
+func (img Image) dataURL() string {
+	return "data:" + img.MediaType + ";base64," + img.base64()
+}
+
+func (img Image) base64() string {
+	return base64.StdEncoding.EncodeToString(img.Data)
+}
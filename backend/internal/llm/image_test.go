@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jack/klaudkod/backend/internal/config"
+)
+
+var testImage = Image{MediaType: "image/png", Data: []byte("\x89PNG\r\n\x1a\nfake")}
+
+func TestConvertMessagesToOpenAI_Images(t *testing.T) {
+	messages := []Message{
+		{Role: "user", Content: "what is this?", Images: []Image{testImage}},
+		{Role: "assistant", ToolCalls: []ToolCall{
+			{ID: "call_1", Name: "read", Arguments: `{"path":"a.png"}`},
+			{ID: "call_2", Name: "read", Arguments: `{"path":"b.txt"}`},
+		}},
+		{Role: "tool", ToolCallID: "call_1", Content: "Image a.png is attached.", Images: []Image{testImage}},
+		{Role: "tool", ToolCallID: "call_2", Content: "text"},
+		{Role: "assistant", Content: "done"},
+	}
+
+	converted := convertMessagesToOpenAI(messages)
+	data, err := json.Marshal(converted)
+	if err != nil {
+		t.Fatalf("failed to encode messages: %v", err)
+	}
+
+	var decoded []struct {
+		Role       string          `json:"role"`
+		ToolCallID string          `json:"tool_call_id"`
+		Content    json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("failed to decode messages: %v", err)
+	}
+
+	var roles []string
+	for _, msg := range decoded {
+		roles = append(roles, msg.Role)
+	}
+	// The tool image follows both results, so they stay next to the call.
+	want := "user,assistant,tool,tool,user,assistant"
+	if got := strings.Join(roles, ","); got != want {
+		t.Fatalf("roles = %s, want %s", got, want)
+	}
+
+	dataURL := "data:image/png;base64," + testImage.base64()
+	if !strings.Contains(string(decoded[0].Content), dataURL) {
+		t.Errorf("user message has no image part: %s", decoded[0].Content)
+	}
+	if !strings.Contains(string(decoded[4].Content), dataURL) || !strings.Contains(string(decoded[4].Content), "call_1") {
+		t.Errorf("tool images not sent after the results: %s", decoded[4].Content)
+	}
+	if strings.Contains(string(decoded[2].Content), "image_url") {
+		t.Errorf("tool message must be text only: %s", decoded[2].Content)
+	}
+}
+
+func TestAnthropicProvider_Images(t *testing.T) {
+	p := newAnthropicProvider(config.ModelSpec{Provider: "anthropic", Model: "claude-sonnet-4-5"}, 1024)
+	req := p.buildRequest(Request{Messages: []Message{
+		{Role: "user", Content: "what is this?", Images: []Image{testImage}},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "toolu_1", Name: "read", Arguments: `{}`}}},
+		{Role: "tool", ToolCallID: "toolu_1", Content: "Image a.png is attached.", Images: []Image{testImage}},
+	}})
+
+	if len(req.Messages) != 3 {
+		t.Fatalf("got %d messages, want 3", len(req.Messages))
+	}
+
+	user := req.Messages[0].Content
+	if len(user) != 2 || user[0].Type != "text" || user[1].Type != "image" {
+		t.Fatalf("user blocks = %+v, want text and image", user)
+	}
+	if src := user[1].Source; src == nil || src.Type != "base64" || src.MediaType != "image/png" || src.Data != testImage.base64() {
+		t.Errorf("image source = %+v", src)
+	}
+
+	result := req.Messages[2].Content[0]
+	blocks, ok := result.Content.([]anthropicBlock)
+	if result.Type != "tool_result" || !ok {
+		t.Fatalf("tool result = %+v, want content blocks", result)
+	}
+	if len(blocks) != 2 || blocks[1].Type != "image" {
+		t.Errorf("tool result blocks = %+v, want text and image", blocks)
+	}
+}
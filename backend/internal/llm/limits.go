@@ -0,0 +1,75 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Reasons a turn can be stopped early, reported in "step_limit" events.
+const (
+	limitMaxSteps     = "max_steps"
+	limitRepeatedCall = "repeated_call"
+)
+
+// loopGuard stops a turn that runs too many steps or keeps making the same
+// tool call over and over, which usually means the model is stuck.
+type loopGuard struct {
+	maxSteps   int
+	maxRepeats int
+	steps      int
+	// lastCall is the latest tool call and repeats how many times in a
+	// row it has been made.
+	lastCall string
+	repeats  int
+}
+
+func newLoopGuard(maxSteps, maxRepeats int) *loopGuard {
+	return &loopGuard{
+		maxSteps:   maxSteps,
+		maxRepeats: maxRepeats,
+	}
+}
+
+// step records a reply that asked for tool calls and reports whether the
+// turn has used up its steps. A limit of zero disables the check.
+func (g *loopGuard) step() bool {
+	g.steps++
+	return g.maxSteps > 0 && g.steps >= g.maxSteps
+}
+
+// repeated records a tool call and reports whether the same call, with the
+// same arguments, has now been made more than maxRepeats times in a row.
+// Any other call in between starts the count over, so that alternating
+// between an edit and the same test run is not mistaken for a loop.
+func (g *loopGuard) repeated(tc ToolCall) bool {
+	key := tc.Name + "\x00" + canonicalArguments(tc.Arguments)
+	if key == g.lastCall {
+		g.repeats++
+	} else {
+		g.lastCall, g.repeats = key, 1
+	}
+	return g.maxRepeats > 0 && g.repeats > g.maxRepeats
+}
+
+// limitWarning is given to the model in place of the results of tool calls
+// that were not run because the turn hit a limit.
+func (g *loopGuard) limitWarning(reason string, tc ToolCall) string {
+	if reason == limitRepeatedCall {
+		return fmt.Sprintf("Not run: %s was already called %d times in a row with these arguments, which looks like a loop. Don't repeat it. Tell the user what is blocking you; they can let you continue with a different approach.", tc.Name, g.maxRepeats)
+	}
+	return fmt.Sprintf("Not run: this turn reached its limit of %d steps. Stop and tell the user what you have done and what is left; they can let you continue.", g.maxSteps)
+}
+
+// canonicalArguments normalizes JSON arguments so that calls differing
+// only in whitespace or key order compare equal.
+func canonicalArguments(args string) string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(args), &v); err != nil {
+		return args
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return args
+	}
+	return string(data)
+}
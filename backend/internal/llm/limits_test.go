@@ -0,0 +1,116 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// loopingProvider asks for a tool call on every request. With vary set, the
+// arguments differ each time; with alternate set, every other call is an
+// edit.
+type loopingProvider struct {
+	vary      bool
+	alternate bool
+	calls     int
+}
+
+func (p *loopingProvider) Stream(ctx context.Context, req Request, eventChan chan<- StreamEvent) (Response, error) {
+	p.calls++
+	name, args := "bash", `{"command": "make test"}`
+	switch {
+	case p.vary:
+		args = fmt.Sprintf(`{"command": "make test-%d"}`, p.calls)
+	case p.alternate && p.calls%2 == 1:
+		name, args = "edit", `{"filePath": "main.go"}`
+	}
+	return Response{ToolCalls: []ToolCall{{ID: fmt.Sprintf("call_%d", p.calls), Name: name, Arguments: args}}}, nil
+}
+
+func runLoop(t *testing.T, client *Client) (executed int, limit *StreamEvent, last StreamEvent, results []StreamEvent) {
+	t.Helper()
+
+	executor := func(name, argsJSON string) ToolOutput {
+		executed++
+		return ToolOutput{Content: "FAIL", IsError: true}
+	}
+	eventChan := make(chan StreamEvent)
+	go client.StreamWithTools(context.Background(), []Message{{Role: "user", Content: "fix the tests"}}, nil, executor, eventChan)
+
+	for event := range eventChan {
+		switch event.Type {
+		case "step_limit":
+			e := event
+			limit = &e
+		case "tool_result":
+			results = append(results, event)
+		}
+		last = event
+	}
+	return executed, limit, last, results
+}
+
+func TestStreamWithTools_StopsRepeatedCalls(t *testing.T) {
+	provider := &loopingProvider{}
+	client := newScriptedClient(provider, 1)
+	client.maxRepeats = 2
+
+	executed, limit, last, results := runLoop(t, client)
+
+	if executed != 2 {
+		t.Errorf("executed %d calls, want 2", executed)
+	}
+	if limit == nil || limit.Metadata["reason"] != limitRepeatedCall || limit.Metadata["tool"] != "bash" {
+		t.Fatalf("step_limit event = %+v", limit)
+	}
+	// The blocked call is answered with a warning so the history stays valid.
+	if n := len(results); n != 3 || results[n-1].Error == "" {
+		t.Errorf("tool results = %+v", results)
+	}
+	if last.Type != "done" {
+		t.Errorf("last event = %q, want done", last.Type)
+	}
+}
+
+func TestStreamWithTools_StopsAtMaxSteps(t *testing.T) {
+	provider := &loopingProvider{vary: true}
+	client := newScriptedClient(provider, 1)
+	client.maxSteps = 4
+	client.maxRepeats = 2
+
+	executed, limit, _, _ := runLoop(t, client)
+
+	if provider.calls != 4 || executed != 3 {
+		t.Errorf("requests = %d, executed = %d; want 4 and 3", provider.calls, executed)
+	}
+	if limit == nil || limit.Metadata["reason"] != limitMaxSteps || limit.Metadata["steps"] != 4 {
+		t.Fatalf("step_limit event = %+v", limit)
+	}
+}
+
+func TestStreamWithTools_AllowsAlternatingCalls(t *testing.T) {
+	provider := &loopingProvider{alternate: true}
+	client := newScriptedClient(provider, 1)
+	client.maxSteps = 8
+	client.maxRepeats = 2
+
+	executed, limit, _, _ := runLoop(t, client)
+
+	if executed != 7 {
+		t.Errorf("executed %d calls, want 7", executed)
+	}
+	if limit == nil || limit.Metadata["reason"] != limitMaxSteps {
+		t.Fatalf("step_limit event = %+v, want only the step limit", limit)
+	}
+}
+
+func TestCanonicalArguments(t *testing.T) {
+	a := canonicalArguments(`{"b": 1, "a": "x"}`)
+	b := canonicalArguments(`{"a":"x","b":1}`)
+	if a != b {
+		t.Errorf("%q != %q", a, b)
+	}
+	if got := canonicalArguments("not json"); got != "not json" {
+		t.Errorf("invalid JSON changed: %q", got)
+	}
+}
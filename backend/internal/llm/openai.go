@@ -0,0 +1,286 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/jack/klaudkod/backend/internal/config"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/shared"
+)
+
+// openAIProvider talks to the OpenAI chat completions API and to servers that
+// implement the same protocol.
+type openAIProvider struct {
+	client          openai.Client
+	reasoningEffort string
+	// toolCallsInContent recovers tool calls that local servers write into
+	// the reply text instead of the tool_calls field.
+	toolCallsInContent bool
+}
+
+func newOpenAIProvider(spec config.ModelSpec) *openAIProvider {
+	opts := []option.RequestOption{
+		option.WithAPIKey(spec.APIKey),
+		// Retries are handled by Client so they can be reported to the user.
+		option.WithMaxRetries(0),
+	}
+
+	if spec.BaseURL != "" {
+		opts = append(opts, option.WithBaseURL(spec.BaseURL))
+	}
+
+	return &openAIProvider{
+		client:             openai.NewClient(opts...),
+		reasoningEffort:    spec.ReasoningEffort,
+		toolCallsInContent: spec.ToolCallsInContent,
+	}
+}
+
+func (p *openAIProvider) Stream(ctx context.Context, req Request, eventChan chan<- StreamEvent) (Response, error) {
+	// Create streaming request with tools
+	params := openai.ChatCompletionNewParams{
+		Model:    openai.ChatModel(req.Model),
+		Messages: convertMessagesToOpenAI(req.Messages),
+		Tools:    convertToolsToOpenAI(req.Tools),
+		StreamOptions: openai.ChatCompletionStreamOptionsParam{
+			IncludeUsage: openai.Bool(true),
+		},
+	}
+	if p.reasoningEffort != "" {
+		params.ReasoningEffort = shared.ReasoningEffort(p.reasoningEffort)
+	}
+	if s := req.ResponseSchema; s != nil {
+		params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{
+				JSONSchema: shared.ResponseFormatJSONSchemaJSONSchemaParam{
+					Name:   s.name(),
+					Schema: s.Schema,
+					Strict: openai.Bool(s.Strict),
+				},
+			},
+		}
+	}
+	stream := p.client.Chat.Completions.NewStreaming(ctx, params)
+
+	var contentBuilder strings.Builder
+	var textCalls textToolCallStream
+	var reasoningBuilder strings.Builder
+	toolCalls := newToolCallAccumulator()
+	var stopReason string
+	var model string
+	var usage Usage
+
+	for stream.Next() {
+		chunk := stream.Current()
+		if chunk.Model != "" {
+			model = chunk.Model
+		}
+		// Usage arrives on a final chunk without choices.
+		if chunk.Usage.PromptTokens > 0 || chunk.Usage.CompletionTokens > 0 {
+			usage = Usage{
+				PromptTokens:     chunk.Usage.PromptTokens,
+				CompletionTokens: chunk.Usage.CompletionTokens,
+				CachedTokens:     chunk.Usage.PromptTokensDetails.CachedTokens,
+			}
+		}
+		for _, choice := range chunk.Choices {
+			if reasoning := reasoningDelta(choice.Delta); reasoning != "" {
+				reasoningBuilder.WriteString(reasoning)
+				eventChan <- StreamEvent{
+					Type:    "reasoning",
+					Content: reasoning,
+				}
+			}
+
+			// Handle content chunks
+			if choice.Delta.Content != "" {
+				contentBuilder.WriteString(choice.Delta.Content)
+				text := choice.Delta.Content
+				if p.toolCallsInContent {
+					text = textCalls.write(text)
+				}
+				if text != "" {
+					eventChan <- StreamEvent{
+						Type:    "chunk",
+						Content: text,
+					}
+				}
+			}
+
+			// Handle tool calls
+			for _, deltaToolCall := range choice.Delta.ToolCalls {
+				toolCalls.add(deltaToolCall.Index, deltaToolCall.ID, deltaToolCall.Function.Name, deltaToolCall.Function.Arguments)
+			}
+
+			if choice.FinishReason != "" {
+				stopReason = choice.FinishReason
+			}
+		}
+	}
+
+	if err := stream.Err(); err != nil {
+		return Response{}, convertOpenAIError(err)
+	}
+
+	resp := Response{
+		Content:    contentBuilder.String(),
+		Reasoning:  reasoningBuilder.String(),
+		ToolCalls:  toolCalls.toolCalls(),
+		StopReason: stopReason,
+		Model:      model,
+		Usage:      usage,
+	}
+
+	if p.toolCallsInContent {
+		if len(resp.ToolCalls) == 0 {
+			resp.Content, resp.ToolCalls = extractToolCallsFromText(resp.Content, req.Tools)
+		}
+		if text := textCalls.flush(resp.Content); text != "" {
+			eventChan <- StreamEvent{
+				Type:    "chunk",
+				Content: text,
+			}
+		}
+	}
+
+	return resp, nil
+}
+
+// reasoningDelta returns the reasoning text in a delta. It isn't part of
+// the OpenAI API, whose models don't share their reasoning, but compatible
+// servers send it as reasoning_content (DeepSeek, vLLM, llama.cpp) or
+// reasoning (Ollama, OpenRouter). Reasoning is never sent back, since those
+// servers reject it in requests.
+func reasoningDelta(delta openai.ChatCompletionChunkChoiceDelta) string {
+	for _, key := range []string{"reasoning_content", "reasoning"} {
+		field, ok := delta.JSON.ExtraFields[key]
+		if !ok {
+			continue
+		}
+		var text string
+		if err := json.Unmarshal([]byte(field.Raw()), &text); err == nil && text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// convertOpenAIError turns SDK errors into APIErrors so they can be
+// classified the same way for every provider.
+func convertOpenAIError(err error) error {
+	var openaiErr *openai.Error
+	if !errors.As(err, &openaiErr) {
+		return err
+	}
+
+	apiErr := &APIError{
+		StatusCode: openaiErr.StatusCode,
+		Type:       openaiErr.Type,
+		Message:    openaiErr.Message,
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = err.Error()
+	}
+	if openaiErr.Response != nil {
+		apiErr.RetryAfter = parseRetryAfter(openaiErr.Response.Header)
+	}
+	return apiErr
+}
+
+func convertToolsToOpenAI(tools []ToolDefinition) []openai.ChatCompletionToolParam {
+	var params []openai.ChatCompletionToolParam
+	for _, tool := range tools {
+		params = append(params, openai.ChatCompletionToolParam{
+			Function: shared.FunctionDefinitionParam{
+				Name:        tool.Name,
+				Description: openai.String(tool.Description),
+				Parameters:  shared.FunctionParameters(tool.Parameters),
+			},
+		})
+	}
+	return params
+}
+
+func convertMessagesToOpenAI(messages []Message) []openai.ChatCompletionMessageParamUnion {
+	var openaiMessages []openai.ChatCompletionMessageParamUnion
+	// Tool messages can only hold text, so images returned by tools are
+	// sent in a user message after the last result of the run, keeping
+	// the results right after the calls they answer.
+	var toolImages []openai.ChatCompletionContentPartUnionParam
+	flushToolImages := func() {
+		if len(toolImages) > 0 {
+			openaiMessages = append(openaiMessages, openai.UserMessage(toolImages))
+			toolImages = nil
+		}
+	}
+
+	for _, msg := range messages {
+		if msg.Role != "tool" {
+			flushToolImages()
+		}
+
+		switch msg.Role {
+		case "user":
+			openaiMessages = append(openaiMessages, openAIUserMessage(msg))
+		case "assistant":
+			if len(msg.ToolCalls) > 0 {
+				toolCalls := make([]openai.ChatCompletionMessageToolCallParam, len(msg.ToolCalls))
+				for j, tc := range msg.ToolCalls {
+					toolCalls[j] = openai.ChatCompletionMessageToolCallParam{
+						ID: tc.ID,
+						Function: openai.ChatCompletionMessageToolCallFunctionParam{
+							Name:      tc.Name,
+							Arguments: tc.Arguments,
+						},
+					}
+				}
+				openaiMessages = append(openaiMessages, openai.ChatCompletionMessageParamUnion{
+					OfAssistant: &openai.ChatCompletionAssistantMessageParam{
+						ToolCalls: toolCalls,
+					},
+				})
+			} else {
+				openaiMessages = append(openaiMessages, openai.AssistantMessage(msg.Content))
+			}
+		case "system":
+			openaiMessages = append(openaiMessages, openai.SystemMessage(msg.Content))
+		case "tool":
+			openaiMessages = append(openaiMessages, openai.ToolMessage(msg.Content, msg.ToolCallID))
+			if len(msg.Images) > 0 {
+				toolImages = append(toolImages, openai.TextContentPart("Images returned by tool call "+msg.ToolCallID+":"))
+				toolImages = append(toolImages, openAIImageParts(msg.Images)...)
+			}
+		default:
+			openaiMessages = append(openaiMessages, openAIUserMessage(msg))
+		}
+	}
+	flushToolImages()
+	return openaiMessages
+}
+
+// openAIUserMessage sends a plain string when there are no images, which
+// every compatible server understands, and content parts otherwise.
+func openAIUserMessage(msg Message) openai.ChatCompletionMessageParamUnion {
+	if len(msg.Images) == 0 {
+		return openai.UserMessage(msg.Content)
+	}
+	var parts []openai.ChatCompletionContentPartUnionParam
+	if msg.Content != "" {
+		parts = append(parts, openai.TextContentPart(msg.Content))
+	}
+	return openai.UserMessage(append(parts, openAIImageParts(msg.Images)...))
+}
+
+func openAIImageParts(images []Image) []openai.ChatCompletionContentPartUnionParam {
+	parts := make([]openai.ChatCompletionContentPartUnionParam, len(images))
+	for i, img := range images {
+		parts[i] = openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{
+			URL: img.dataURL(),
+		})
+	}
+	return parts
+}
@@ -0,0 +1,118 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jack/klaudkod/backend/internal/config"
+)
+
+// newChunkReplayServer serves a recorded chat completions stream from
+// testdata for every request.
+func newChunkReplayServer(t *testing.T, file string) *httptest.Server {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", file, err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOpenAIProvider_ToolCallAssembly(t *testing.T) {
+	tests := []struct {
+		file        string
+		wantContent string
+		// An empty ID means the call must get a generated one.
+		want []ToolCall
+	}{
+		{
+			file: "openai_fragmented_args.sse",
+			want: []ToolCall{
+				{ID: "call_Xk3", Name: "read", Arguments: `{"filePath":"main.go"}`},
+			},
+		},
+		{
+			file: "openai_parallel_interleaved.sse",
+			want: []ToolCall{
+				{ID: "call_A1", Name: "glob", Arguments: `{"pattern":"**/*.go"}`},
+				{ID: "call_B2", Name: "grep", Arguments: `{"pattern":"TODO"}`},
+			},
+		},
+		{
+			file:        "openai_zero_args.sse",
+			wantContent: "Checking status.",
+			want: []ToolCall{
+				{ID: "call_Z0", Name: "status", Arguments: `{}`},
+				{ID: "call_Z1", Name: "read", Arguments: `{"filePath":"go.mod"}`},
+			},
+		},
+		{
+			file: "ollama_single_chunk.sse",
+			want: []ToolCall{
+				{Name: "read", Arguments: `{"filePath":"a.go"}`},
+			},
+		},
+		{
+			file: "llamacpp_reused_index.sse",
+			want: []ToolCall{
+				{ID: "vGy2f1", Name: "read", Arguments: `{"filePath":"a.go"}`},
+				{ID: "Qm9x4T", Name: "read", Arguments: `{"filePath":"b.go"}`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.TrimSuffix(tt.file, ".sse"), func(t *testing.T) {
+			server := newChunkReplayServer(t, tt.file)
+			provider := newOpenAIProvider(config.ModelSpec{
+				BaseURL: server.URL,
+				APIKey:  "test-key",
+			})
+
+			resp, err := provider.Stream(context.Background(), Request{
+				Model:    "gpt-4o",
+				Messages: []Message{{Role: "user", Content: "go"}},
+			}, make(chan StreamEvent, 64))
+			if err != nil {
+				t.Fatalf("Stream failed: %v", err)
+			}
+
+			if resp.Content != tt.wantContent {
+				t.Errorf("content = %q, want %q", resp.Content, tt.wantContent)
+			}
+			if resp.StopReason != "tool_calls" {
+				t.Errorf("stop reason = %q, want tool_calls", resp.StopReason)
+			}
+			if len(resp.ToolCalls) != len(tt.want) {
+				t.Fatalf("got %d tool calls, want %d: %+v", len(resp.ToolCalls), len(tt.want), resp.ToolCalls)
+			}
+			for i, want := range tt.want {
+				got := resp.ToolCalls[i]
+				if want.ID == "" {
+					if !strings.HasPrefix(got.ID, "call_") {
+						t.Errorf("call %d: expected generated ID, got %q", i, got.ID)
+					}
+					want.ID = got.ID
+				}
+				if got != want {
+					t.Errorf("call %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Provider sends a single request to a model API and streams the reply.
+// The agentic loop in Client is built on top of it, so a provider only has
+// to deal with one round trip at a time.
+type Provider interface {
+	// Stream sends req and forwards text deltas to eventChan as "chunk"
+	// events while they arrive. It returns the complete reply once the
+	// stream has ended.
+	Stream(ctx context.Context, req Request, eventChan chan<- StreamEvent) (Response, error)
+}
+
+// Request is a provider-neutral model request.
+type Request struct {
+	Model    string
+	Messages []Message
+	Tools    []ToolDefinition
+	// ResponseSchema, if set, is the format the final answer must have.
+	ResponseSchema *ResponseSchema
+}
+
+// Response is the assembled reply to a Request.
+type Response struct {
+	Content    string
+	ToolCalls  []ToolCall
+	StopReason string
+	// Model is the model that served the request, as reported by the API.
+	Model string
+	// Reasoning is the model's streamed thinking, if it shares it.
+	// ReasoningBlocks is set by providers that need the reasoning sent
+	// back unchanged, block by block, and verify each block's signature.
+	Reasoning       string
+	ReasoningBlocks []ReasoningBlock
+	// Usage is the token usage reported by the API. Cost is filled in by
+	// Client from its price table.
+	Usage Usage
+}
+
+// ToolDefinition describes a tool the model may call. Parameters is a JSON
+// Schema object.
+type ToolDefinition struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{}
+}
+
+// APIError is returned by providers when the API answers with a non-success
+// HTTP status, or reports an error in the middle of a stream, in which case
+// StatusCode is zero and Type says what went wrong.
+type APIError struct {
+	StatusCode int
+	Type       string
+	Message    string
+	// RetryAfter is the delay the server asked for before retrying.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("stream error (%s): %s", e.Type, e.Message)
+	}
+	return fmt.Sprintf("API error (status %d): %s", e.StatusCode, e.Message)
+}
@@ -0,0 +1,121 @@
+package llm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jack/klaudkod/backend/internal/config"
+)
+
+func TestOpenAIProvider_Reasoning(t *testing.T) {
+	tests := []struct {
+		file          string
+		wantReasoning string
+	}{
+		{file: "openai_reasoning_content.sse", wantReasoning: "Two plus two is four."},
+		{file: "ollama_reasoning.sse", wantReasoning: "Simple sum."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			server := newChunkReplayServer(t, tt.file)
+			provider := newOpenAIProvider(config.ModelSpec{BaseURL: server.URL, APIKey: "test-key"})
+
+			eventChan := make(chan StreamEvent, 64)
+			resp, err := provider.Stream(context.Background(), Request{
+				Model:    "m",
+				Messages: []Message{{Role: "user", Content: "2+2?"}},
+			}, eventChan)
+			if err != nil {
+				t.Fatalf("Stream failed: %v", err)
+			}
+			close(eventChan)
+
+			var streamed string
+			for event := range eventChan {
+				if event.Type == "reasoning" {
+					streamed += event.Content
+				}
+			}
+			if resp.Reasoning != tt.wantReasoning || streamed != tt.wantReasoning {
+				t.Errorf("reasoning = %q, streamed %q, want %q", resp.Reasoning, streamed, tt.wantReasoning)
+			}
+			if resp.Content != "4" {
+				t.Errorf("content = %q, want 4", resp.Content)
+			}
+		})
+	}
+}
+
+func TestAnthropicProvider_Thinking(t *testing.T) {
+	server := newReplayServer(t, "anthropic_thinking.sse", "anthropic_text.sse")
+	client := NewClient(&config.Config{
+		LLMProvider:        "anthropic",
+		LLMBaseURL:         server.URL,
+		LLMAPIKey:          "test-key",
+		LLMModel:           "claude-sonnet-4-5",
+		LLMMaxTokens:       1024,
+		LLMReasoningEffort: "low",
+	})
+
+	executor := func(name, argsJSON string) ToolOutput {
+		return ToolOutput{Content: "package main"}
+	}
+	eventChan := make(chan StreamEvent)
+	go client.StreamWithTools(context.Background(), []Message{{Role: "user", Content: "Show main.go"}}, nil, executor, eventChan)
+
+	var reasoning string
+	var first *Message
+	for event := range eventChan {
+		switch event.Type {
+		case "reasoning":
+			reasoning += event.Content
+		case "message":
+			if first == nil {
+				first = event.Message
+			}
+		case "error":
+			t.Fatalf("unexpected error: %s", event.Error)
+		}
+	}
+
+	want := "The user wants the file. I should read it first.\n\nIt is the entry point."
+	if reasoning != want || first == nil || first.Reasoning != want {
+		t.Fatalf("reasoning = %q, first message = %+v", reasoning, first)
+	}
+	wantBlocks := []ReasoningBlock{
+		{Text: "The user wants the file. I should read it first.", Signature: "EqQBCgIYAhIM1gbcDa9GJwZA2b3hGgxBdjrkzLoky3dl1pkiMOYds"},
+		{Text: "It is the entry point.", Signature: "ErUBCkYIBRgCIkD7pQz0Jm3nV"},
+		{Redacted: "EmwKAhgBEgy3va3pzix/LafPsn4aDFIT2Xlxh0L5L8rLVyIwxtE3rAFBa8cr3qpP"},
+	}
+	if len(first.ReasoningBlocks) != len(wantBlocks) {
+		t.Fatalf("reasoning blocks = %+v, want %+v", first.ReasoningBlocks, wantBlocks)
+	}
+	for i, want := range wantBlocks {
+		if first.ReasoningBlocks[i] != want {
+			t.Errorf("reasoning block %d = %+v, want %+v", i, first.ReasoningBlocks[i], want)
+		}
+	}
+
+	req := server.requests[0]
+	if req.Thinking == nil || req.Thinking.BudgetTokens != 2048 || req.MaxTokens != 1024+2048 {
+		t.Errorf("thinking = %+v, max_tokens = %d", req.Thinking, req.MaxTokens)
+	}
+
+	// The thinking blocks have to lead the assistant turn, each with its
+	// own signature and redacted ones as they came, when the tool result
+	// is sent back.
+	assistant := server.requests[1].Messages[1]
+	if assistant.Role != "assistant" || len(assistant.Content) != 4 {
+		t.Fatalf("assistant turn = %+v", assistant)
+	}
+	for i, want := range wantBlocks[:2] {
+		block := assistant.Content[i]
+		if block.Type != "thinking" || block.Thinking != want.Text || block.Signature != want.Signature {
+			t.Errorf("assistant block %d = %+v, want %+v", i, block, want)
+		}
+	}
+	if block := assistant.Content[2]; block.Type != "redacted_thinking" || block.Data != wantBlocks[2].Redacted {
+		t.Errorf("assistant block 2 = %+v, want redacted thinking", block)
+	}
+}
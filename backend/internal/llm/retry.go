@@ -0,0 +1,137 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how requests that fail with a transient error are
+// retried. Delays grow exponentially from BaseDelay up to MaxDelay with full
+// jitter, unless the server asks for a specific delay with Retry-After, which
+// is honored up to MaxDelay as well.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts per request, including
+	// the first. Values below 2 disable retries.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy returns the policy used when none is configured.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   time.Second,
+		MaxDelay:    30 * time.Second,
+	}
+}
+
+// delay returns how long to wait before retry number attempt (starting at
+// 1). A positive retryAfter from the server takes precedence, capped at
+// MaxDelay so that a server asking for minutes or hours can't stall the turn.
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		if p.MaxDelay > 0 && retryAfter > p.MaxDelay {
+			return p.MaxDelay
+		}
+		return retryAfter
+	}
+
+	backoff := p.BaseDelay
+	for i := 1; i < attempt && backoff < p.MaxDelay; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxDelay {
+		backoff = p.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// isRetryable reports whether err is worth retrying: rate limits, server
+// errors, overloads and dropped connections. It also returns the delay the
+// server asked for, if any.
+func isRetryable(err error) (bool, time.Duration) {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false, 0
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == http.StatusTooManyRequests,
+			apiErr.StatusCode == http.StatusRequestTimeout,
+			apiErr.StatusCode >= 500:
+			return true, apiErr.RetryAfter
+		case apiErr.StatusCode == 0:
+			// Errors reported inside an already open stream.
+			switch apiErr.Type {
+			case "overloaded_error", "api_error", "rate_limit_error":
+				return true, apiErr.RetryAfter
+			}
+		}
+		return false, 0
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true, 0
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() && !errors.Is(err, context.DeadlineExceeded) {
+		return true, 0
+	}
+
+	return false, 0
+}
+
+// parseRetryAfter reads the delay requested by a server from the
+// retry-after-ms or Retry-After headers. Retry-After may be a number of
+// seconds or an HTTP date.
+func parseRetryAfter(header http.Header) time.Duration {
+	if header == nil {
+		return 0
+	}
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		if d := time.Until(when); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,195 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// scriptedProvider fails with the queued errors, streaming partial text
+// first when asked to, and then answers with resp.
+type scriptedProvider struct {
+	errs    []error
+	partial string
+	resp    Response
+	calls   int
+}
+
+func (p *scriptedProvider) Stream(ctx context.Context, req Request, eventChan chan<- StreamEvent) (Response, error) {
+	p.calls++
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		if p.partial != "" {
+			eventChan <- StreamEvent{Type: "chunk", Content: p.partial}
+		}
+		return Response{}, err
+	}
+	eventChan <- StreamEvent{Type: "chunk", Content: p.resp.Content}
+	return p.resp, nil
+}
+
+func newScriptedClient(provider Provider, attempts int) *Client {
+	return &Client{
+		models: []modelEndpoint{{name: "test-model", provider: provider}},
+		retry: RetryPolicy{
+			MaxAttempts: attempts,
+			BaseDelay:   time.Millisecond,
+			MaxDelay:    5 * time.Millisecond,
+		},
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		want      bool
+		wantDelay time.Duration
+	}{
+		{name: "rate limit", err: &APIError{StatusCode: 429, RetryAfter: 3 * time.Second}, want: true, wantDelay: 3 * time.Second},
+		{name: "server error", err: &APIError{StatusCode: 503}, want: true},
+		{name: "overloaded mid-stream", err: &APIError{Type: "overloaded_error"}, want: true},
+		{name: "bad request", err: &APIError{StatusCode: 400}, want: false},
+		{name: "unauthorized", err: &APIError{StatusCode: 401}, want: false},
+		{name: "connection reset", err: fmt.Errorf("read: %w", syscall.ECONNRESET), want: true},
+		{name: "unexpected eof", err: io.ErrUnexpectedEOF, want: true},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "other", err: errors.New("boom"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, delay := isRetryable(tt.err)
+			if got != tt.want || delay != tt.wantDelay {
+				t.Errorf("isRetryable = (%v, %v), want (%v, %v)", got, delay, tt.want, tt.wantDelay)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	header := http.Header{}
+	header.Set("Retry-After", "2")
+	if got := parseRetryAfter(header); got != 2*time.Second {
+		t.Errorf("seconds: got %v", got)
+	}
+
+	header.Set("Retry-After-Ms", "250")
+	if got := parseRetryAfter(header); got != 250*time.Millisecond {
+		t.Errorf("milliseconds: got %v", got)
+	}
+
+	header = http.Header{}
+	header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if got := parseRetryAfter(header); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("http date: got %v", got)
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt := 1; attempt <= 8; attempt++ {
+		if d := policy.delay(attempt, 0); d < 0 || d > time.Second {
+			t.Errorf("attempt %d: delay %v outside [0, MaxDelay]", attempt, d)
+		}
+	}
+	if d := policy.delay(1, 500*time.Millisecond); d != 500*time.Millisecond {
+		t.Errorf("Retry-After not honored: %v", d)
+	}
+	header := http.Header{}
+	header.Set("Retry-After", "86400")
+	if d := policy.delay(1, parseRetryAfter(header)); d != time.Second {
+		t.Errorf("oversized Retry-After not capped at MaxDelay: %v", d)
+	}
+}
+
+func TestStreamWithTools_RetriesTransientErrors(t *testing.T) {
+	provider := &scriptedProvider{
+		errs: []error{&APIError{StatusCode: 429}, &APIError{StatusCode: 502}},
+		resp: Response{Content: "done"},
+	}
+	client := newScriptedClient(provider, 3)
+
+	eventChan := make(chan StreamEvent)
+	go client.StreamWithTools(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, nil, eventChan)
+
+	var retries []StreamEvent
+	var last StreamEvent
+	for event := range eventChan {
+		if event.Type == "retrying" {
+			retries = append(retries, event)
+		}
+		last = event
+	}
+
+	if provider.calls != 3 {
+		t.Errorf("provider called %d times, want 3", provider.calls)
+	}
+	if len(retries) != 2 {
+		t.Fatalf("got %d retrying events, want 2", len(retries))
+	}
+	if retries[1].Metadata["attempt"] != 3 || retries[1].Metadata["discard_partial"] != false {
+		t.Errorf("unexpected retry metadata: %v", retries[1].Metadata)
+	}
+	if last.Type != "done" {
+		t.Errorf("last event = %q, want done", last.Type)
+	}
+}
+
+func TestStreamWithTools_PartialContentIsDiscarded(t *testing.T) {
+	provider := &scriptedProvider{
+		errs:    []error{io.ErrUnexpectedEOF},
+		partial: "Half an ans",
+		resp:    Response{Content: "A full answer"},
+	}
+	client := newScriptedClient(provider, 2)
+
+	eventChan := make(chan StreamEvent)
+	go client.StreamWithTools(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, nil, eventChan)
+
+	var discarded bool
+	var final *Message
+	for event := range eventChan {
+		switch event.Type {
+		case "retrying":
+			discarded, _ = event.Metadata["discard_partial"].(bool)
+		case "message":
+			final = event.Message
+		}
+	}
+
+	if !discarded {
+		t.Error("retry after streamed text should set discard_partial")
+	}
+	if final == nil || final.Content != "A full answer" {
+		t.Errorf("final message = %+v, want only the retried content", final)
+	}
+}
+
+func TestStreamWithTools_GivesUpOnPermanentErrors(t *testing.T) {
+	provider := &scriptedProvider{
+		errs: []error{&APIError{StatusCode: 400, Message: "bad request"}},
+	}
+	client := newScriptedClient(provider, 5)
+
+	eventChan := make(chan StreamEvent)
+	go client.StreamWithTools(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, nil, eventChan)
+
+	var last StreamEvent
+	for event := range eventChan {
+		if event.Type == "retrying" {
+			t.Errorf("unexpected retry for a permanent error")
+		}
+		last = event
+	}
+
+	if provider.calls != 1 || last.Type != "error" {
+		t.Errorf("calls = %d, last event = %+v", provider.calls, last)
+	}
+}
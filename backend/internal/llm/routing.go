@@ -0,0 +1,113 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/jack/klaudkod/backend/internal/config"
+)
+
+// modelEndpoint is a model name together with the provider that serves it.
+type modelEndpoint struct {
+	name     string
+	provider Provider
+	// inputLimit is the prompt size in tokens the model can take.
+	inputLimit int
+}
+
+func newModelEndpoint(spec config.ModelSpec, maxTokens int) modelEndpoint {
+	return modelEndpoint{
+		name:       spec.Model,
+		provider:   newProvider(spec, maxTokens),
+		inputLimit: inputLimit(spec, maxTokens),
+	}
+}
+
+// streamWithFallback streams one step, starting with c.models[start] and
+// moving down the fallback chain when a model is unavailable, over quota or
+// still failing after its retries. A "fallback" event is emitted on every
+// switch. It returns the index of the model that answered, or of the last
+// one tried if all of them failed.
+func (c *Client) streamWithFallback(ctx context.Context, start int, req Request, eventChan chan<- StreamEvent) (Response, int, error) {
+	for i := start; ; i++ {
+		endpoint := c.models[i]
+		resp, streamed, err := c.streamStep(ctx, endpoint, req, eventChan)
+		if err == nil {
+			return resp, i, nil
+		}
+
+		if i+1 >= len(c.models) || ctx.Err() != nil || !shouldFallBack(err) {
+			return Response{}, i, err
+		}
+
+		next := c.models[i+1]
+		log.Printf("Model %s failed, falling back to %s: %v", endpoint.name, next.name, err)
+		eventChan <- StreamEvent{
+			Type:  "fallback",
+			Error: err.Error(),
+			Metadata: map[string]interface{}{
+				"from":            endpoint.name,
+				"to":              next.name,
+				"discard_partial": streamed,
+			},
+		}
+	}
+}
+
+// shouldFallBack reports whether err means the model can't serve requests
+// right now, as opposed to a problem with the request itself that another
+// model would reject too.
+func shouldFallBack(err error) bool {
+	if retryable, _ := isRetryable(err); retryable {
+		return true
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusPaymentRequired, http.StatusForbidden, http.StatusNotFound:
+		return true
+	}
+	return strings.Contains(apiErr.Type, "quota") || strings.Contains(apiErr.Type, "not_found")
+}
+
+// Complete runs a single request without tools for a background task such
+// as title generation or summarization, and returns the reply. The task's
+// routed model is tried first, followed by the main fallback chain. Nothing
+// is streamed to the user.
+func (c *Client) Complete(ctx context.Context, task string, messages []Message) (Message, error) {
+	endpoints := c.models
+	if routed, ok := c.routes[task]; ok {
+		endpoints = append([]modelEndpoint{routed}, c.models...)
+	}
+
+	discard := make(chan StreamEvent)
+	go func() {
+		for range discard {
+		}
+	}()
+	defer close(discard)
+
+	var lastErr error
+	for _, endpoint := range endpoints {
+		resp, _, err := c.streamStep(ctx, endpoint, Request{Messages: messages}, discard)
+		if err == nil {
+			return Message{
+				Role:    "assistant",
+				Content: resp.Content,
+				Model:   resp.Model,
+				Usage:   &resp.Usage,
+			}, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil || !shouldFallBack(err) {
+			break
+		}
+	}
+	return Message{}, lastErr
+}
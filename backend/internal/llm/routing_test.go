@@ -0,0 +1,99 @@
+package llm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStreamWithTools_FallsBackToNextModel(t *testing.T) {
+	primary := &scriptedProvider{
+		errs: []error{&APIError{StatusCode: 503}, &APIError{StatusCode: 503}},
+	}
+	backup := &scriptedProvider{resp: Response{Content: "from backup"}}
+
+	client := &Client{
+		models: []modelEndpoint{
+			{name: "big-model", provider: primary},
+			{name: "backup-model", provider: backup},
+		},
+		retry: RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+	}
+
+	eventChan := make(chan StreamEvent)
+	go client.StreamWithTools(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, nil, eventChan)
+
+	var fallback *StreamEvent
+	var final *Message
+	for event := range eventChan {
+		switch event.Type {
+		case "fallback":
+			e := event
+			fallback = &e
+		case "message":
+			final = event.Message
+		}
+	}
+
+	if primary.calls != 2 || backup.calls != 1 {
+		t.Errorf("calls: primary %d, backup %d", primary.calls, backup.calls)
+	}
+	if fallback == nil || fallback.Metadata["from"] != "big-model" || fallback.Metadata["to"] != "backup-model" {
+		t.Fatalf("fallback event = %+v", fallback)
+	}
+	if final == nil || final.Content != "from backup" || final.Model != "backup-model" {
+		t.Errorf("final message = %+v, want content and model from the backup", final)
+	}
+}
+
+func TestStreamWithTools_NoFallbackForBadRequests(t *testing.T) {
+	primary := &scriptedProvider{errs: []error{&APIError{StatusCode: 400}}}
+	backup := &scriptedProvider{resp: Response{Content: "unused"}}
+
+	client := &Client{
+		models: []modelEndpoint{
+			{name: "big-model", provider: primary},
+			{name: "backup-model", provider: backup},
+		},
+		retry: RetryPolicy{MaxAttempts: 1},
+	}
+
+	eventChan := make(chan StreamEvent)
+	go client.StreamWithTools(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, nil, eventChan)
+	for range eventChan {
+	}
+
+	if backup.calls != 0 {
+		t.Errorf("backup was called for a request error")
+	}
+}
+
+func TestComplete_UsesTaskRoute(t *testing.T) {
+	main := &scriptedProvider{resp: Response{Content: "expensive"}}
+	cheap := &scriptedProvider{resp: Response{Content: "Fix login bug"}}
+
+	client := &Client{
+		models: []modelEndpoint{{name: "big-model", provider: main}},
+		routes: map[string]modelEndpoint{
+			"title": {name: "small-model", provider: cheap},
+		},
+		retry: RetryPolicy{MaxAttempts: 1},
+	}
+
+	reply, err := client.Complete(context.Background(), "title", []Message{{Role: "user", Content: "the login is broken"}})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if reply.Content != "Fix login bug" || reply.Model != "small-model" {
+		t.Errorf("reply = %+v, want the routed model's answer", reply)
+	}
+	if main.calls != 0 {
+		t.Errorf("main model was called for a routed task")
+	}
+
+	// Tasks without a route use the main model.
+	reply, err = client.Complete(context.Background(), "summary", []Message{{Role: "user", Content: "x"}})
+	if err != nil || reply.Model != "big-model" {
+		t.Errorf("unrouted task: reply = %+v, err = %v", reply, err)
+	}
+}
@@ -0,0 +1,278 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSchemaRepairs is how many times the model is asked to fix a final
+// answer that doesn't match the response schema.
+const maxSchemaRepairs = 2
+
+// ResponseSchema asks for the final answer of a turn as JSON matching
+// Schema, a JSON Schema object. Providers with structured output support
+// enforce it while generating; the answer is validated either way.
+type ResponseSchema struct {
+	// Name identifies the schema to providers that need one. It may
+	// contain letters, digits, underscores and dashes.
+	Name   string                 `json:"name,omitempty"`
+	Schema map[string]interface{} `json:"schema"`
+	// Strict turns on the provider's strict mode, which only accepts a
+	// subset of JSON Schema: every property required and no additional
+	// properties.
+	Strict bool `json:"strict,omitempty"`
+}
+
+// name returns Name, or a default for providers that require one.
+func (s *ResponseSchema) name() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return "response"
+}
+
+// instruction tells a model without structured output support what to
+// reply with.
+func (s *ResponseSchema) instruction() string {
+	data, _ := json.MarshalIndent(s.Schema, "", "  ")
+	return "When you have finished, give your final answer as a single JSON value that conforms to this JSON Schema, with no other text and no code fences:\n\n" + string(data)
+}
+
+// parse extracts the JSON value from a final answer and validates it. It
+// returns the value re-encoded compactly, or the reasons it doesn't
+// conform.
+func (s *ResponseSchema) parse(content string) (json.RawMessage, []string) {
+	text := extractJSON(content)
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return nil, []string{fmt.Sprintf("reply is not valid JSON: %v", err)}
+	}
+	if errs := validateSchema(s.Schema, value, "$"); len(errs) > 0 {
+		return nil, errs
+	}
+	data, _ := json.Marshal(value)
+	return data, nil
+}
+
+// repairPrompt asks the model to correct an answer that failed validation.
+func (s *ResponseSchema) repairPrompt(errs []string) string {
+	return "Your final answer does not conform to the required JSON schema:\n- " + strings.Join(errs, "\n- ") + "\n\nReply again with only the corrected JSON."
+}
+
+// extractJSON strips the text models tend to put around JSON despite being
+// told not to: surrounding whitespace and a markdown code fence.
+func extractJSON(content string) string {
+	text := strings.TrimSpace(content)
+	if rest, ok := strings.CutPrefix(text, "```"); ok {
+		// Drop the info string, such as "json", on the opening line.
+		if i := strings.IndexByte(rest, '\n'); i >= 0 {
+			rest = rest[i+1:]
+		}
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(rest), "```"))
+	}
+	return text
+}
+
+// validateSchema checks value, decoded by encoding/json, against schema and
+// returns a message for every violation, prefixed with its path. It covers
+// the keywords used to describe tool and response formats: type, enum,
+// const, properties, required, additionalProperties, items, the length and
+// range bounds, pattern, and anyOf, oneOf and allOf. Other keywords,
+// including $ref, are ignored.
+func validateSchema(schema map[string]interface{}, value interface{}, path string) []string {
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if t, ok := schema["type"]; ok {
+		var types []string
+		switch t := t.(type) {
+		case string:
+			types = []string{t}
+		case []interface{}:
+			for _, v := range t {
+				if s, ok := v.(string); ok {
+					types = append(types, s)
+				}
+			}
+		}
+		if !matchesType(value, types) {
+			fail("expected %s, got %s", strings.Join(types, " or "), jsonType(value))
+			return errs
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, v := range enum {
+			if jsonEqual(v, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %s", compactJSON(enum))
+		}
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(c, value) {
+		fail("must be %s", compactJSON(c))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		errs = append(errs, validateObject(schema, v, path)...)
+	case []interface{}:
+		if n, ok := schemaNumber(schema, "minItems"); ok && float64(len(v)) < n {
+			fail("must have at least %v items", n)
+		}
+		if n, ok := schemaNumber(schema, "maxItems"); ok && float64(len(v)) > n {
+			fail("must have at most %v items", n)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				errs = append(errs, validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case string:
+		length := float64(utf8.RuneCountInString(v))
+		if n, ok := schemaNumber(schema, "minLength"); ok && length < n {
+			fail("must be at least %v characters", n)
+		}
+		if n, ok := schemaNumber(schema, "maxLength"); ok && length > n {
+			fail("must be at most %v characters", n)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				fail("must match %s", pattern)
+			}
+		}
+	case float64:
+		if n, ok := schemaNumber(schema, "minimum"); ok && v < n {
+			fail("must be at least %v", n)
+		}
+		if n, ok := schemaNumber(schema, "maximum"); ok && v > n {
+			fail("must be at most %v", n)
+		}
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if sub, ok := sub.(map[string]interface{}); ok {
+				errs = append(errs, validateSchema(sub, value, path)...)
+			}
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok && countMatches(anyOf, value, path) == 0 {
+		fail("does not match any of the allowed schemas")
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		if n := countMatches(oneOf, value, path); n != 1 {
+			fail("must match exactly one of the allowed schemas, matches %d", n)
+		}
+	}
+	return errs
+}
+
+func validateObject(schema map[string]interface{}, obj map[string]interface{}, path string) []string {
+	var errs []string
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, present := obj[name]; !present {
+					errs = append(errs, fmt.Sprintf("%s: missing required property %q", path, name))
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	// Visit properties in order so errors come out the same every time.
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		childPath := path + "." + name
+		if sub, ok := properties[name].(map[string]interface{}); ok {
+			errs = append(errs, validateSchema(sub, obj[name], childPath)...)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				errs = append(errs, fmt.Sprintf("%s: property %q is not allowed", path, name))
+			}
+		case map[string]interface{}:
+			errs = append(errs, validateSchema(additional, obj[name], childPath)...)
+		}
+	}
+	return errs
+}
+
+// countMatches returns how many of schemas value conforms to.
+func countMatches(schemas []interface{}, value interface{}, path string) int {
+	n := 0
+	for _, sub := range schemas {
+		if sub, ok := sub.(map[string]interface{}); ok && len(validateSchema(sub, value, path)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func matchesType(value interface{}, types []string) bool {
+	for _, t := range types {
+		switch t {
+		case "integer":
+			if f, ok := value.(float64); ok && f == math.Trunc(f) {
+				return true
+			}
+		case jsonType(value):
+			return true
+		}
+	}
+	return len(types) == 0
+}
+
+// jsonType names the JSON Schema type of a decoded value.
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func schemaNumber(schema map[string]interface{}, key string) (float64, bool) {
+	n, ok := schema[key].(float64)
+	return n, ok
+}
+
+func jsonEqual(a, b interface{}) bool {
+	return compactJSON(a) == compactJSON(b)
+}
+
+// compactJSON encodes v for comparisons and messages. Map keys are sorted
+// by encoding/json, so equal values encode the same.
+func compactJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
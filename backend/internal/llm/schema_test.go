@@ -0,0 +1,218 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jack/klaudkod/backend/internal/config"
+)
+
+const issuesSchema = `{
+	"type": "object",
+	"properties": {
+		"issues": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"properties": {
+					"file": {"type": "string", "minLength": 1},
+					"line": {"type": "integer", "minimum": 1},
+					"severity": {"enum": ["low", "high"]}
+				},
+				"required": ["file", "line"],
+				"additionalProperties": false
+			}
+		}
+	},
+	"required": ["issues"]
+}`
+
+func parseSchema(t *testing.T, data string) *ResponseSchema {
+	t.Helper()
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &schema); err != nil {
+		t.Fatalf("bad schema: %v", err)
+	}
+	return &ResponseSchema{Name: "issues", Schema: schema}
+}
+
+func TestResponseSchema_Parse(t *testing.T) {
+	schema := parseSchema(t, issuesSchema)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "valid", content: `{"issues": [{"file": "main.go", "line": 3, "severity": "high"}]}`},
+		{name: "code fence", content: "```json\n{\"issues\": []}\n```"},
+		{name: "not json", content: "I found two issues.", wantErr: "not valid JSON"},
+		{name: "missing property", content: `{}`, wantErr: `$: missing required property "issues"`},
+		{name: "wrong type", content: `{"issues": [{"file": "a.go", "line": "3"}]}`, wantErr: "$.issues[0].line: expected integer, got string"},
+		{name: "fraction", content: `{"issues": [{"file": "a.go", "line": 1.5}]}`, wantErr: "expected integer"},
+		{name: "minimum", content: `{"issues": [{"file": "a.go", "line": 0}]}`, wantErr: "$.issues[0].line: must be at least 1"},
+		{name: "enum", content: `{"issues": [{"file": "a.go", "line": 1, "severity": "mid"}]}`, wantErr: `must be one of ["low","high"]`},
+		{name: "additional property", content: `{"issues": [{"file": "a.go", "line": 1, "col": 2}]}`, wantErr: `property "col" is not allowed`},
+		{name: "min length", content: `{"issues": [{"file": "", "line": 1}]}`, wantErr: "$.issues[0].file: must be at least 1 characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, errs := schema.parse(tt.content)
+			if tt.wantErr == "" {
+				if len(errs) > 0 {
+					t.Fatalf("unexpected errors: %v", errs)
+				}
+				if !json.Valid(value) {
+					t.Errorf("parsed value %q is not JSON", value)
+				}
+				return
+			}
+			if !strings.Contains(strings.Join(errs, "\n"), tt.wantErr) {
+				t.Errorf("errors %v do not contain %q", errs, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSchema_Combinators(t *testing.T) {
+	schema := parseSchema(t, `{"oneOf": [{"type": "string"}, {"type": "integer"}, {"type": "number"}]}`).Schema
+
+	if errs := validateSchema(schema, "x", "$"); len(errs) > 0 {
+		t.Errorf("string: unexpected errors %v", errs)
+	}
+	// 2 is both an integer and a number.
+	if errs := validateSchema(schema, 2.0, "$"); len(errs) == 0 {
+		t.Error("integer: expected oneOf to fail")
+	}
+	if errs := validateSchema(schema, true, "$"); len(errs) == 0 {
+		t.Error("boolean: expected oneOf to fail")
+	}
+}
+
+// answerProvider replies with the queued answers in order and records the
+// requests it was sent.
+type answerProvider struct {
+	answers  []string
+	requests []Request
+}
+
+func (p *answerProvider) Stream(ctx context.Context, req Request, eventChan chan<- StreamEvent) (Response, error) {
+	p.requests = append(p.requests, req)
+	answer := p.answers[0]
+	p.answers = p.answers[1:]
+	return Response{Content: answer}, nil
+}
+
+func runStructured(client *Client, schema *ResponseSchema) []StreamEvent {
+	eventChan := make(chan StreamEvent)
+	go client.StreamStructured(context.Background(), []Message{{Role: "user", Content: "find issues"}}, nil, schema, nil, eventChan)
+	return collectEvents(eventChan)
+}
+
+func TestStreamStructured_RepairsInvalidAnswer(t *testing.T) {
+	provider := &answerProvider{answers: []string{
+		"Found one issue in main.go line 3.",
+		`{"issues": [{"file": "main.go", "line": 3}]}`,
+	}}
+	schema := parseSchema(t, issuesSchema)
+	events := runStructured(newScriptedClient(provider, 1), schema)
+
+	var invalid, structured, last StreamEvent
+	for _, event := range events {
+		switch event.Type {
+		case "schema_invalid":
+			invalid = event
+		case "structured":
+			structured = event
+		}
+		last = event
+	}
+
+	if len(provider.requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(provider.requests))
+	}
+	if provider.requests[0].ResponseSchema != schema {
+		t.Error("schema was not passed to the provider")
+	}
+	repair := provider.requests[1].Messages[len(provider.requests[1].Messages)-1]
+	if repair.Role != "user" || !strings.Contains(repair.Content, "not valid JSON") {
+		t.Errorf("repair message = %+v", repair)
+	}
+	if !strings.Contains(invalid.Content, "not valid JSON") {
+		t.Errorf("schema_invalid event = %+v", invalid)
+	}
+	if structured.Content != `{"issues":[{"file":"main.go","line":3}]}` {
+		t.Errorf("structured = %q", structured.Content)
+	}
+	if last.Type != "done" {
+		t.Errorf("last event = %s, want done", last.Type)
+	}
+}
+
+func TestStreamStructured_GivesUp(t *testing.T) {
+	provider := &answerProvider{answers: []string{"{}", "{}", "{}"}}
+	events := runStructured(newScriptedClient(provider, 1), parseSchema(t, issuesSchema))
+
+	last := events[len(events)-1]
+	if last.Type != "error" || !strings.Contains(last.Error, "after 3 attempts") {
+		t.Errorf("last event = %+v, want a schema error", last)
+	}
+	if len(provider.requests) != maxSchemaRepairs+1 {
+		t.Errorf("got %d requests, want %d", len(provider.requests), maxSchemaRepairs+1)
+	}
+}
+
+func TestProviders_ResponseSchema(t *testing.T) {
+	schema := parseSchema(t, issuesSchema)
+
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		data, _ := os.ReadFile(filepath.Join("testdata", "openai_zero_args.sse"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	openaiProvider := newOpenAIProvider(config.ModelSpec{Provider: "openai", Model: "gpt-4o", BaseURL: server.URL, APIKey: "test-key"})
+	if _, err := openaiProvider.Stream(context.Background(), Request{
+		Model:          "gpt-4o",
+		Messages:       []Message{{Role: "user", Content: "hi"}},
+		ResponseSchema: schema,
+	}, make(chan StreamEvent, 100)); err != nil {
+		t.Fatalf("stream failed: %v", err)
+	}
+	var sent struct {
+		ResponseFormat struct {
+			Type       string `json:"type"`
+			JSONSchema struct {
+				Name   string                 `json:"name"`
+				Strict bool                   `json:"strict"`
+				Schema map[string]interface{} `json:"schema"`
+			} `json:"json_schema"`
+		} `json:"response_format"`
+	}
+	if err := json.Unmarshal(body, &sent); err != nil {
+		t.Fatalf("bad request body: %v", err)
+	}
+	if f := sent.ResponseFormat; f.Type != "json_schema" || f.JSONSchema.Name != "issues" || f.JSONSchema.Schema["type"] != "object" {
+		t.Errorf("response_format = %+v", f)
+	}
+
+	p := newAnthropicProvider(config.ModelSpec{Provider: "anthropic", Model: "claude-sonnet-4-5"}, 1024)
+	req := p.buildRequest(Request{
+		Messages:       []Message{{Role: "system", Content: "Be brief."}, {Role: "user", Content: "hi"}},
+		ResponseSchema: schema,
+	})
+	if !strings.HasPrefix(req.System, "Be brief.") || !strings.Contains(req.System, `"additionalProperties": false`) {
+		t.Errorf("anthropic system prompt does not carry the schema: %s", req.System)
+	}
+}
@@ -0,0 +1,239 @@
+package llm
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// toolCallAccumulator assembles streamed tool-call deltas into complete
+// calls. OpenAI sends the ID and name only on the first delta of each call and
+// identifies later argument fragments by index alone, and parallel calls may
+// interleave their fragments, so deltas are grouped by index rather than ID.
+type toolCallAccumulator struct {
+	calls   []*ToolCall
+	current map[int64]*ToolCall
+}
+
+func newToolCallAccumulator() *toolCallAccumulator {
+	return &toolCallAccumulator{
+		current: make(map[int64]*ToolCall),
+	}
+}
+
+// add merges one delta into the call at index. A delta whose ID differs from
+// the one already recorded at that index starts a new call, which handles
+// servers that send every call with index 0.
+func (a *toolCallAccumulator) add(index int64, id, name, arguments string) {
+	call, ok := a.current[index]
+	if !ok || (id != "" && call.ID != "" && id != call.ID) {
+		call = &ToolCall{}
+		a.current[index] = call
+		a.calls = append(a.calls, call)
+	}
+
+	if id != "" {
+		call.ID = id
+	}
+	if name != "" {
+		call.Name = name
+	}
+	call.Arguments += arguments
+}
+
+// toolCalls returns the assembled calls in the order they were started.
+// Calls without arguments get an empty JSON object, and calls the server
+// left without an ID get a generated one.
+func (a *toolCallAccumulator) toolCalls() []ToolCall {
+	var calls []ToolCall
+	for _, c := range a.calls {
+		call := *c
+		if call.Name == "" {
+			continue
+		}
+		if strings.TrimSpace(call.Arguments) == "" {
+			call.Arguments = "{}"
+		}
+		if call.ID == "" {
+			call.ID = newToolCallID()
+		}
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+// newToolCallID returns a random ID in the style of OpenAI tool call IDs.
+func newToolCallID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "call_" + hex.EncodeToString(b)
+}
+
+var (
+	toolCallTagPattern   = regexp.MustCompile(`(?s)<tool_call>\s*(.*?)\s*</tool_call>`)
+	jsonCodeFencePattern = regexp.MustCompile("(?s)^```(?:json)?\\s*(.*?)\\s*```$")
+)
+
+// textToolCall is the JSON shape local models use when they write a tool
+// call into the message text. Both "arguments" and "parameters" are common,
+// and the value may be an object or a JSON-encoded string.
+type textToolCall struct {
+	Name       string          `json:"name"`
+	Arguments  json.RawMessage `json:"arguments"`
+	Parameters json.RawMessage `json:"parameters"`
+}
+
+// textToolCallStream decides which content can be streamed as it arrives
+// when tool calls may be written into the text, so that the raw calls don't
+// reach the user before extractToolCallsFromText removes them. A reply that
+// opens with "{", "[" or a JSON code fence is held back whole, and any other
+// reply up to the first <tool_call> tag.
+type textToolCallStream struct {
+	content strings.Builder
+	sent    int
+}
+
+// write adds a content delta and returns the part that is safe to stream.
+func (s *textToolCallStream) write(delta string) string {
+	s.content.WriteString(delta)
+	content := s.content.String()
+	if s.sent == 0 && couldBeTextToolCall(content) {
+		return ""
+	}
+
+	end := len(content)
+	if i := strings.Index(content[s.sent:], "<tool_call>"); i >= 0 {
+		end = s.sent + i
+	} else {
+		// Keep back what may be the start of a tag split across deltas.
+		for n := len("<tool_call>") - 1; n > 0; n-- {
+			if strings.HasSuffix(content, "<tool_call>"[:n]) {
+				end -= n
+				break
+			}
+		}
+	}
+	if end <= s.sent {
+		return ""
+	}
+	text := content[s.sent:end]
+	s.sent = end
+	return text
+}
+
+// flush returns the rest of the reply once it is complete, given its
+// content after tool calls were extracted.
+func (s *textToolCallStream) flush(final string) string {
+	content := s.content.String()
+	switch {
+	case final == content:
+		return content[s.sent:]
+	case final == "":
+		return ""
+	}
+	// Everything from the first call on was held back.
+	rest := toolCallTagPattern.ReplaceAllString(content[s.sent:], "")
+	if s.sent == 0 {
+		return strings.TrimSpace(rest)
+	}
+	return strings.TrimRightFunc(rest, unicode.IsSpace)
+}
+
+// couldBeTextToolCall reports whether content, the start of a reply, may
+// still turn out to be a tool call written as the whole reply.
+func couldBeTextToolCall(content string) bool {
+	trimmed := strings.TrimLeftFunc(content, unicode.IsSpace)
+	switch {
+	case trimmed == "", strings.HasPrefix(trimmed, "{"), strings.HasPrefix(trimmed, "["):
+		return true
+	case strings.HasPrefix("```", trimmed):
+		return true
+	case strings.HasPrefix(trimmed, "```"):
+		lang, _, complete := strings.Cut(trimmed[3:], "\n")
+		lang = strings.TrimSpace(lang)
+		return !complete || lang == "" || lang == "json"
+	}
+	return false
+}
+
+// extractToolCallsFromText recovers tool calls embedded in content, either
+// wrapped in <tool_call> tags or as a reply that consists only of a JSON
+// call object or array of them. Only calls to known tools are accepted, so
+// ordinary JSON in an answer is left alone. It returns the content with the
+// extracted calls removed.
+func extractToolCallsFromText(content string, tools []ToolDefinition) (string, []ToolCall) {
+	known := make(map[string]bool, len(tools))
+	for _, tool := range tools {
+		known[tool.Name] = true
+	}
+
+	if matches := toolCallTagPattern.FindAllStringSubmatch(content, -1); len(matches) > 0 {
+		var calls []ToolCall
+		for _, match := range matches {
+			calls = append(calls, parseTextToolCalls(match[1], known)...)
+		}
+		if len(calls) == 0 {
+			return content, nil
+		}
+		return strings.TrimSpace(toolCallTagPattern.ReplaceAllString(content, "")), calls
+	}
+
+	trimmed := strings.TrimSpace(content)
+	if match := jsonCodeFencePattern.FindStringSubmatch(trimmed); match != nil {
+		trimmed = match[1]
+	}
+	if calls := parseTextToolCalls(trimmed, known); len(calls) > 0 {
+		return "", calls
+	}
+	return content, nil
+}
+
+// parseTextToolCalls decodes a single call object or an array of them. It
+// returns nothing unless every call names a known tool.
+func parseTextToolCalls(text string, known map[string]bool) []ToolCall {
+	var raw []textToolCall
+	if strings.HasPrefix(text, "[") {
+		if err := json.Unmarshal([]byte(text), &raw); err != nil {
+			return nil
+		}
+	} else {
+		var single textToolCall
+		if err := json.Unmarshal([]byte(text), &single); err != nil {
+			return nil
+		}
+		raw = []textToolCall{single}
+	}
+
+	var calls []ToolCall
+	for _, tc := range raw {
+		if !known[tc.Name] {
+			return nil
+		}
+		args := tc.Arguments
+		if len(args) == 0 {
+			args = tc.Parameters
+		}
+		calls = append(calls, ToolCall{
+			ID:        newToolCallID(),
+			Name:      tc.Name,
+			Arguments: normalizeArguments(args),
+		})
+	}
+	return calls
+}
+
+// normalizeArguments turns the arguments of a text tool call into a JSON
+// object string, unwrapping arguments that were encoded as a string.
+func normalizeArguments(args json.RawMessage) string {
+	if len(args) == 0 || string(args) == "null" {
+		return "{}"
+	}
+	var encoded string
+	if err := json.Unmarshal(args, &encoded); err == nil {
+		return encoded
+	}
+	return string(args)
+}
@@ -0,0 +1,136 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtractToolCallsFromText(t *testing.T) {
+	tools := []ToolDefinition{{Name: "read"}, {Name: "bash"}}
+
+	tests := []struct {
+		name        string
+		content     string
+		wantNames   []string
+		wantArgs    []string
+		wantContent string
+	}{
+		{
+			name:        "hermes tags",
+			content:     "Let me check.\n<tool_call>\n{\"name\": \"read\", \"arguments\": {\"filePath\": \"main.go\"}}\n</tool_call>",
+			wantNames:   []string{"read"},
+			wantArgs:    []string{`{"filePath": "main.go"}`},
+			wantContent: "Let me check.",
+		},
+		{
+			name:      "bare object with parameters",
+			content:   `{"name": "bash", "parameters": {"command": "ls", "description": "List files"}}`,
+			wantNames: []string{"bash"},
+			wantArgs:  []string{`{"command": "ls", "description": "List files"}`},
+		},
+		{
+			name:      "fenced array with string arguments",
+			content:   "```json\n[{\"name\": \"read\", \"arguments\": \"{\\\"filePath\\\": \\\"a.go\\\"}\"}, {\"name\": \"read\", \"arguments\": {}}]\n```",
+			wantNames: []string{"read", "read"},
+			wantArgs:  []string{`{"filePath": "a.go"}`, `{}`},
+		},
+		{
+			name:        "unknown tool is left as text",
+			content:     `{"name": "deploy", "arguments": {}}`,
+			wantContent: `{"name": "deploy", "arguments": {}}`,
+		},
+		{
+			name:        "plain answer",
+			content:     "The answer is 42.",
+			wantContent: "The answer is 42.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, calls := extractToolCallsFromText(tt.content, tools)
+			if content != tt.wantContent {
+				t.Errorf("content = %q, want %q", content, tt.wantContent)
+			}
+			if len(calls) != len(tt.wantNames) {
+				t.Fatalf("got %d calls, want %d: %+v", len(calls), len(tt.wantNames), calls)
+			}
+			for i, call := range calls {
+				if call.Name != tt.wantNames[i] || call.Arguments != tt.wantArgs[i] {
+					t.Errorf("call %d = %+v, want %s(%s)", i, call, tt.wantNames[i], tt.wantArgs[i])
+				}
+				if !strings.HasPrefix(call.ID, "call_") {
+					t.Errorf("call %d has no synthesized ID: %q", i, call.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestTextToolCallStream(t *testing.T) {
+	tools := []ToolDefinition{{Name: "read"}}
+
+	tests := []struct {
+		name   string
+		deltas []string
+		// wantEarly is what must be streamed before the reply is complete.
+		wantEarly string
+		want      string
+	}{
+		{
+			name:      "tagged call after text",
+			deltas:    []string{"Let me ", "check.\n<tool", "_call>\n{\"name\": \"read\", ", "\"arguments\": {}}\n</tool_call>"},
+			wantEarly: "Let me check.\n",
+			want:      "Let me check.\n",
+		},
+		{
+			name:   "bare call",
+			deltas: []string{"  {\"name\": ", "\"read\", \"arguments\": {\"filePath\": \"a.go\"}}"},
+		},
+		{
+			name:   "fenced call",
+			deltas: []string{"``", "`json\n[{\"name\": \"read\"}]\n```"},
+		},
+		{
+			name:      "plain answer",
+			deltas:    []string{"The answer ", "is 42."},
+			wantEarly: "The answer is 42.",
+			want:      "The answer is 42.",
+		},
+		{
+			name:      "code block",
+			deltas:    []string{"```go\n", "fmt.Println(1)\n```"},
+			wantEarly: "```go\nfmt.Println(1)\n```",
+			want:      "```go\nfmt.Println(1)\n```",
+		},
+		{
+			name:   "JSON that is not a call",
+			deltas: []string{`{"name": `, `"deploy"}`},
+			want:   `{"name": "deploy"}`,
+		},
+		{
+			name:      "text that looks like a tag",
+			deltas:    []string{"Use <tool", "s> here"},
+			wantEarly: "Use <tools> here",
+			want:      "Use <tools> here",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s textToolCallStream
+			var streamed strings.Builder
+			for _, delta := range tt.deltas {
+				streamed.WriteString(s.write(delta))
+			}
+			if streamed.String() != tt.wantEarly {
+				t.Errorf("streamed %q before the end, want %q", streamed.String(), tt.wantEarly)
+			}
+			final, _ := extractToolCallsFromText(strings.Join(tt.deltas, ""), tools)
+			streamed.WriteString(s.flush(final))
+			if streamed.String() != tt.want {
+				t.Errorf("streamed %q, want %q", streamed.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// Usage counts the tokens used by one or more requests and what they cost.
+// PromptTokens includes CachedTokens.
+type Usage struct {
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	CachedTokens     int64   `json:"cached_tokens,omitempty"`
+	Cost             float64 `json:"cost_usd"`
+}
+
+// Add accumulates other into u.
+func (u *Usage) Add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.CachedTokens += other.CachedTokens
+	u.Cost += other.Cost
+}
+
+// Price is the cost of a model in US dollars per million tokens.
+type Price struct {
+	Input       float64 `json:"input"`
+	CachedInput float64 `json:"cached_input"`
+	Output      float64 `json:"output"`
+}
+
+// PriceTable maps model names to prices. A model without an exact entry
+// uses the entry it is a dated snapshot of, so "gpt-4o" also prices
+// "gpt-4o-2024-08-06". Other models in the same family, such as
+// "gpt-4.1-nano" next to "gpt-4.1", need entries of their own.
+type PriceTable map[string]Price
+
+// DefaultPrices are list prices for common models at the time of writing.
+// They can be overridden or extended with a price table file.
+func DefaultPrices() PriceTable {
+	return PriceTable{
+		"gpt-4":             {Input: 30, CachedInput: 30, Output: 60},
+		"gpt-4-turbo":       {Input: 10, CachedInput: 10, Output: 30},
+		"gpt-4o":            {Input: 2.50, CachedInput: 1.25, Output: 10},
+		"gpt-4o-mini":       {Input: 0.15, CachedInput: 0.075, Output: 0.60},
+		"gpt-4.1":           {Input: 2, CachedInput: 0.50, Output: 8},
+		"gpt-4.1-mini":      {Input: 0.40, CachedInput: 0.10, Output: 1.60},
+		"gpt-4.1-nano":      {Input: 0.10, CachedInput: 0.025, Output: 0.40},
+		"o1":                {Input: 15, CachedInput: 7.50, Output: 60},
+		"o3":                {Input: 2, CachedInput: 0.50, Output: 8},
+		"o4-mini":           {Input: 1.10, CachedInput: 0.275, Output: 4.40},
+		"claude-sonnet-4-5": {Input: 3, CachedInput: 0.30, Output: 15},
+		"claude-haiku-4-5":  {Input: 1, CachedInput: 0.10, Output: 5},
+		"claude-opus-4-1":   {Input: 15, CachedInput: 1.50, Output: 75},
+	}
+}
+
+// LoadPriceTable returns the default prices merged with the JSON object in
+// path, which maps model names to prices. A missing file is not an error.
+func LoadPriceTable(path string) (PriceTable, error) {
+	prices := DefaultPrices()
+	if path == "" {
+		return prices, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return prices, nil
+		}
+		return prices, fmt.Errorf("failed to read price table: %w", err)
+	}
+
+	var custom PriceTable
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return prices, fmt.Errorf("failed to parse price table %s: %w", path, err)
+	}
+	for model, price := range custom {
+		prices[model] = price
+	}
+	return prices, nil
+}
+
+// snapshotSuffix matches the date a dated model snapshot adds to the name
+// of its model: -2024-08-06 or -0613 for OpenAI, -20250929 for Anthropic.
+var snapshotSuffix = regexp.MustCompile(`-(\d{4}-\d{2}-\d{2}|\d{8}|\d{4})$`)
+
+// Lookup returns the price for model, or for the model it is a dated
+// snapshot of.
+func (t PriceTable) Lookup(model string) (Price, bool) {
+	if price, ok := t[model]; ok {
+		return price, true
+	}
+	if loc := snapshotSuffix.FindStringIndex(model); loc != nil {
+		if price, ok := t[model[:loc[0]]]; ok {
+			return price, true
+		}
+	}
+	return Price{}, false
+}
+
+// Cost prices usage for model. Unknown models cost nothing.
+func (t PriceTable) Cost(model string, usage Usage) float64 {
+	price, ok := t.Lookup(model)
+	if !ok {
+		return 0
+	}
+	uncached := usage.PromptTokens - usage.CachedTokens
+	return (float64(uncached)*price.Input +
+		float64(usage.CachedTokens)*price.CachedInput +
+		float64(usage.CompletionTokens)*price.Output) / 1e6
+}
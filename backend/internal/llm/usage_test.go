@@ -0,0 +1,153 @@
+package llm
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jack/klaudkod/backend/internal/config"
+)
+
+func TestPriceTable_Lookup(t *testing.T) {
+	prices := PriceTable{
+		"gpt-4":             {Input: 30},
+		"gpt-4o":            {Input: 2.5},
+		"gpt-4o-mini":       {Input: 0.15},
+		"gpt-4.1":           {Input: 2},
+		"claude-sonnet-4-5": {Input: 3},
+	}
+
+	tests := []struct {
+		model string
+		want  float64
+		found bool
+	}{
+		{model: "gpt-4o", want: 2.5, found: true},
+		{model: "gpt-4o-2024-08-06", want: 2.5, found: true},
+		{model: "gpt-4o-mini-2024-07-18", want: 0.15, found: true},
+		{model: "gpt-4-0613", want: 30, found: true},
+		{model: "claude-sonnet-4-5-20250929", want: 3, found: true},
+		// Other models of a family aren't priced like it.
+		{model: "gpt-4.1-nano", found: false},
+		{model: "gpt-4-turbo", found: false},
+		{model: "gpt-4o-mini-tts", found: false},
+		{model: "llama3", found: false},
+	}
+	for _, tt := range tests {
+		price, ok := prices.Lookup(tt.model)
+		if ok != tt.found || price.Input != tt.want {
+			t.Errorf("Lookup(%q) = (%v, %v), want input %v, found %v", tt.model, price, ok, tt.want, tt.found)
+		}
+	}
+
+	// The defaults price the smaller models of a family on their own.
+	for model, want := range map[string]float64{"gpt-4.1-nano-2025-04-14": 0.10, "gpt-4-turbo": 10, "o4-mini": 1.10} {
+		if price, ok := DefaultPrices().Lookup(model); !ok || price.Input != want {
+			t.Errorf("default price of %s = (%v, %v), want input %v", model, price, ok, want)
+		}
+	}
+}
+
+func TestPriceTable_Cost(t *testing.T) {
+	prices := PriceTable{"m": {Input: 2, CachedInput: 0.5, Output: 10}}
+	usage := Usage{PromptTokens: 1_000_000, CachedTokens: 400_000, CompletionTokens: 100_000}
+
+	// 600k uncached at $2, 400k cached at $0.50, 100k output at $10.
+	want := 1.2 + 0.2 + 1.0
+	if got := prices.Cost("m", usage); math.Abs(got-want) > 1e-9 {
+		t.Errorf("Cost = %v, want %v", got, want)
+	}
+	if got := prices.Cost("unknown", usage); got != 0 {
+		t.Errorf("unknown model cost %v, want 0", got)
+	}
+}
+
+func TestLoadPriceTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.json")
+	os.WriteFile(path, []byte(`{"local-model": {"input": 1, "output": 2}, "gpt-4o": {"input": 9}}`), 0644)
+
+	prices, err := LoadPriceTable(path)
+	if err != nil {
+		t.Fatalf("LoadPriceTable failed: %v", err)
+	}
+	if prices["local-model"].Output != 2 || prices["gpt-4o"].Input != 9 {
+		t.Errorf("custom prices not applied: %+v", prices)
+	}
+	if _, ok := prices["claude-sonnet-4-5"]; !ok {
+		t.Error("default prices missing")
+	}
+
+	if _, err := LoadPriceTable(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("missing file should not be an error: %v", err)
+	}
+}
+
+func TestProviders_ReportUsage(t *testing.T) {
+	t.Run("openai", func(t *testing.T) {
+		server := newChunkReplayServer(t, "openai_zero_args.sse")
+		provider := newOpenAIProvider(config.ModelSpec{BaseURL: server.URL, APIKey: "test-key"})
+
+		resp, err := provider.Stream(context.Background(), Request{
+			Model:    "gpt-4o",
+			Messages: []Message{{Role: "user", Content: "go"}},
+		}, make(chan StreamEvent, 64))
+		if err != nil {
+			t.Fatalf("Stream failed: %v", err)
+		}
+		want := Usage{PromptTokens: 1200, CompletionTokens: 40, CachedTokens: 1024}
+		if resp.Usage != want {
+			t.Errorf("usage = %+v, want %+v", resp.Usage, want)
+		}
+	})
+
+	t.Run("anthropic", func(t *testing.T) {
+		server := newReplayServer(t, "anthropic_text.sse")
+		provider := newTestAnthropicClient(server.URL).models[0].provider
+
+		resp, err := provider.Stream(context.Background(), Request{
+			Model:    "claude-sonnet-4-5",
+			Messages: []Message{{Role: "user", Content: "hi"}},
+		}, make(chan StreamEvent, 16))
+		if err != nil {
+			t.Fatalf("Stream failed: %v", err)
+		}
+		want := Usage{PromptTokens: 25, CompletionTokens: 15}
+		if resp.Usage != want {
+			t.Errorf("usage = %+v, want %+v", resp.Usage, want)
+		}
+	})
+}
+
+func TestStreamWithTools_EmitsUsage(t *testing.T) {
+	provider := &scriptedProvider{
+		resp: Response{Content: "done", Usage: Usage{PromptTokens: 1000, CompletionTokens: 100}},
+	}
+	client := newScriptedClient(provider, 1)
+	client.prices = PriceTable{"test-model": {Input: 1, Output: 10}}
+
+	eventChan := make(chan StreamEvent)
+	go client.StreamWithTools(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, nil, eventChan)
+
+	var usage *Usage
+	var final *Message
+	for event := range eventChan {
+		switch event.Type {
+		case "usage":
+			usage = event.Usage
+		case "message":
+			final = event.Message
+		}
+	}
+
+	if usage == nil {
+		t.Fatal("missing usage event")
+	}
+	if want := 0.002; math.Abs(usage.Cost-want) > 1e-12 {
+		t.Errorf("cost = %v, want %v", usage.Cost, want)
+	}
+	if final == nil || final.Usage == nil || *final.Usage != *usage {
+		t.Errorf("assistant message usage = %+v, want %+v", final, usage)
+	}
+}
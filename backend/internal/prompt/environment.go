@@ -0,0 +1,101 @@
+package prompt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Environment is what the agent is told about where it runs.
+type Environment struct {
+	OS         string
+	WorkingDir string
+	Date       time.Time
+	// GitBranch is empty outside a git repository. A detached HEAD is
+	// reported by its commit hash.
+	GitBranch string
+	Tools     []string
+}
+
+// DetectEnvironment describes the current machine and workingDir.
+func DetectEnvironment(workingDir string, tools []string) Environment {
+	return Environment{
+		OS:         runtime.GOOS + "/" + runtime.GOARCH,
+		WorkingDir: workingDir,
+		Date:       time.Now(),
+		GitBranch:  gitBranch(workingDir),
+		Tools:      tools,
+	}
+}
+
+// String formats the environment for the system prompt.
+func (e Environment) String() string {
+	var sb strings.Builder
+	sb.WriteString("Environment:\n")
+	fmt.Fprintf(&sb, "- Operating system: %s\n", e.OS)
+	fmt.Fprintf(&sb, "- Working directory: %s\n", e.WorkingDir)
+	fmt.Fprintf(&sb, "- Date: %s\n", e.Date.Format("2006-01-02"))
+	if e.GitBranch != "" {
+		fmt.Fprintf(&sb, "- Git branch: %s\n", e.GitBranch)
+	} else {
+		sb.WriteString("- Not a git repository\n")
+	}
+	if len(e.Tools) > 0 {
+		fmt.Fprintf(&sb, "- Tools: %s\n", strings.Join(e.Tools, ", "))
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// gitBranch reads the current branch from the repository containing dir
+// without running git. It follows the .git file used by worktrees and
+// submodules.
+func gitBranch(dir string) string {
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+		gitDir := filepath.Join(dir, ".git")
+		info, err := os.Stat(gitDir)
+		if err == nil {
+			if !info.IsDir() {
+				gitDir = resolveGitFile(dir, gitDir)
+			}
+			return readHead(gitDir)
+		}
+		if filepath.Dir(dir) == dir {
+			return ""
+		}
+	}
+}
+
+// resolveGitFile returns the directory named by a "gitdir: <path>" file.
+func resolveGitFile(dir, path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir:")
+	if !ok {
+		return ""
+	}
+	target = strings.TrimSpace(target)
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(dir, target)
+	}
+	return target
+}
+
+func readHead(gitDir string) string {
+	data, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	head := strings.TrimSpace(string(data))
+	if ref, ok := strings.CutPrefix(head, "ref: "); ok {
+		return strings.TrimPrefix(ref, "refs/heads/")
+	}
+	if len(head) > 12 {
+		return head[:12] + " (detached)"
+	}
+	return head
+}
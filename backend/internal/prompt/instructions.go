@@ -0,0 +1,97 @@
+package prompt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+// InstructionFileNames are the files a project uses to tell the agent about
+// its conventions. When a directory has more than one, all are used.
+var InstructionFileNames = []string{"AGENTS.md", "KLAUDKOD.md"}
+
+const (
+	// DefaultMaxFileBytes and DefaultMaxTotalBytes keep instruction files
+	// from crowding out the conversation.
+	DefaultMaxFileBytes  = 16 * 1024
+	DefaultMaxTotalBytes = 48 * 1024
+)
+
+// Instruction is the content of one instruction file.
+type Instruction struct {
+	Path    string
+	Content string
+	// Truncated is set when Content was cut to fit the size limits.
+	Truncated bool
+}
+
+// FindInstructions collects instruction files from userDir, for the user's
+// own preferences, and from workingDir and each of its parents. They are
+// returned from most general to most specific: the user's files first, then
+// from the filesystem root down to workingDir, so that the closest file has
+// the last word. Each file is cut to maxFileBytes and files are dropped,
+// most general first, once they exceed maxTotalBytes in total.
+func FindInstructions(workingDir, userDir string, maxFileBytes, maxTotalBytes int) []Instruction {
+	var dirs []string
+	for dir := filepath.Clean(workingDir); ; dir = filepath.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	if userDir != "" {
+		dirs = append([]string{filepath.Clean(userDir)}, dirs...)
+	}
+
+	var found []Instruction
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		for _, name := range InstructionFileNames {
+			path := filepath.Join(dir, name)
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+
+			data, err := os.ReadFile(path)
+			if err != nil || len(data) == 0 {
+				continue
+			}
+			found = append(found, newInstruction(path, data, maxFileBytes))
+		}
+	}
+
+	// Drop the most general files until the rest fit.
+	total := 0
+	for _, inst := range found {
+		total += len(inst.Content)
+	}
+	for maxTotalBytes > 0 && total > maxTotalBytes && len(found) > 0 {
+		total -= len(found[0].Content)
+		found = found[1:]
+	}
+	return found
+}
+
+func newInstruction(path string, data []byte, maxBytes int) Instruction {
+	inst := Instruction{Path: path, Content: string(data)}
+	if maxBytes > 0 && len(data) > maxBytes {
+		cut := maxBytes
+		for cut > 0 && !utf8.RuneStart(data[cut]) {
+			cut--
+		}
+		inst.Content = string(data[:cut])
+		inst.Truncated = true
+	}
+	return inst
+}
+
+// String formats the instruction for the system prompt.
+func (i Instruction) String() string {
+	s := fmt.Sprintf("Instructions from %s:\n\n%s", i.Path, i.Content)
+	if i.Truncated {
+		s += "\n\n[Truncated. Read the file for the rest.]"
+	}
+	return s
+}
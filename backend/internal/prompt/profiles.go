@@ -0,0 +1,172 @@
+package prompt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultProfile is used when a session doesn't ask for one.
+const DefaultProfile = "default"
+
+// contextTemplate renders the environment and instruction files. Profiles
+// include it with {{template "context" .}}.
+const contextTemplate = `{{define "context"}}{{.Environment}}{{if .Instructions}}
+
+The following instruction files describe the user's preferences and the project's conventions. Follow them unless they conflict with the security rules; later files are more specific and take precedence over earlier ones.{{range .Instructions}}
+
+{{.}}{{end}}{{end}}{{end}}`
+
+// builtinProfiles are available without any configuration. A template
+// file with the same name replaces one.
+var builtinProfiles = map[string]string{
+	DefaultProfile: `{{template "context" .}}`,
+
+	"reviewer": `You are reviewing code, not writing it. Read the relevant files and report bugs, risky changes, missing tests and style problems, most important first, each with its file path and line number. Don't modify files or run commands that change the workspace unless the user asks you to.
+
+{{template "context" .}}`,
+
+	"refactorer": `You are refactoring the code in {{.WorkingDir}}. Improve its structure without changing its behavior: keep public APIs stable unless asked otherwise, work in small steps, and run the project's build and tests after each one{{if .GitBranch}} on branch {{.GitBranch}}{{end}}. Stop and report if a test fails that you can't fix without changing behavior.
+
+{{template "context" .}}`,
+
+	"explainer": `You explain code. Answer the user's questions about how the code in {{.WorkingDir}} works by reading it, quoting the relevant parts with their file paths, and building up from the overall design to the details. Don't modify files.
+
+{{template "context" .}}`,
+}
+
+// Data is what profile templates can refer to.
+type Data struct {
+	OS           string
+	WorkingDir   string
+	Date         time.Time
+	GitBranch    string
+	Tools        []string
+	Instructions []Instruction
+}
+
+// NewData combines the environment and instruction files for a template.
+func NewData(env Environment, instructions []Instruction) Data {
+	return Data{
+		OS:           env.OS,
+		WorkingDir:   env.WorkingDir,
+		Date:         env.Date,
+		GitBranch:    env.GitBranch,
+		Tools:        env.Tools,
+		Instructions: instructions,
+	}
+}
+
+// Environment formats the environment facts, for {{.Environment}}.
+func (d Data) Environment() string {
+	return Environment{
+		OS:         d.OS,
+		WorkingDir: d.WorkingDir,
+		Date:       d.Date,
+		GitBranch:  d.GitBranch,
+		Tools:      d.Tools,
+	}.String()
+}
+
+// Profiles are named system prompt templates.
+type Profiles struct {
+	templates map[string]*template.Template
+}
+
+// DefaultProfiles returns the built-in profiles.
+func DefaultProfiles() *Profiles {
+	p := &Profiles{templates: make(map[string]*template.Template)}
+	for name, text := range builtinProfiles {
+		tmpl, err := parseProfile(name, text)
+		if err != nil {
+			panic(fmt.Sprintf("built-in profile %s: %v", name, err))
+		}
+		p.templates[name] = tmpl
+	}
+	return p
+}
+
+// LoadProfiles returns the built-in profiles together with the templates in
+// dir, one per <name>.tmpl file. A missing directory is not an error. Files
+// that fail to parse are skipped and reported in the returned error.
+func LoadProfiles(dir string) (*Profiles, error) {
+	p := DefaultProfiles()
+	if dir == "" {
+		return p, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return p, err
+	}
+
+	var errs []error
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read prompt profile: %w", err))
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".tmpl")
+		tmpl, err := parseProfile(name, string(data))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid prompt profile %s: %w", path, err))
+			continue
+		}
+		p.templates[name] = tmpl
+	}
+	return p, errors.Join(errs...)
+}
+
+func parseProfile(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).
+		Funcs(template.FuncMap{"join": strings.Join}).
+		Option("missingkey=error").
+		Parse(contextTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return tmpl.Parse(text)
+}
+
+// Names returns the profile names in alphabetical order.
+func (p *Profiles) Names() []string {
+	names := make([]string, 0, len(p.templates))
+	for name := range p.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Has reports whether a profile exists.
+func (p *Profiles) Has(name string) bool {
+	_, ok := p.templates[name]
+	return ok
+}
+
+// Render builds the system prompt for a profile. The security preamble
+// always comes first, whatever the profile says.
+func (p *Profiles) Render(name string, data Data) (string, error) {
+	tmpl, ok := p.templates[name]
+	if !ok {
+		return "", fmt.Errorf("unknown prompt profile %q", name)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt profile %s: %w", name, err)
+	}
+
+	rendered := strings.TrimSpace(buf.String())
+	if rendered == "" {
+		return securityPreamble, nil
+	}
+	return securityPreamble + "\n\n" + rendered, nil
+}
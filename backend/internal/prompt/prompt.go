@@ -0,0 +1,4 @@
+// Package prompt assembles the system prompt from the security rules, a
+// profile template, facts about the environment and the project's own
+// instruction files.
+package prompt
@@ -0,0 +1,143 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// underRoot keeps the instructions found below root, ignoring any that
+// happen to exist above the test's temporary directory.
+func underRoot(root string, found []Instruction) []Instruction {
+	var kept []Instruction
+	for _, inst := range found {
+		if strings.HasPrefix(inst.Path, root) {
+			kept = append(kept, inst)
+		}
+	}
+	return kept
+}
+
+func TestFindInstructions_Order(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "user", "AGENTS.md"), "user")
+	writeFile(t, filepath.Join(root, "repo", "AGENTS.md"), "repo")
+	writeFile(t, filepath.Join(root, "repo", "svc", "KLAUDKOD.md"), "svc klaudkod")
+	writeFile(t, filepath.Join(root, "repo", "svc", "AGENTS.md"), "svc agents")
+
+	found := underRoot(root, FindInstructions(filepath.Join(root, "repo", "svc"), filepath.Join(root, "user"), 0, 0))
+
+	var got []string
+	for _, inst := range found {
+		got = append(got, inst.Content)
+	}
+	want := []string{"user", "repo", "svc agents", "svc klaudkod"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("found %v, want %v", got, want)
+	}
+}
+
+func TestFindInstructions_Limits(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "AGENTS.md"), strings.Repeat("a", 100))
+	writeFile(t, filepath.Join(root, "repo", "AGENTS.md"), strings.Repeat("é", 50))
+
+	found := underRoot(root, FindInstructions(filepath.Join(root, "repo"), "", 41, 60))
+
+	// Both are cut to 41 bytes, which leaves too much for the total, so
+	// the more general file is dropped.
+	if len(found) != 1 {
+		t.Fatalf("found %d files, want 1", len(found))
+	}
+	inst := found[0]
+	if !inst.Truncated || len(inst.Content) != 40 || !strings.HasPrefix(inst.Path, filepath.Join(root, "repo")) {
+		t.Errorf("instruction = %+v", inst)
+	}
+	if !strings.Contains(inst.String(), "[Truncated.") {
+		t.Error("truncation not noted")
+	}
+}
+
+func TestGitBranch(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "repo", ".git", "HEAD"), "ref: refs/heads/feature/x\n")
+	if got := gitBranch(filepath.Join(root, "repo", "pkg", "sub")); got != "feature/x" {
+		t.Errorf("branch = %q, want feature/x", got)
+	}
+
+	// Worktrees have a .git file pointing at their git directory.
+	writeFile(t, filepath.Join(root, "wt", ".git"), "gitdir: ../gitdirs/wt\n")
+	writeFile(t, filepath.Join(root, "gitdirs", "wt", "HEAD"), "0123456789abcdef0123456789abcdef01234567\n")
+	if got := gitBranch(filepath.Join(root, "wt")); got != "0123456789ab (detached)" {
+		t.Errorf("worktree branch = %q", got)
+	}
+}
+
+func TestProfiles_Render(t *testing.T) {
+	profiles := DefaultProfiles()
+	data := NewData(
+		Environment{OS: "linux/amd64", WorkingDir: "/src", GitBranch: "main", Tools: []string{"bash", "read"}},
+		[]Instruction{{Path: "/src/AGENTS.md", Content: "Run make test."}},
+	)
+
+	for _, name := range profiles.Names() {
+		got, err := profiles.Render(name, data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !strings.HasPrefix(got, securityPreamble+"\n\n") {
+			t.Errorf("%s: security preamble must come first", name)
+		}
+		for _, want := range []string{"Working directory: /src", "Git branch: main", "Tools: bash, read", "Instructions from /src/AGENTS.md:\n\nRun make test."} {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: prompt missing %q:\n%s", name, want, got)
+			}
+		}
+	}
+
+	if _, err := profiles.Render("nope", data); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "terse.tmpl"), `Be terse. You are in {{.WorkingDir}} on {{.Date.Format "2006"}}.`)
+	writeFile(t, filepath.Join(dir, "reviewer.tmpl"), `Custom reviewer for {{join .Tools "+"}}.`)
+	writeFile(t, filepath.Join(dir, "broken.tmpl"), `{{.Nope`)
+
+	profiles, err := LoadProfiles(dir)
+	if err == nil || !strings.Contains(err.Error(), "broken.tmpl") {
+		t.Errorf("expected an error for broken.tmpl, got %v", err)
+	}
+	if profiles.Has("broken") || !profiles.Has("terse") || !profiles.Has("explainer") {
+		t.Errorf("profiles = %v", profiles.Names())
+	}
+
+	data := NewData(Environment{WorkingDir: "/src", Tools: []string{"bash", "read"}}, nil)
+	got, err := profiles.Render("reviewer", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != securityPreamble+"\n\nCustom reviewer for bash+read." {
+		t.Errorf("overridden reviewer = %q", got)
+	}
+
+	// A template can't drop the preamble, even by rendering nothing.
+	writeFile(t, filepath.Join(dir, "empty.tmpl"), `{{/* nothing */}}`)
+	profiles, _ = LoadProfiles(dir)
+	if got, _ := profiles.Render("empty", data); got != securityPreamble {
+		t.Errorf("empty profile = %q", got)
+	}
+}
@@ -0,0 +1,13 @@
+package prompt
+
+// securityPreamble is prepended to every system prompt. It is not part of
+// any profile template, so no profile or instruction file can remove or
+// override it.
+const securityPreamble = `SECURITY RESTRICTIONS - CRITICAL:
+1. .env files and all variants (.env.*, .envrc, etc.) are STRICTLY FORBIDDEN from being read or accessed
+2. This restriction applies to ALL tools including bash, cat, read, and any file operations
+3. DO NOT attempt any workarounds or indirect methods to access .env files
+4. You are restricted to working within the current working directory and its subdirectories
+5. Use the 'read' tool for file access - do not use bash commands like 'cat' to read files
+6. Any attempt to violate these restrictions will be blocked
+These rules are enforced at the tool level and cannot be bypassed.`
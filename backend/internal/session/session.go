@@ -0,0 +1,337 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jack/klaudkod/backend/internal/llm"
+)
+
+// Session is a saved conversation together with what it cost. Title,
+// Messages and usage may be updated while the session is being saved, such
+// as by title generation running alongside the first turn, and must be
+// changed through its methods then.
+type Session struct {
+	ID         string        `json:"id"`
+	Title      string        `json:"title,omitempty"`
+	WorkingDir string        `json:"working_dir"`
+	Profile    string        `json:"profile,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	Messages   []llm.Message `json:"messages"`
+	// Usage is the total over all models; ModelUsage breaks it down by
+	// model, including background tasks such as title generation.
+	Usage      llm.Usage            `json:"usage"`
+	ModelUsage map[string]llm.Usage `json:"model_usage,omitempty"`
+
+	mu sync.Mutex
+}
+
+// AddUsage records usage for model and returns the session's new total.
+func (s *Session) AddUsage(model string, usage llm.Usage) llm.Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Usage.Add(usage)
+	if s.ModelUsage == nil {
+		s.ModelUsage = make(map[string]llm.Usage)
+	}
+	total := s.ModelUsage[model]
+	total.Add(usage)
+	s.ModelUsage[model] = total
+	return s.Usage
+}
+
+// SetTitle names the conversation.
+func (s *Session) SetTitle(title string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Title = title
+}
+
+// SetMessages replaces the conversation history.
+func (s *Session) SetMessages(messages []llm.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Messages = messages
+}
+
+// Store keeps sessions as JSON files in a directory, one per session.
+// Images in their messages are kept once each in an images subdirectory,
+// named by the SHA-256 of their data, and the sessions refer to them.
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// New starts a session for a conversation in workingDir. It is not written
+// until it is saved.
+func New(workingDir string) *Session {
+	now := time.Now()
+	return &Session{
+		ID:         newID(now),
+		WorkingDir: workingDir,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+}
+
+// Save writes sess, replacing any earlier copy.
+func (s *Store) Save(sess *Session) error {
+	// Encode under the store lock too, so that concurrent saves of the
+	// same session are written in the order they were taken.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess.mu.Lock()
+	sess.UpdatedAt = time.Now()
+	messages := sess.Messages
+	stored, err := s.storeImages(messages)
+	var data []byte
+	if err == nil {
+		sess.Messages = stored
+		data, err = json.MarshalIndent(sess, "", "  ")
+		sess.Messages = messages
+	}
+	sess.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a session
+	// half written.
+	path := s.path(sess.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	return nil
+}
+
+// Load reads the session with the given ID. Images whose data has gone
+// missing are left out of its messages.
+func (s *Store) Load(id string) (*Session, error) {
+	sess, err := s.load(id)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sess.Messages {
+		sess.Messages[i].Images = s.loadImages(sess.Messages[i].Images)
+	}
+	return sess, nil
+}
+
+// load reads the session with the given ID without its images' data.
+func (s *Store) load(id string) (*Session, error) {
+	if !validID.MatchString(id) {
+		return nil, fmt.Errorf("invalid session ID: %q", id)
+	}
+
+	s.mu.Lock()
+	data, err := os.ReadFile(s.path(id))
+	s.mu.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("session not found: %s", id)
+		}
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+
+	var sess Session
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return nil, fmt.Errorf("failed to parse session %s: %w", id, err)
+	}
+	return &sess, nil
+}
+
+// List returns all saved sessions, most recently updated first.
+func (s *Store) List() ([]*Session, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	var sessions []*Session
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		sess, err := s.load(id)
+		if err != nil {
+			// Skip files we can't read rather than hiding every other
+			// session's usage.
+			continue
+		}
+		sessions = append(sessions, sess)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt)
+	})
+	return sessions, nil
+}
+
+// RepositoryUsage is the combined usage of all sessions in one working
+// directory.
+type RepositoryUsage struct {
+	WorkingDir string               `json:"working_dir"`
+	Sessions   int                  `json:"sessions"`
+	Usage      llm.Usage            `json:"usage"`
+	ModelUsage map[string]llm.Usage `json:"model_usage,omitempty"`
+}
+
+// UsageByRepository sums session usage per working directory, most
+// expensive first.
+func (s *Store) UsageByRepository() ([]RepositoryUsage, error) {
+	sessions, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	byDir := make(map[string]*RepositoryUsage)
+	for _, sess := range sessions {
+		repo, ok := byDir[sess.WorkingDir]
+		if !ok {
+			repo = &RepositoryUsage{
+				WorkingDir: sess.WorkingDir,
+				ModelUsage: make(map[string]llm.Usage),
+			}
+			byDir[sess.WorkingDir] = repo
+		}
+		repo.Sessions++
+		repo.Usage.Add(sess.Usage)
+		for model, usage := range sess.ModelUsage {
+			total := repo.ModelUsage[model]
+			total.Add(usage)
+			repo.ModelUsage[model] = total
+		}
+	}
+
+	repos := make([]RepositoryUsage, 0, len(byDir))
+	for _, repo := range byDir {
+		repos = append(repos, *repo)
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		if repos[i].Usage.Cost != repos[j].Usage.Cost {
+			return repos[i].Usage.Cost > repos[j].Usage.Cost
+		}
+		return repos[i].WorkingDir < repos[j].WorkingDir
+	})
+	return repos, nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *Store) imagePath(sum string) string {
+	return filepath.Join(s.dir, "images", sum)
+}
+
+// storeImages writes the images in messages that aren't stored yet and
+// returns a copy of messages that refers to them by hash instead of holding
+// their data.
+func (s *Store) storeImages(messages []llm.Message) ([]llm.Message, error) {
+	var out []llm.Message
+	for i, msg := range messages {
+		if len(msg.Images) == 0 {
+			continue
+		}
+		if out == nil {
+			out = make([]llm.Message, len(messages))
+			copy(out, messages)
+		}
+		images := make([]llm.Image, len(msg.Images))
+		for j, img := range msg.Images {
+			if len(img.Data) > 0 {
+				sum := sha256.Sum256(img.Data)
+				img.SHA256 = hex.EncodeToString(sum[:])
+				if err := s.writeImage(img.SHA256, img.Data); err != nil {
+					return nil, err
+				}
+				img.Data = nil
+			}
+			images[j] = img
+		}
+		out[i].Images = images
+	}
+	if out == nil {
+		return messages, nil
+	}
+	return out, nil
+}
+
+// writeImage stores data under its hash unless it is already there.
+func (s *Store) writeImage(sum string, data []byte) error {
+	path := s.imagePath(sum)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to store image: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to store image: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to store image: %w", err)
+	}
+	return nil
+}
+
+// loadImages reads the data of images saved by reference, dropping those
+// that can't be read.
+func (s *Store) loadImages(images []llm.Image) []llm.Image {
+	var out []llm.Image
+	for _, img := range images {
+		if len(img.Data) == 0 {
+			if !validImageSum.MatchString(img.SHA256) {
+				continue
+			}
+			data, err := os.ReadFile(s.imagePath(img.SHA256))
+			if err != nil {
+				continue
+			}
+			img.Data = data
+		}
+		out = append(out, img)
+	}
+	return out
+}
+
+// validID matches the IDs made by newID, so that IDs received from clients
+// can't name files outside the store.
+var validID = regexp.MustCompile(`^[0-9]{8}-[0-9]{6}-[0-9a-f]{8}$`)
+
+// validImageSum matches the hashes images are stored under.
+var validImageSum = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// newID returns a sortable, unique session ID.
+func newID(now time.Time) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return now.Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
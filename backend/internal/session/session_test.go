@@ -0,0 +1,129 @@
+package session
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jack/klaudkod/backend/internal/llm"
+)
+
+func TestStore_SaveAndLoad(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+
+	sess := New("/src/app")
+	sess.Title = "Fix the build"
+	sess.Messages = []llm.Message{{Role: "user", Content: "hi"}}
+	sess.AddUsage("gpt-4o", llm.Usage{PromptTokens: 10, CompletionTokens: 5, Cost: 0.01})
+	if err := store.Save(sess); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := store.Load(sess.ID)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.Title != sess.Title || len(loaded.Messages) != 1 || loaded.Usage != sess.Usage {
+		t.Errorf("loaded session = %+v, want %+v", loaded, sess)
+	}
+
+	if _, err := store.Load("../../etc/passwd"); err == nil {
+		t.Error("expected an error for an invalid ID")
+	}
+}
+
+func TestStore_UsageByRepository(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+
+	for _, s := range []struct {
+		dir   string
+		model string
+		cost  float64
+	}{
+		{"/src/app", "gpt-4o", 0.10},
+		{"/src/app", "gpt-4o-mini", 0.01},
+		{"/src/lib", "gpt-4o", 0.50},
+	} {
+		sess := New(s.dir)
+		sess.AddUsage(s.model, llm.Usage{PromptTokens: 100, Cost: s.cost})
+		if err := store.Save(sess); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	repos, err := store.UsageByRepository()
+	if err != nil {
+		t.Fatalf("UsageByRepository failed: %v", err)
+	}
+	if len(repos) != 2 {
+		t.Fatalf("got %d repositories, want 2: %+v", len(repos), repos)
+	}
+
+	lib, app := repos[0], repos[1]
+	if lib.WorkingDir != "/src/lib" || app.WorkingDir != "/src/app" {
+		t.Fatalf("repositories not sorted by cost: %+v", repos)
+	}
+	if app.Sessions != 2 || app.Usage.PromptTokens != 200 || len(app.ModelUsage) != 2 {
+		t.Errorf("app usage = %+v", app)
+	}
+}
+
+func TestStore_Images(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+
+	img := llm.Image{MediaType: "image/png", Data: []byte("\x89PNG\r\n\x1a\nscreenshot")}
+	sess := New("/src/app")
+	sess.Messages = []llm.Message{
+		{Role: "user", Content: "what is this?", Images: []llm.Image{img}},
+		{Role: "tool", Content: "Image a.png is attached.", Images: []llm.Image{img}},
+	}
+	if err := store.Save(sess); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, sess.ID+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), base64.StdEncoding.EncodeToString(img.Data)) {
+		t.Error("session file holds the image data")
+	}
+	if entries, err := os.ReadDir(filepath.Join(dir, "images")); err != nil || len(entries) != 1 {
+		t.Errorf("images = %v (%v), want the image stored once", entries, err)
+	}
+	if len(sess.Messages[0].Images[0].Data) == 0 {
+		t.Error("saving dropped the image data from the session in memory")
+	}
+
+	loaded, err := store.Load(sess.ID)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	for i, msg := range loaded.Messages {
+		if len(msg.Images) != 1 || !bytes.Equal(msg.Images[0].Data, img.Data) || msg.Images[0].MediaType != img.MediaType {
+			t.Errorf("message %d images = %+v", i, msg.Images)
+		}
+	}
+
+	os.RemoveAll(filepath.Join(dir, "images"))
+	loaded, err = store.Load(sess.ID)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(loaded.Messages[0].Images) != 0 {
+		t.Errorf("missing image was not dropped: %+v", loaded.Messages[0].Images)
+	}
+}
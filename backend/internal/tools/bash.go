@@ -1,9 +1,9 @@
 package tools
 
 import (
-	"bytes"
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
 	"time"
@@ -13,6 +13,11 @@ type BashTool struct {
 	workingDir      string
 	defaultTimeout  time.Duration
 	maxOutputLength int
+	headLines       int
+	tailLines       int
+	scratchDir      string
+	scratchMaxBytes int64
+	scratchMaxAge   time.Duration
 }
 
 func NewBashTool(workingDir string) *BashTool {
@@ -20,9 +25,30 @@ func NewBashTool(workingDir string) *BashTool {
 		workingDir:      workingDir,
 		defaultTimeout:  2 * time.Minute,
 		maxOutputLength: 30000,
+		headLines:       100,
+		tailLines:       100,
 	}
 }
 
+// SetOutputLimits configures how much output is returned inline. Output longer
+// than maxBytes or headLines+tailLines lines keeps only its head and tail.
+func (b *BashTool) SetOutputLimits(maxBytes, headLines, tailLines int) {
+	b.maxOutputLength = maxBytes
+	b.headLines = headLines
+	b.tailLines = tailLines
+}
+
+// SetScratchDir enables saving the full output of truncated commands to files
+// in dir, so the model can page through them with the read tool. Saved files
+// older than maxAge are removed, as are the oldest ones once the directory
+// holds more than maxBytes; zero disables either limit.
+func (b *BashTool) SetScratchDir(dir string, maxBytes int64, maxAge time.Duration) {
+	b.scratchDir = dir
+	b.scratchMaxBytes = maxBytes
+	b.scratchMaxAge = maxAge
+	pruneScratchDir(dir, maxBytes, maxAge, "")
+}
+
 func (b *BashTool) Name() string {
 	return "bash"
 }
@@ -47,6 +73,10 @@ func (b *BashTool) Parameters() map[string]interface{} {
 				"type":        "string",
 				"description": fmt.Sprintf("The working directory to run the command in. Defaults to %s. Use this instead of 'cd' commands.", b.workingDir),
 			},
+			"labelStreams": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Prefix each output line with [stdout] or [stderr] to tell the streams apart (defaults to false)",
+			},
 			"description": map[string]interface{}{
 				"type":        "string",
 				"description": "Clear, concise description of what this command does in 5-10 words. Examples:\nInput: ls\nOutput: Lists files in current directory\n\nInput: git status\nOutput: Shows working tree status\n\nInput: npm install\nOutput: Installs package dependencies\n\nInput: mkdir foo\nOutput: Creates directory 'foo'",
@@ -81,48 +111,110 @@ func (b *BashTool) Execute(ctx context.Context, args map[string]interface{}) (To
 		}
 	}
 
+	labelStreams, _ := args["labelStreams"].(bool)
+
 	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(cmdCtx, "sh", "-c", command)
 	cmd.Dir = workdir
+	// Don't hang on background processes that keep the output pipes open
+	// after the shell itself has been killed.
+	cmd.WaitDelay = 2 * time.Second
+
+	recorder := &outputRecorder{label: labelStreams}
+	if labelStreams {
+		cmd.Stdout = recorder.stream("stdout")
+		cmd.Stderr = recorder.stream("stderr")
+	} else {
+		// A single writer makes exec share one pipe between both streams,
+		// which preserves their exact relative order.
+		w := recorder.stream("")
+		cmd.Stdout = w
+		cmd.Stderr = w
+	}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	started := time.Now()
+	err := cmd.Start()
+	if err != nil {
+		return ToolResult{
+			Content: fmt.Sprintf("Command failed to start: %v", err),
+			IsError: true,
+			Metadata: map[string]interface{}{
+				"status": bashStatusStartFailed,
+				"error":  err.Error(),
+			},
+		}, nil
+	}
+	err = cmd.Wait()
 
-	err := cmd.Run()
-	output := stdout.String()
+	exit := classifyExit(cmdCtx, cmd.ProcessState, err)
+	exit.Duration = time.Since(started)
 
-	if stderr.Len() > 0 {
-		output += "[stderr]" + stderr.String()
+	output := recorder.String()
+	metadata := map[string]interface{}{
+		"status":      exit.Status,
+		"exit_code":   exit.ExitCode,
+		"duration_ms": exit.Duration.Milliseconds(),
+	}
+	if exit.Signal != "" {
+		metadata["signal"] = exit.Signal
 	}
 
-	var metadata []string
-	metadata = append(metadata, "<bash_metadata>")
-
-	if len(output) > b.maxOutputLength {
-		output = output[:b.maxOutputLength]
-		metadata = append(metadata, fmt.Sprintf("bash tool truncated output as it exceeded %d char limit", b.maxOutputLength))
+	// Metadata carries the structured exit status; the model only gets a
+	// note when something went wrong or output was left out.
+	var notes []string
+	if exit.OutputHeld {
+		metadata["output_held"] = true
+		notes = append(notes, fmt.Sprintf("background processes kept the output open after the command exited; anything they wrote after %v is missing", cmd.WaitDelay))
+	}
+	if truncated, ok := truncateOutput(output, b.maxOutputLength, b.headLines, b.tailLines); ok {
+		metadata["truncated"] = true
+		notes = append(notes, fmt.Sprintf("bash tool truncated output as it exceeded %d bytes or %d lines; only the first and last lines are shown", b.maxOutputLength, b.headLines+b.tailLines))
+		if path, err := b.saveFullOutput(output); err == nil {
+			metadata["full_output_path"] = path
+			notes = append(notes, fmt.Sprintf("full output saved to %s; use the read tool with offset and limit to view the omitted lines", path))
+		} else if b.scratchDir != "" {
+			notes = append(notes, fmt.Sprintf("failed to save full output: %v", err))
+		}
+		output = truncated
+	}
+	if len(notes) > 0 {
+		output += "\n\n<bash_metadata>\n" + strings.Join(notes, "\n") + "\n</bash_metadata>"
 	}
 
-	if cmdCtx.Err() == context.DeadlineExceeded {
-		metadata = append(metadata, fmt.Sprintf("bash tool terminated command after exceeding timeout %v", timeout))
+	result := ToolResult{
+		Content:  output,
+		IsError:  exit.Status != bashStatusSuccess,
+		Metadata: metadata,
 	}
 
-	if len(metadata) > 1 {
-		metadata = append(metadata, "</bash_metadata>")
-		output += "\n\n" + strings.Join(metadata, "\n")
+	if result.IsError {
+		result.Content = exit.describe(timeout) + "\n\n" + result.Content
 	}
 
-	result := ToolResult{
-		Content: output,
-		IsError: err != nil,
+	return result, nil
+}
+
+// saveFullOutput writes output to a new file in the scratch directory and
+// returns its path.
+func (b *BashTool) saveFullOutput(output string) (string, error) {
+	if b.scratchDir == "" {
+		return "", fmt.Errorf("no scratch directory configured")
+	}
+	if err := os.MkdirAll(b.scratchDir, 0755); err != nil {
+		return "", err
 	}
 
+	file, err := os.CreateTemp(b.scratchDir, "bash-*.log")
 	if err != nil {
-		result.Content = fmt.Sprintf("Command failed: %v\n\n%s", err, result.Content)
+		return "", err
 	}
+	defer file.Close()
 
-	return result, nil
-}
\ No newline at end of file
+	if _, err := file.WriteString(output); err != nil {
+		return "", err
+	}
+	pruneScratchDir(b.scratchDir, b.scratchMaxBytes, b.scratchMaxAge, file.Name())
+	return file.Name(), nil
+}
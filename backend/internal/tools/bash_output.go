@@ -0,0 +1,145 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	bashStatusSuccess     = "success"
+	bashStatusExitError   = "exit_error"
+	bashStatusTimeout     = "timeout"
+	bashStatusCanceled    = "canceled"
+	bashStatusStartFailed = "start_failed"
+)
+
+// outputRecorder collects the output of a command in the order it arrives.
+// When label is set, every line is prefixed with the name of the stream it
+// came from.
+type outputRecorder struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	label   bool
+	last    string
+	midLine bool
+}
+
+type streamWriter struct {
+	recorder *outputRecorder
+	name     string
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.recorder.write(w.name, p)
+	return len(p), nil
+}
+
+// stream returns a writer that records output under the given stream name.
+func (r *outputRecorder) stream(name string) io.Writer {
+	return &streamWriter{recorder: r, name: name}
+}
+
+func (r *outputRecorder) write(stream string, p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.label {
+		r.buf.Write(p)
+		return
+	}
+
+	// Keep labelled lines whole when the other stream interrupts mid-line.
+	if r.midLine && stream != r.last {
+		r.buf.WriteByte('\n')
+		r.midLine = false
+	}
+	r.last = stream
+
+	for len(p) > 0 {
+		if !r.midLine {
+			r.buf.WriteString("[" + stream + "] ")
+		}
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			r.buf.Write(p)
+			r.midLine = true
+			return
+		}
+		r.buf.Write(p[:i+1])
+		r.midLine = false
+		p = p[i+1:]
+	}
+}
+
+func (r *outputRecorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.String()
+}
+
+// bashExit describes how a command finished.
+type bashExit struct {
+	Status   string
+	ExitCode int
+	Signal   string
+	Duration time.Duration
+	// OutputHeld is set when the command exited but background processes
+	// kept its output open past WaitDelay, so later output may be missing.
+	OutputHeld bool
+}
+
+// classifyExit works out why a command stopped from its context, process
+// state and the error returned by Wait.
+func classifyExit(ctx context.Context, state *os.ProcessState, err error) bashExit {
+	exit := bashExit{Status: bashStatusSuccess, ExitCode: -1}
+
+	if state != nil {
+		exit.ExitCode = state.ExitCode()
+		if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			exit.Signal = ws.Signal().String()
+		}
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		exit.Status = bashStatusTimeout
+	case errors.Is(ctx.Err(), context.Canceled):
+		exit.Status = bashStatusCanceled
+	case errors.Is(err, exec.ErrWaitDelay):
+		// Wait only reports this when the command itself succeeded.
+		exit.OutputHeld = true
+	case err != nil:
+		exit.Status = bashStatusExitError
+	}
+
+	return exit
+}
+
+// describe renders the exit as a single line for the model.
+func (e bashExit) describe(timeout time.Duration) string {
+	switch e.Status {
+	case bashStatusTimeout:
+		msg := fmt.Sprintf("Command timed out after %v and was killed", timeout)
+		if e.Signal != "" {
+			msg += fmt.Sprintf(" (signal: %s)", e.Signal)
+		}
+		return msg
+	case bashStatusCanceled:
+		return "Command was canceled"
+	case bashStatusExitError:
+		if e.Signal != "" {
+			return fmt.Sprintf("Command was terminated by signal: %s", e.Signal)
+		}
+		return fmt.Sprintf("Command exited with code %d", e.ExitCode)
+	default:
+		return "Command exited with code 0"
+	}
+}
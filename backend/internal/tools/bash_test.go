@@ -0,0 +1,104 @@
+package tools
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBashTool_ExitStatus(t *testing.T) {
+	tmpDir := t.TempDir()
+	tool := NewBashTool(tmpDir)
+	ctx := context.Background()
+
+	tests := []struct {
+		name         string
+		args         map[string]interface{}
+		wantStatus   string
+		wantError    bool
+		wantContains []string
+		wantContent  string
+	}{
+		{
+			name:        "success",
+			args:        map[string]interface{}{"command": "echo hello"},
+			wantStatus:  bashStatusSuccess,
+			wantContent: "hello\n",
+		},
+		{
+			name:         "non-zero exit",
+			args:         map[string]interface{}{"command": "echo oops >&2; exit 3"},
+			wantStatus:   bashStatusExitError,
+			wantError:    true,
+			wantContains: []string{"oops", "Command exited with code 3"},
+		},
+		{
+			name:         "timeout",
+			args:         map[string]interface{}{"command": "sleep 5", "timeout": float64(100)},
+			wantStatus:   bashStatusTimeout,
+			wantError:    true,
+			wantContains: []string{"Command timed out after 100ms"},
+		},
+		{
+			name:         "background process holds output",
+			args:         map[string]interface{}{"command": "echo started; sleep 5 &"},
+			wantStatus:   bashStatusSuccess,
+			wantContains: []string{"started\n", "background processes kept the output open"},
+		},
+		{
+			name:         "start failure",
+			args:         map[string]interface{}{"command": "true", "workdir": filepath.Join(tmpDir, "missing")},
+			wantStatus:   bashStatusStartFailed,
+			wantError:    true,
+			wantContains: []string{"Command failed to start"},
+		},
+		{
+			name:         "labelled streams",
+			args:         map[string]interface{}{"command": "echo out; echo err >&2", "labelStreams": true},
+			wantStatus:   bashStatusSuccess,
+			wantContains: []string{"[stdout] out\n", "[stderr] err\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.args["description"] = "test command"
+			result, err := tool.Execute(ctx, tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.IsError != tt.wantError {
+				t.Errorf("IsError = %v, want %v", result.IsError, tt.wantError)
+			}
+			if status := result.Metadata["status"]; status != tt.wantStatus {
+				t.Errorf("status = %v, want %v", status, tt.wantStatus)
+			}
+			for _, s := range tt.wantContains {
+				if !strings.Contains(result.Content, s) {
+					t.Errorf("content missing %q:\n%s", s, result.Content)
+				}
+				if n := strings.Count(result.Content, s); n > 1 {
+					t.Errorf("content has %q %d times:\n%s", s, n, result.Content)
+				}
+			}
+			if tt.wantContent != "" && result.Content != tt.wantContent {
+				t.Errorf("content = %q, want %q", result.Content, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestBashTool_ExitCodeMetadata(t *testing.T) {
+	tool := NewBashTool(t.TempDir())
+	result, err := tool.Execute(context.Background(), map[string]interface{}{
+		"command":     "exit 7",
+		"description": "exit with code 7",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code := result.Metadata["exit_code"]; code != 7 {
+		t.Errorf("exit_code = %v, want 7", code)
+	}
+}
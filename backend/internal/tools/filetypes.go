@@ -0,0 +1,53 @@
+package tools
+
+import (
+	"sort"
+	"strings"
+)
+
+// fileTypes maps the file type names tools accept, as in ripgrep's --type,
+// to the globs of the files they cover.
+var fileTypes = map[string][]string{
+	"c":          {"*.c", "*.h"},
+	"cpp":        {"*.cpp", "*.cc", "*.cxx", "*.hpp", "*.hh", "*.hxx", "*.h"},
+	"cs":         {"*.cs"},
+	"css":        {"*.css", "*.scss", "*.sass", "*.less"},
+	"go":         {"*.go"},
+	"html":       {"*.html", "*.htm"},
+	"java":       {"*.java"},
+	"js":         {"*.js", "*.jsx", "*.mjs", "*.cjs"},
+	"json":       {"*.json"},
+	"kotlin":     {"*.kt", "*.kts"},
+	"md":         {"*.md", "*.markdown"},
+	"php":        {"*.php"},
+	"py":         {"*.py", "*.pyi"},
+	"ruby":       {"*.rb"},
+	"rust":       {"*.rs"},
+	"sh":         {"*.sh", "*.bash", "*.zsh"},
+	"sql":        {"*.sql"},
+	"swift":      {"*.swift"},
+	"toml":       {"*.toml"},
+	"ts":         {"*.ts", "*.tsx", "*.mts", "*.cts"},
+	"yaml":       {"*.yaml", "*.yml"},
+	"dockerfile": {"Dockerfile", "*.dockerfile"},
+}
+
+// fileTypeNames lists the known file types for error messages.
+func fileTypeNames() string {
+	names := make([]string, 0, len(fileTypes))
+	for name := range fileTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// fileTypeGlob returns a glob matching the names of files of a type, or
+// false if the type isn't known.
+func fileTypeGlob(name string) (string, bool) {
+	globs, ok := fileTypes[name]
+	if !ok {
+		return "", false
+	}
+	return "{" + strings.Join(globs, ",") + "}", true
+}
@@ -3,10 +3,21 @@ package tools
 import (
 	"context"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
+)
+
+const maxGlobResults = 1000
+
+// Kinds of entries a glob can return.
+const (
+	globEntryFile      = "file"
+	globEntryDirectory = "directory"
+	globEntryAny       = "any"
 )
 
 type GlobTool struct {
@@ -24,7 +35,7 @@ func (t *GlobTool) Name() string {
 }
 
 func (t *GlobTool) Description() string {
-	return "Find files matching a glob pattern. Supports ** for recursive matching (e.g., '**/*.go', 'src/**/*.ts')"
+	return "Find files matching a glob pattern. Supports ** for recursive matching (e.g., '**/*.go', 'src/**/*.ts') and braces for alternatives (e.g., '**/*.{ts,tsx}'). Results can be sorted by modification time, most recent first, to find recently changed files. Files excluded by .gitignore and .klaudkodignore are skipped unless noIgnore is set"
 }
 
 func (t *GlobTool) Parameters() map[string]interface{} {
@@ -33,17 +44,48 @@ func (t *GlobTool) Parameters() map[string]interface{} {
 		"properties": map[string]interface{}{
 			"pattern": map[string]interface{}{
 				"type":        "string",
-				"description": "Glob pattern to match files (e.g., '**/*.go', 'src/**/*.ts')",
+				"description": "Glob pattern to match paths relative to the search directory (e.g., '**/*.go', 'src/**/*.{ts,tsx}'). * and ? don't match /",
 			},
 			"path": map[string]interface{}{
 				"type":        "string",
 				"description": "Directory to search in (defaults to working directory)",
 			},
+			"exclude": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Glob patterns of paths to leave out of the results (e.g., ['**/*_test.go'])",
+			},
+			"ignoreCase": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Match patterns case-insensitively (defaults to false)",
+			},
+			"entryType": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{globEntryFile, globEntryDirectory, globEntryAny},
+				"description": "Whether to return files, directories or both (defaults to file). Directories are shown with a trailing /",
+			},
+			"maxDepth": map[string]interface{}{
+				"type":        "integer",
+				"description": "How many directory levels below path to search; 1 searches only path itself",
+			},
+			"sortBy": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"path", "mtime"},
+				"description": "path sorts results by path (default), mtime by modification time with the most recent first",
+			},
+			"noIgnore": noIgnoreParameter,
 		},
 		"required": []string{"pattern"},
 	}
 }
 
+// globMatch is a path a glob matched.
+type globMatch struct {
+	path    string
+	isDir   bool
+	modTime time.Time
+}
+
 func (t *GlobTool) Execute(ctx context.Context, args map[string]interface{}) (ToolResult, error) {
 	pattern, ok := args["pattern"].(string)
 	if !ok {
@@ -76,13 +118,56 @@ func (t *GlobTool) Execute(ctx context.Context, args map[string]interface{}) (To
 		return ToolResult{}, fmt.Errorf("path is not a directory: %s", searchPath)
 	}
 
-	var matches []string
-	err = filepath.Walk(searchPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
+	ignoreCase, _ := args["ignoreCase"].(bool)
+	matcher, err := compileGlob(pattern, ignoreCase)
+	if err != nil {
+		return ToolResult{}, err
+	}
+	var excludes []*globMatcher
+	if list, ok := args["exclude"].([]interface{}); ok {
+		for _, item := range list {
+			exclude, ok := item.(string)
+			if !ok {
+				return ToolResult{}, fmt.Errorf("exclude must be a list of glob patterns")
+			}
+			m, err := compileGlob(exclude, ignoreCase)
+			if err != nil {
+				return ToolResult{}, err
+			}
+			excludes = append(excludes, m)
 		}
+	}
+
+	entryType := globEntryFile
+	if e, ok := args["entryType"].(string); ok && e != "" {
+		if e != globEntryFile && e != globEntryDirectory && e != globEntryAny {
+			return ToolResult{}, fmt.Errorf("invalid entryType %q: use %s, %s or %s", e, globEntryFile, globEntryDirectory, globEntryAny)
+		}
+		entryType = e
+	}
+	sortBy := "path"
+	if s, ok := args["sortBy"].(string); ok && s != "" {
+		if s != "path" && s != "mtime" {
+			return ToolResult{}, fmt.Errorf("invalid sortBy %q: use path or mtime", s)
+		}
+		sortBy = s
+	}
+
+	noIgnore, _ := args["noIgnore"].(bool)
+	opts := walkOptions{
+		noIgnore: noIgnore,
+		dirs:     entryType != globEntryFile,
+	}
+	if d, ok := args["maxDepth"].(float64); ok {
+		if d < 1 {
+			return ToolResult{}, fmt.Errorf("maxDepth must be at least 1")
+		}
+		opts.maxDepth = int(d)
+	}
 
-		if info.IsDir() {
+	var matches []globMatch
+	err = walkFiles(ctx, searchPath, opts, func(path string, d fs.DirEntry) error {
+		if d.IsDir() && entryType == globEntryFile || !d.IsDir() && entryType == globEntryDirectory {
 			return nil
 		}
 
@@ -93,10 +178,24 @@ func (t *GlobTool) Execute(ctx context.Context, args map[string]interface{}) (To
 
 		relPath = filepath.ToSlash(relPath)
 
-		if matchGlob(pattern, relPath) {
-			matches = append(matches, path)
+		if !matcher.match(relPath) {
+			return nil
+		}
+		for _, exclude := range excludes {
+			if exclude.match(relPath) {
+				return nil
+			}
 		}
 
+		match := globMatch{path: path, isDir: d.IsDir()}
+		if sortBy == "mtime" {
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			match.modTime = info.ModTime()
+		}
+		matches = append(matches, match)
 		return nil
 	})
 
@@ -104,27 +203,38 @@ func (t *GlobTool) Execute(ctx context.Context, args map[string]interface{}) (To
 		return ToolResult{}, fmt.Errorf("failed to walk directory: %w", err)
 	}
 
-	sort.Strings(matches)
+	if sortBy == "mtime" {
+		sort.SliceStable(matches, func(i, j int) bool {
+			return matches[i].modTime.After(matches[j].modTime)
+		})
+	} else {
+		sort.Slice(matches, func(i, j int) bool {
+			return matches[i].path < matches[j].path
+		})
+	}
 
-	maxResults := 1000
-	if len(matches) > maxResults {
-		matches = matches[:maxResults]
+	truncated := len(matches) > maxGlobResults
+	if truncated {
+		matches = matches[:maxGlobResults]
 	}
 
 	var builder strings.Builder
 	builder.WriteString("<glob_results>\n")
 
 	for _, match := range matches {
-		relPath, err := filepath.Rel(t.workingDir, match)
+		relPath, err := filepath.Rel(t.workingDir, match.path)
 		if err != nil {
-			relPath = match
+			relPath = match.path
+		}
+		if match.isDir {
+			relPath += "/"
 		}
 		builder.WriteString(relPath + "\n")
 	}
 
 	builder.WriteString(fmt.Sprintf("\nFound %d matches", len(matches)))
-	if len(matches) == maxResults {
-		builder.WriteString(" (showing first 1000 results)")
+	if truncated {
+		builder.WriteString(fmt.Sprintf(" (showing first %d results)", maxGlobResults))
 	}
 	builder.WriteString("\n</glob_results>")
 
@@ -133,52 +243,3 @@ func (t *GlobTool) Execute(ctx context.Context, args map[string]interface{}) (To
 		IsError: false,
 	}, nil
 }
-
-func matchGlob(pattern, path string) bool {
-	if !strings.Contains(pattern, "**") {
-		matched, _ := filepath.Match(pattern, path)
-		return matched
-	}
-
-	patternParts := strings.Split(pattern, "/")
-	pathParts := strings.Split(path, "/")
-
-	return matchGlobRecursive(patternParts, pathParts)
-}
-
-func matchGlobRecursive(patternParts, pathParts []string) bool {
-	if len(patternParts) == 0 && len(pathParts) == 0 {
-		return true
-	}
-	if len(patternParts) == 0 {
-		return false
-	}
-	if len(pathParts) == 0 {
-		for _, part := range patternParts {
-			if part != "**" {
-				return false
-			}
-		}
-		return true
-	}
-
-	patternPart := patternParts[0]
-	pathPart := pathParts[0]
-
-	if patternPart == "**" {
-		if matchGlobRecursive(patternParts[1:], pathParts) {
-			return true
-		}
-		if matchGlobRecursive(patternParts, pathParts[1:]) {
-			return true
-		}
-		return false
-	}
-
-	matched, _ := filepath.Match(patternPart, pathPart)
-	if matched {
-		return matchGlobRecursive(patternParts[1:], pathParts[1:])
-	}
-
-	return false
-}
\ No newline at end of file
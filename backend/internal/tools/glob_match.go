@@ -0,0 +1,151 @@
+package tools
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// globMatcher is a glob pattern compiled to a regular expression. Go's
+// regexp package runs in time linear in the input, so patterns such as
+// **/**/**/x can't make matching backtrack.
+//
+// The syntax is that of filepath.Match, where * and ? don't match /, with:
+//   - ** as a whole path segment matching any number of segments, including
+//     none, so **/*.go matches main.go and a/b/main.go
+//   - {a,b} matching either alternative; braces can nest
+type globMatcher struct {
+	pattern string
+	regex   *regexp.Regexp
+}
+
+// compileGlob compiles pattern, matching case-insensitively if ignoreCase
+// is set.
+func compileGlob(pattern string, ignoreCase bool) (*globMatcher, error) {
+	var expr strings.Builder
+	if ignoreCase {
+		expr.WriteString("(?i)")
+	}
+	expr.WriteString("^")
+
+	depth := 0
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' && globSegmentStart(pattern, i) && globSegmentEnd(pattern, i+2) {
+				i++
+				switch {
+				case i+1 < len(pattern) && pattern[i+1] == '/':
+					// **/ matches any run of leading directories.
+					i++
+					expr.WriteString("(?:[^/]*/)*")
+				case i > 1 && pattern[i-2] == '/':
+					// A trailing /** matches the directory itself too. The /
+					// is already written, so it becomes optional.
+					s := strings.TrimSuffix(expr.String(), "/")
+					expr.Reset()
+					expr.WriteString(s)
+					expr.WriteString("(?:/.*)?")
+				default:
+					expr.WriteString(".*")
+				}
+				continue
+			}
+			expr.WriteString("[^/]*")
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end, class, err := globClass(pattern, i)
+			if err != nil {
+				return nil, err
+			}
+			expr.WriteString(class)
+			i = end
+		case '{':
+			depth++
+			expr.WriteString("(?:")
+		case '}':
+			if depth == 0 {
+				return nil, fmt.Errorf("invalid glob pattern %q: unmatched }", pattern)
+			}
+			depth--
+			expr.WriteString(")")
+		case ',':
+			if depth > 0 {
+				expr.WriteString("|")
+			} else {
+				expr.WriteString(",")
+			}
+		case '\\':
+			if i+1 == len(pattern) {
+				return nil, fmt.Errorf("invalid glob pattern %q: trailing \\", pattern)
+			}
+			i++
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("invalid glob pattern %q: unmatched {", pattern)
+	}
+	expr.WriteString("$")
+
+	regex, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+	}
+	return &globMatcher{pattern: pattern, regex: regex}, nil
+}
+
+// globSegmentStart reports whether position i of pattern starts a path
+// segment, or an alternative within braces.
+func globSegmentStart(pattern string, i int) bool {
+	return i == 0 || strings.IndexByte("/{,", pattern[i-1]) >= 0
+}
+
+// globSegmentEnd reports whether position i of pattern ends a path segment,
+// or an alternative within braces.
+func globSegmentEnd(pattern string, i int) bool {
+	return i == len(pattern) || strings.IndexByte("/},", pattern[i]) >= 0
+}
+
+// globClass translates the character class starting at position start of
+// pattern. It returns the position of the closing ] and the class as a
+// regular expression that never matches /.
+func globClass(pattern string, start int) (int, string, error) {
+	var class strings.Builder
+	class.WriteString("[")
+	i := start + 1
+	negated := i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^')
+	if negated {
+		class.WriteString("^/")
+		i++
+	}
+	for first := true; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == ']' && !first:
+			class.WriteString("]")
+			return i, class.String(), nil
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			class.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			class.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+		first = false
+	}
+	return 0, "", fmt.Errorf("invalid glob pattern %q: unterminated [", pattern)
+}
+
+// match reports whether path, with / separators, matches the pattern.
+func (m *globMatcher) match(path string) bool {
+	return m.regex.MatchString(path)
+}
+
+// String returns the pattern the matcher was compiled from.
+func (m *globMatcher) String() string {
+	return m.pattern
+}
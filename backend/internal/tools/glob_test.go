@@ -0,0 +1,151 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		ignoreCase    bool
+		want          bool
+	}{
+		{pattern: "*.go", path: "main.go", want: true},
+		{pattern: "*.go", path: "cmd/main.go", want: false},
+		{pattern: "**/*.go", path: "main.go", want: true},
+		{pattern: "**/*.go", path: "a/b/main.go", want: true},
+		{pattern: "src/**/*.ts", path: "src/app.ts", want: true},
+		{pattern: "src/**/*.ts", path: "src/a/b/app.ts", want: true},
+		{pattern: "src/**/*.ts", path: "lib/src/app.ts", want: false},
+		{pattern: "src/**", path: "src", want: true},
+		{pattern: "src/**", path: "src/a/b", want: true},
+		{pattern: "src/**", path: "srcs/a", want: false},
+		{pattern: "a**b", path: "axxb", want: true},
+		{pattern: "a**b", path: "ax/xb", want: false},
+		{pattern: "?.go", path: "a.go", want: true},
+		{pattern: "?.go", path: "ab.go", want: false},
+		{pattern: "*.{ts,tsx}", path: "app.tsx", want: true},
+		{pattern: "*.{ts,tsx}", path: "app.js", want: false},
+		{pattern: "{src,lib}/**/*.{c,h}", path: "lib/x/y.h", want: true},
+		{pattern: "{a,b{c,d}}.txt", path: "bd.txt", want: true},
+		{pattern: "{**/,}x", path: "a/b/x", want: true},
+		{pattern: "[a-c]*.go", path: "b.go", want: true},
+		{pattern: "[!a-c]*.go", path: "b.go", want: false},
+		{pattern: "a[!x]b", path: "a/b", want: false},
+		{pattern: "\\*.go", path: "*.go", want: true},
+		{pattern: "\\*.go", path: "a.go", want: false},
+		{pattern: "a.(go)+", path: "a.(go)+", want: true},
+		{pattern: "*.GO", path: "main.go", want: false},
+		{pattern: "*.GO", path: "main.go", ignoreCase: true, want: true},
+	}
+	for _, tt := range tests {
+		m, err := compileGlob(tt.pattern, tt.ignoreCase)
+		if err != nil {
+			t.Fatalf("compileGlob(%q): %v", tt.pattern, err)
+		}
+		if got := m.match(tt.path); got != tt.want {
+			t.Errorf("%q on %q: got %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+
+	for _, pattern := range []string{"{a,b", "a}", "[ab", "a\\"} {
+		if _, err := compileGlob(pattern, false); err == nil {
+			t.Errorf("compileGlob(%q) succeeded, want an error", pattern)
+		}
+	}
+}
+
+func TestCompileGlob_NoBacktracking(t *testing.T) {
+	m, err := compileGlob(strings.Repeat("**/", 30)+"x", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	path := strings.Repeat("a/", 200) + "y"
+
+	start := time.Now()
+	if m.match(path) {
+		t.Error("unexpected match")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("matching took %v", elapsed)
+	}
+}
+
+func TestGlobTool_Options(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"old.ts":            "",
+		"new.tsx":           "",
+		"README.MD":         "",
+		"src/app.ts":        "",
+		"src/app_test.ts":   "",
+		"src/deep/inner.ts": "",
+	})
+	base := time.Now().Add(-time.Hour)
+	for i, name := range []string{"src/app.ts", "old.ts", "new.tsx"} {
+		mtime := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(filepath.Join(dir, name), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tool := NewGlobTool(dir)
+
+	tests := []struct {
+		name string
+		args map[string]interface{}
+		want string
+	}{
+		{
+			name: "braces",
+			args: map[string]interface{}{"pattern": "*.{ts,tsx}"},
+			want: "new.tsx\nold.ts\n\nFound 2 matches",
+		},
+		{
+			name: "modification time",
+			args: map[string]interface{}{"pattern": "**/*.{ts,tsx}", "sortBy": "mtime", "maxDepth": 2.0},
+			want: "src/app_test.ts\nnew.tsx\nold.ts\nsrc/app.ts\n\nFound 4 matches",
+		},
+		{
+			name: "exclude",
+			args: map[string]interface{}{"pattern": "src/**", "exclude": []interface{}{"**/*_test.ts", "src/deep/**"}},
+			want: "src/app.ts\n\nFound 1 matches",
+		},
+		{
+			name: "ignore case",
+			args: map[string]interface{}{"pattern": "*.md", "ignoreCase": true},
+			want: "README.MD\n\nFound 1 matches",
+		},
+		{
+			name: "directories",
+			args: map[string]interface{}{"pattern": "**", "entryType": "directory"},
+			want: "src/\nsrc/deep/\n\nFound 2 matches",
+		},
+		{
+			name: "files and directories with max depth",
+			args: map[string]interface{}{"pattern": "src/*", "entryType": "any", "maxDepth": 2.0},
+			want: "src/app.ts\nsrc/app_test.ts\nsrc/deep/\n\nFound 3 matches",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tool.Execute(context.Background(), tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := "<glob_results>\n" + tt.want + "\n</glob_results>"
+			if result.Content != want {
+				t.Errorf("got\n%s\nwant\n%s", result.Content, want)
+			}
+		})
+	}
+
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"pattern": "*.{ts"}); err == nil {
+		t.Error("invalid pattern was accepted")
+	}
+}
@@ -1,24 +1,36 @@
 package tools
 
 import (
-	"bufio"
 	"context"
 	"fmt"
-	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 )
 
+const (
+	defaultGrepLimit = 100
+	maxGrepLimit     = 1000
+)
+
 type GrepTool struct {
 	workingDir string
-	maxResults int
+	workers    int
+	// rgPath is the ripgrep binary searches are delegated to, if it is
+	// installed.
+	rgPath   string
+	listings *ripgrepListings
 }
 
 func NewGrepTool(workingDir string) *GrepTool {
+	rgPath, _ := exec.LookPath("rg")
 	return &GrepTool{
 		workingDir: workingDir,
-		maxResults: 100,
+		workers:    runtime.NumCPU(),
+		rgPath:     rgPath,
+		listings:   newRipgrepListings(),
 	}
 }
 
@@ -27,7 +39,7 @@ func (t *GrepTool) Name() string {
 }
 
 func (t *GrepTool) Description() string {
-	return "Search for regex patterns in file contents. Supports file inclusion patterns and line-by-line matching"
+	return "Search for regex patterns in file contents. Supports context lines, case-insensitive, fixed-string and multiline search, file type and glob filters, and listing matching files or match counts instead of lines. Results are paginated with offset and limit. Files excluded by .gitignore and .klaudkodignore are skipped unless noIgnore is set"
 }
 
 func (t *GrepTool) Parameters() map[string]interface{} {
@@ -36,7 +48,7 @@ func (t *GrepTool) Parameters() map[string]interface{} {
 		"properties": map[string]interface{}{
 			"pattern": map[string]interface{}{
 				"type":        "string",
-				"description": "Regex pattern to search for",
+				"description": "Regex pattern to search for (Go RE2 syntax)",
 			},
 			"path": map[string]interface{}{
 				"type":        "string",
@@ -44,17 +56,78 @@ func (t *GrepTool) Parameters() map[string]interface{} {
 			},
 			"include": map[string]interface{}{
 				"type":        "string",
-				"description": "Glob pattern for files to include (e.g. '*.go')",
+				"description": "Glob pattern for the names of files to include (e.g. '*.go' or '*.{ts,tsx}')",
+			},
+			"type": map[string]interface{}{
+				"type":        "string",
+				"description": "File type to search, such as go, ts, py or rust. Known types: " + fileTypeNames(),
+			},
+			"outputMode": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{grepModeContent, grepModeFiles, grepModeCount},
+				"description": "content shows matching lines as path:line:text (default), files_with_matches lists matching files, count shows path:count per file",
+			},
+			"before": map[string]interface{}{
+				"type":        "integer",
+				"description": "Lines of context to show before each match, as path-line-text",
+			},
+			"after": map[string]interface{}{
+				"type":        "integer",
+				"description": "Lines of context to show after each match",
+			},
+			"context": map[string]interface{}{
+				"type":        "integer",
+				"description": "Lines of context to show before and after each match",
+			},
+			"ignoreCase": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Match case-insensitively (defaults to false)",
+			},
+			"fixedStrings": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Treat the pattern as a literal string rather than a regex (defaults to false)",
 			},
+			"multiline": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Let the pattern match across lines, with \\n matching line breaks. Use (?s) for . to match them too. Files over 16 MB are skipped (defaults to false)",
+			},
+			"offset": map[string]interface{}{
+				"type":        "integer",
+				"description": "Number of results to skip, for paging through long results",
+			},
+			"limit": map[string]interface{}{
+				"type":        "integer",
+				"description": fmt.Sprintf("Maximum number of results to return (defaults to %d, at most %d)", defaultGrepLimit, maxGrepLimit),
+			},
+			"noIgnore": noIgnoreParameter,
 		},
 		"required": []string{"pattern"},
 	}
 }
 
 func (t *GrepTool) Execute(ctx context.Context, args map[string]interface{}) (ToolResult, error) {
+	search, searchPath, err := t.newSearch(args)
+	if err != nil {
+		return ToolResult{}, err
+	}
+
+	result, err := t.search(ctx, search, searchPath)
+	if err != nil {
+		return ToolResult{}, fmt.Errorf("failed to walk directory: %w", err)
+	}
+
+	return ToolResult{
+		Content: t.format(search, result),
+		IsError: false,
+	}, nil
+}
+
+// newSearch builds the search the arguments ask for, and returns it with
+// the path to search.
+func (t *GrepTool) newSearch(args map[string]interface{}) (*grepSearch, string, error) {
 	pattern, ok := args["pattern"].(string)
 	if !ok {
-		return ToolResult{}, fmt.Errorf("pattern is required")
+		return nil, "", fmt.Errorf("pattern is required")
 	}
 
 	searchPath := t.workingDir
@@ -68,150 +141,141 @@ func (t *GrepTool) Execute(ctx context.Context, args map[string]interface{}) (To
 
 	searchPath = filepath.Clean(searchPath)
 	if !strings.HasPrefix(searchPath, t.workingDir+string(filepath.Separator)) && searchPath != t.workingDir {
-		return ToolResult{}, fmt.Errorf("access denied: path is outside working directory")
+		return nil, "", fmt.Errorf("access denied: path is outside working directory")
 	}
 
-	regex, err := regexp.Compile(pattern)
-	if err != nil {
-		return ToolResult{}, fmt.Errorf("invalid regex pattern: %w", err)
+	search := &grepSearch{
+		mode:     grepModeContent,
+		limit:    defaultGrepLimit,
+		workers:  t.workers,
+		listings: t.listings,
 	}
 
-	var includePattern string
-	if include, ok := args["include"].(string); ok {
-		includePattern = include
+	if fixed, _ := args["fixedStrings"].(bool); fixed {
+		pattern = regexp.QuoteMeta(pattern)
 	}
-
-	var matches []string
-	var filesSearched int
-	var totalMatches int
-
-	skipDirs := map[string]bool{
-		".git":        true,
-		"node_modules": true,
-		"vendor":      true,
-		"__pycache__": true,
-		".venv":       true,
+	search.multiline, _ = args["multiline"].(bool)
+	if search.multiline {
+		// Keep ^ and $ matching at line boundaries.
+		pattern = "(?m)" + pattern
+	}
+	if ignoreCase, _ := args["ignoreCase"].(bool); ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid regex pattern: %w", err)
 	}
+	search.regex = regex
 
-	err = filepath.Walk(searchPath, func(path string, info os.FileInfo, err error) error {
+	if include, ok := args["include"].(string); ok && include != "" {
+		search.include, err = compileGlob(include, false)
 		if err != nil {
-			return nil
-		}
-
-		if info.IsDir() {
-			if skipDirs[info.Name()] {
-				return filepath.SkipDir
-			}
-			return nil
+			return nil, "", err
 		}
-
-		if includePattern != "" {
-			relPath, err := filepath.Rel(searchPath, path)
-			if err != nil {
-				return nil
-			}
-			relPath = filepath.ToSlash(relPath)
-			if !matchGlob(includePattern, filepath.Base(relPath)) {
-				return nil
-			}
-		}
-
-		if t.isBinaryFile(path) {
-			return nil
+	}
+	if fileType, ok := args["type"].(string); ok && fileType != "" {
+		glob, known := fileTypeGlob(fileType)
+		if !known {
+			return nil, "", fmt.Errorf("unknown file type %q; known types: %s", fileType, fileTypeNames())
 		}
-
-		filesSearched++
-		fileMatches, err := t.searchFile(path, regex)
+		search.types, err = compileGlob(glob, false)
 		if err != nil {
-			return nil
+			return nil, "", err
 		}
-
-		for _, match := range fileMatches {
-			if totalMatches >= t.maxResults {
-				return nil
-			}
-			matches = append(matches, match)
-			totalMatches++
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		return ToolResult{}, fmt.Errorf("failed to walk directory: %w", err)
 	}
-
-	var builder strings.Builder
-	builder.WriteString("<grep_results>\n")
-
-	for _, match := range matches {
-		relPath, err := filepath.Rel(t.workingDir, strings.Split(match, ":")[0])
-		if err != nil {
-			relPath = strings.Split(match, ":")[0]
-		}
-		parts := strings.SplitN(match, ":", 3)
-		if len(parts) == 3 {
-			builder.WriteString(fmt.Sprintf("%s:%s:%s\n", relPath, parts[1], parts[2]))
+	if mode, ok := args["outputMode"].(string); ok && mode != "" {
+		if mode != grepModeContent && mode != grepModeFiles && mode != grepModeCount {
+			return nil, "", fmt.Errorf("invalid outputMode %q: use %s, %s or %s", mode, grepModeContent, grepModeFiles, grepModeCount)
 		}
+		search.mode = mode
 	}
 
-	builder.WriteString(fmt.Sprintf("\nFound %d matches in %d files", totalMatches, filesSearched))
-	if totalMatches == t.maxResults {
-		builder.WriteString(fmt.Sprintf(" (showing first %d results)", t.maxResults))
+	if c, ok := args["context"].(float64); ok {
+		search.before, search.after = int(c), int(c)
 	}
-	builder.WriteString("\n</grep_results>")
-
-	return ToolResult{
-		Content: builder.String(),
-		IsError: false,
-	}, nil
-}
-
-func (t *GrepTool) isBinaryFile(path string) bool {
-	file, err := os.Open(path)
-	if err != nil {
-		return true
+	if b, ok := args["before"].(float64); ok {
+		search.before = int(b)
 	}
-	defer file.Close()
-
-	buffer := make([]byte, 512)
-	n, err := file.Read(buffer)
-	if err != nil {
-		return true
+	if a, ok := args["after"].(float64); ok {
+		search.after = int(a)
 	}
-
-	for i := 0; i < n; i++ {
-		if buffer[i] == 0 {
-			return true
-		}
+	if o, ok := args["offset"].(float64); ok {
+		search.offset = int(o)
+	}
+	if l, ok := args["limit"].(float64); ok {
+		search.limit = int(l)
+	}
+	if search.before < 0 || search.after < 0 || search.offset < 0 || search.limit < 1 {
+		return nil, "", fmt.Errorf("context lines and offset must not be negative, and limit must be at least 1")
+	}
+	if search.limit > maxGrepLimit {
+		search.limit = maxGrepLimit
 	}
 
-	return false
+	noIgnore, _ := args["noIgnore"].(bool)
+	search.walk = walkOptions{noIgnore: noIgnore}
+	return search, searchPath, nil
 }
 
-func (t *GrepTool) searchFile(path string, regex *regexp.Regexp) ([]string, error) {
-	var matches []string
-
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
+// search runs a search with ripgrep when it is installed and can run it,
+// and with the built-in engine otherwise.
+func (t *GrepTool) search(ctx context.Context, search *grepSearch, root string) (grepResult, error) {
+	if t.rgPath != "" {
+		if result, ok := search.runRipgrep(ctx, t.rgPath, root); ok {
+			return result, nil
+		}
 	}
-	defer file.Close()
+	return search.run(ctx, root)
+}
 
-	scanner := bufio.NewScanner(file)
-	lineNum := 1
+// format renders results in the format of grep and ripgrep: path:line:text
+// for matching lines, path-line-text for context lines and "--" between
+// groups of lines that aren't contiguous.
+func (t *GrepTool) format(search *grepSearch, result grepResult) string {
+	var builder strings.Builder
+	builder.WriteString("<grep_results>\n")
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if regex.MatchString(line) {
-			matches = append(matches, fmt.Sprintf("%s:%d:%s", path, lineNum, line))
+	withContext := search.before > 0 || search.after > 0
+	matches := 0
+	for i, file := range result.files {
+		relPath, err := filepath.Rel(t.workingDir, file.path)
+		if err != nil {
+			relPath = file.path
 		}
-		lineNum++
+		switch search.mode {
+		case grepModeFiles:
+			builder.WriteString(relPath + "\n")
+		case grepModeCount:
+			builder.WriteString(fmt.Sprintf("%s:%d\n", relPath, file.count))
+		default:
+			for j, line := range file.lines {
+				if withContext && (j > 0 && line.line > file.lines[j-1].line+1 || j == 0 && i > 0) {
+					builder.WriteString("--\n")
+				}
+				sep := "-"
+				if line.match {
+					sep = ":"
+				}
+				builder.WriteString(fmt.Sprintf("%s%s%d%s%s\n", relPath, sep, line.line, sep, line.text))
+			}
+		}
+		matches += file.count
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	switch search.mode {
+	case grepModeFiles:
+		builder.WriteString(fmt.Sprintf("\nFound %d matching files", result.entries))
+	default:
+		builder.WriteString(fmt.Sprintf("\nFound %d matches in %d files", matches, len(result.files)))
 	}
-
-	return matches, nil
-}
\ No newline at end of file
+	if result.truncated {
+		if search.offset == 0 {
+			builder.WriteString(fmt.Sprintf(" (showing first %d results; use offset %d for more)", search.limit, search.limit))
+		} else {
+			builder.WriteString(fmt.Sprintf(" (showing results %d to %d; use offset %d for more)", search.offset+1, search.offset+search.limit, search.offset+search.limit))
+		}
+	}
+	builder.WriteString("\n</grep_results>")
+	return builder.String()
+}
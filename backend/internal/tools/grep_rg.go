@@ -0,0 +1,549 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ripgrepText is how ripgrep's JSON output carries paths and lines: as
+// text, or base64 encoded bytes when they aren't valid UTF-8.
+type ripgrepText struct {
+	Text  *string `json:"text"`
+	Bytes string  `json:"bytes"`
+}
+
+func (t ripgrepText) String() string {
+	if t.Text != nil {
+		return *t.Text
+	}
+	data, _ := base64.StdEncoding.DecodeString(t.Bytes)
+	return string(data)
+}
+
+// ripgrepMessage is one line of `rg --json` output. Only match and context
+// messages are used.
+type ripgrepMessage struct {
+	Type string `json:"type"`
+	Data struct {
+		Path       ripgrepText `json:"path"`
+		Lines      ripgrepText `json:"lines"`
+		LineNumber int         `json:"line_number"`
+	} `json:"data"`
+}
+
+// runRipgrep runs the search with ripgrep, which walks and searches much
+// faster than the built-in engine, and returns the same results. The
+// ignore rules are mapped onto ripgrep's own handling of ignore files. It
+// reports false when ripgrep can't reproduce them or read the files'
+// encodings, or fails, so that the built-in engine can run instead.
+//
+// Ripgrep searches files in parallel and reports them in any order, so
+// its results are put back in walk order here, keeping only the files that
+// can fall in the requested page.
+func (s *grepSearch) runRipgrep(ctx context.Context, rgPath, root string) (grepResult, bool) {
+	files, dir, ok := s.ripgrepFileArgs(ctx, rgPath, root)
+	if !ok {
+		return grepResult{}, false
+	}
+	transcoded, ok := s.listings.list("encodings\x00"+dir+"\x00"+strings.Join(files, "\x00"), func() ([]string, bool) {
+		return findTranscodedFiles(ctx, rgPath, dir, files)
+	})
+	if !ok {
+		return grepResult{}, false
+	}
+
+	args := []string{
+		"--json",
+		"--no-config",
+		// Binary files are detected afterwards, as the built-in engine
+		// does.
+		"--text",
+		"--regexp", s.regex.String(),
+	}
+	if s.multiline {
+		args = append(args, "--multiline")
+	} else {
+		// The built-in engine matches lines without their \r.
+		args = append(args, "--crlf")
+	}
+	if s.mode == grepModeContent && s.before > 0 {
+		args = append(args, "--before-context", strconv.Itoa(s.before))
+	}
+	if s.mode == grepModeContent && s.after > 0 {
+		args = append(args, "--after-context", strconv.Itoa(s.after))
+	}
+	switch s.mode {
+	case grepModeFiles:
+		args = append(args, "--max-count", "1")
+	case grepModeContent:
+		// No file can hold more results than the page and one past it.
+		args = append(args, "--max-count", strconv.Itoa(s.offset+s.limit+1))
+	}
+	args = append(args, files...)
+
+	rgCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(rgCtx, rgPath, args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return grepResult{}, false
+	}
+	if err := cmd.Start(); err != nil {
+		log.Printf("Failed to start ripgrep: %v", err)
+		return grepResult{}, false
+	}
+
+	// Ripgrep searches Latin-1 and UTF-16 without a byte order mark as
+	// raw bytes, so files in other encodings than UTF-8 are searched by
+	// the built-in engine instead, and what ripgrep found in them is
+	// dropped.
+	page := &ripgrepPage{search: s}
+	reader := bufio.NewReaderSize(nil, grepReadSize)
+	skip := make(map[string]bool, len(transcoded))
+	for _, path := range transcoded {
+		skip[path] = true
+		if file := s.searchFile(ctx, path, reader); file.count > 0 {
+			page.add(file)
+		}
+	}
+
+	// Ripgrep is run with --text, so binary files are dropped here by the
+	// same test the built-in engine uses. Only files with matches need
+	// checking. A file may have changed encoding since the list above was
+	// made, so those are searched again too.
+	parseErr := parseRipgrepJSON(stdout, dir, func(file grepFile) bool {
+		if skip[file.path] {
+			return true
+		}
+		enc, ok := sniffFile(file.path)
+		if !ok {
+			return true
+		}
+		if enc != encodingUTF8 {
+			if file = s.searchFile(ctx, file.path, reader); file.count == 0 {
+				return true
+			}
+		}
+		page.add(file)
+		return true
+	})
+	if parseErr != nil {
+		cancel()
+	}
+	// Drain the rest so ripgrep can exit if parsing stopped early.
+	io.Copy(io.Discard, stdout)
+	err = cmd.Wait()
+
+	// Exit status 1 means nothing matched. Status 2 also covers files
+	// that couldn't be read, but the built-in engine skips those quietly,
+	// so fall back rather than guess what was missed.
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+	if err != nil || parseErr != nil {
+		if ctx.Err() == nil {
+			log.Printf("ripgrep failed, using the built-in search: %v %v %s", err, parseErr, strings.TrimSpace(stderr.String()))
+		}
+		return grepResult{}, false
+	}
+	return page.result(), true
+}
+
+// ripgrepPage gathers the files ripgrep reports, in whatever order they
+// come, into the files the requested page is made of, in walk order. Files
+// past the one holding the first result after the page are dropped as they
+// come, so only about a page of results is held.
+type ripgrepPage struct {
+	search *grepSearch
+	files  []grepFile
+}
+
+func (p *ripgrepPage) add(file grepFile) {
+	i := sort.Search(len(p.files), func(i int) bool {
+		return walkOrderLess(file.path, p.files[i].path)
+	})
+	p.files = slices.Insert(p.files, i, file)
+
+	end := p.search.offset + p.search.limit
+	seen := 0
+	for i, f := range p.files {
+		if p.search.mode == grepModeContent {
+			seen += f.count
+		} else {
+			seen++
+		}
+		if seen > end {
+			p.files = p.files[:i+1]
+			return
+		}
+	}
+}
+
+// result returns the page.
+func (p *ripgrepPage) result() grepResult {
+	var result grepResult
+	seen := 0
+	for _, file := range p.files {
+		if result.add(p.search, file, &seen) {
+			break
+		}
+	}
+	return result
+}
+
+// walkOrderLess orders paths the way walkFiles visits them: by name within
+// each directory, with a directory's contents in its place among its
+// siblings.
+func walkOrderLess(a, b string) bool {
+	as := strings.Split(filepath.ToSlash(a), "/")
+	bs := strings.Split(filepath.ToSlash(b), "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// ripgrepFileArgs builds the part of the ripgrep command line that picks
+// the files to search, ending with the path to search, and returns it with
+// the directory to run ripgrep in. It reports false when the ignore rules
+// can't be expressed with ripgrep's options.
+func (s *grepSearch) ripgrepFileArgs(ctx context.Context, rgPath, root string) ([]string, string, bool) {
+	if !s.ripgrepCompatible() {
+		return nil, "", false
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, "", false
+	}
+	searchDir := root
+	if !info.IsDir() {
+		searchDir = filepath.Dir(root)
+	}
+
+	args := []string{
+		// Hidden files are searched like any other.
+		"--hidden",
+		// .ignore and .rgignore files mean nothing to the built-in
+		// walker.
+		"--no-ignore-dot",
+	}
+	for name := range vcsDirs {
+		args = append(args, "--glob", "!"+name+"/")
+	}
+
+	// Ripgrep resolves --ignore-file patterns from its working directory,
+	// so it runs in the ignore root: the repository root, or the search
+	// directory outside a repository.
+	dir := searchDir
+	if s.walk.noIgnore {
+		args = append(args, "--no-ignore")
+	} else {
+		repo, _ := findRepository(searchDir)
+		if repo == "" {
+			// The built-in walker only reads ignore files from the search
+			// directory down outside a repository, and no global ones.
+			args = append(args, "--no-require-git", "--no-ignore-parent", "--no-ignore-global")
+		} else {
+			dir = repo
+		}
+		// Unlike with the built-in walker, ignore files can't bring the
+		// defaults back.
+		for _, pattern := range strings.Fields(defaultIgnores) {
+			args = append(args, "--glob", "!"+pattern)
+		}
+		if !s.ripgrepCanIgnore(ctx, rgPath, dir, searchDir) {
+			return nil, "", false
+		}
+		rootIgnore := filepath.Join(dir, ".klaudkodignore")
+		if _, err := os.Stat(rootIgnore); err == nil {
+			args = append(args, "--ignore-file", rootIgnore)
+		}
+	}
+
+	if s.multiline {
+		args = append(args, "--max-filesize", strconv.Itoa(grepMultilineMaxBytes))
+	}
+	if s.include != nil {
+		args = append(args, "--glob", s.include.String())
+	}
+	if s.types != nil {
+		args = append(args, "--type-add", "search:"+s.types.String(), "--type", "search")
+	}
+
+	rel, err := filepath.Rel(dir, root)
+	if err != nil {
+		return nil, "", false
+	}
+	args = append(args, "--", rel)
+	return args, dir, true
+}
+
+// findTranscodedFiles returns the files picked by the ripgrep arguments
+// files that hold text in other encodings than UTF-8. Only files with a
+// NUL or non-ASCII byte can be, and ripgrep finds those quickly. It reports
+// false if ripgrep failed.
+func findTranscodedFiles(ctx context.Context, rgPath, dir string, files []string) ([]string, bool) {
+	args := append([]string{
+		"--no-config",
+		"--files-with-matches",
+		"--text",
+		// Look at the bytes of UTF-16 files with a byte order mark too.
+		"--encoding", "none",
+		"--regexp", `(?-u)[\x00\x80-\xff]`,
+	}, files...)
+	cmd := exec.CommandContext(ctx, rgPath, args...)
+	cmd.Dir = dir
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, false
+	}
+	if err := cmd.Start(); err != nil {
+		log.Printf("Failed to start ripgrep: %v", err)
+		return nil, false
+	}
+
+	var transcoded []string
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		path := scanner.Text()
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if enc, ok := sniffFile(path); ok && enc.transcoded() {
+			transcoded = append(transcoded, path)
+		}
+	}
+	scanErr := scanner.Err()
+	io.Copy(io.Discard, stdout)
+	err = cmd.Wait()
+
+	// Exit status 1 means no file has such bytes.
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+	if err != nil || scanErr != nil {
+		return nil, false
+	}
+	return transcoded, true
+}
+
+// ripgrepCompatible reports whether ripgrep would read the pattern and the
+// include glob the way the built-in engine does. Perl classes such as \w
+// and \b match Unicode in ripgrep but only ASCII in Go, and ripgrep globs
+// take paths and exclusions where include only matches file names.
+func (s *grepSearch) ripgrepCompatible() bool {
+	if perlClass.MatchString(s.regex.String()) {
+		return false
+	}
+	if s.include == nil {
+		return true
+	}
+	include := s.include.String()
+	return !strings.Contains(include, "/") && !strings.HasPrefix(include, "!")
+}
+
+// perlClass finds Perl character classes and word boundaries in a pattern.
+// An escaped backslash before a letter is caught too, which only costs a
+// fall back.
+var perlClass = regexp.MustCompile(`\\[wWdDsSbB]`)
+
+// ripgrepCanIgnore reports whether the only .klaudkodignore file that
+// applies to the search is the one in the ignore root. Ripgrep can only
+// take other ignore file names as global files, whose patterns are relative
+// to its working directory, so nested ones are left to the built-in walker.
+func (s *grepSearch) ripgrepCanIgnore(ctx context.Context, rgPath, ignoreRoot, searchDir string) bool {
+	for d := searchDir; d != ignoreRoot && filepath.Dir(d) != d; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, ".klaudkodignore")); err == nil {
+			return false
+		}
+	}
+
+	dirs, ok := s.listings.list("ignores\x00"+ignoreRoot, func() ([]string, bool) {
+		return findIgnoreFileDirs(ctx, rgPath, ignoreRoot)
+	})
+	if !ok {
+		return false
+	}
+	for _, d := range dirs {
+		if d == searchDir || strings.HasPrefix(d, searchDir+string(filepath.Separator)) {
+			return false
+		}
+	}
+	return true
+}
+
+// ripgrepListingTTL is how long the files ripgrep lists for a search are
+// remembered.
+const ripgrepListingTTL = 30 * time.Second
+
+// ripgrepListings remembers lists of files ripgrep found, such as the
+// directories holding nested ignore files, so that finding them doesn't
+// walk the tree again on every search. A nil cache finds them each time.
+type ripgrepListings struct {
+	mu      sync.Mutex
+	entries map[string]ripgrepListing
+}
+
+type ripgrepListing struct {
+	paths []string
+	found time.Time
+}
+
+func newRipgrepListings() *ripgrepListings {
+	return &ripgrepListings{entries: make(map[string]ripgrepListing)}
+}
+
+// list returns the list stored under key, or calls find to make it. It
+// reports false if find failed, which isn't remembered.
+func (c *ripgrepListings) list(key string, find func() ([]string, bool)) ([]string, bool) {
+	if c != nil {
+		c.mu.Lock()
+		entry, ok := c.entries[key]
+		c.mu.Unlock()
+		if ok && time.Since(entry.found) < ripgrepListingTTL {
+			return entry.paths, true
+		}
+	}
+
+	paths, ok := find()
+	if !ok {
+		return nil, false
+	}
+	if c != nil {
+		c.mu.Lock()
+		for k, entry := range c.entries {
+			if time.Since(entry.found) >= ripgrepListingTTL {
+				delete(c.entries, k)
+			}
+		}
+		c.entries[key] = ripgrepListing{paths: paths, found: time.Now()}
+		c.mu.Unlock()
+	}
+	return paths, true
+}
+
+// findIgnoreFileDirs returns the directories below root, but not root
+// itself, that hold a .klaudkodignore file. It reports false if ripgrep
+// failed to list them.
+func findIgnoreFileDirs(ctx context.Context, rgPath, root string) ([]string, bool) {
+	cmd := exec.CommandContext(ctx, rgPath, "--files", "--no-config", "--hidden", "--no-ignore-dot", "--no-require-git",
+		"--glob", ".klaudkodignore", "--glob", "!.git/", "--", root)
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+		return nil, false
+	}
+	var dirs []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line == "" {
+			continue
+		}
+		if d := filepath.Dir(filepath.Clean(line)); d != filepath.Clean(root) {
+			dirs = append(dirs, d)
+		}
+	}
+	return dirs, true
+}
+
+// parseRipgrepJSON reads `rg --json` output, with paths made absolute from
+// dir, and calls handle with each file that has matches. Reading stops
+// early if handle returns false.
+func parseRipgrepJSON(r io.Reader, dir string, handle func(grepFile) bool) error {
+	var file *grepFile
+	stopped := false
+	flush := func() {
+		if file != nil && !stopped {
+			stopped = !handle(*file)
+		}
+		file = nil
+	}
+
+	reader := bufio.NewReaderSize(r, grepReadSize)
+	err := scanLines(reader, func(_ int, line []byte) bool {
+		if len(line) == 0 {
+			return true
+		}
+		var msg ripgrepMessage
+		if json.Unmarshal(line, &msg) != nil {
+			return true
+		}
+		if msg.Type == "end" {
+			flush()
+			return !stopped
+		}
+		if msg.Type != "match" && msg.Type != "context" {
+			return true
+		}
+
+		path := msg.Data.Path.String()
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if file != nil && file.path != path {
+			flush()
+			if stopped {
+				return false
+			}
+		}
+		if file == nil {
+			file = &grepFile{path: path}
+		}
+
+		// A multiline match spans several lines, all of which match.
+		text := strings.TrimSuffix(msg.Data.Lines.String(), "\n")
+		for n, l := range strings.Split(text, "\n") {
+			gl := grepLine{
+				line:  msg.Data.LineNumber + n,
+				text:  strings.TrimSuffix(l, "\r"),
+				match: msg.Type == "match",
+			}
+			if gl.match {
+				file.count++
+			}
+			file.lines = append(file.lines, gl)
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read ripgrep output: %w", err)
+	}
+	flush()
+	return nil
+}
+
+// sniffFile applies sniffText to the start of a file. Files that can't be
+// read count as binary.
+func sniffFile(path string) (*textEncoding, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	head := make([]byte, textSniffSize)
+	n, _ := io.ReadFull(f, head)
+	return sniffText(head[:n])
+}
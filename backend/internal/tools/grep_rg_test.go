@@ -0,0 +1,303 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// lookRipgrep returns the ripgrep binary for the tests that compare the two
+// engines. Without it they are skipped, except in CI, which installs it so
+// that they run.
+func lookRipgrep(tb testing.TB) string {
+	tb.Helper()
+	rgPath, err := exec.LookPath("rg")
+	if err != nil {
+		if os.Getenv("CI") != "" {
+			tb.Fatal("ripgrep is not installed, so the ripgrep engine can't be checked")
+		}
+		tb.Skip("ripgrep is not installed")
+	}
+	return rgPath
+}
+
+func TestParseRipgrepJSON(t *testing.T) {
+	// Output of rg --json --before-context 1 --multiline, shortened.
+	output := `{"type":"begin","data":{"path":{"text":"a.go"}}}
+{"type":"context","data":{"path":{"text":"a.go"},"lines":{"text":"package a\r\n"},"line_number":1,"absolute_offset":0,"submatches":[]}}
+{"type":"match","data":{"path":{"text":"a.go"},"lines":{"text":"func One() {\n\treturn\n"},"line_number":2,"absolute_offset":11,"submatches":[{"match":{"text":"One() {\n\treturn"},"start":5,"end":20}]}}
+{"type":"end","data":{"path":{"text":"a.go"},"binary_offset":null,"stats":{}}}
+{"type":"match","data":{"path":{"bytes":"Yi50eHQ="},"lines":{"bytes":"/25lZWRsZQo="},"line_number":7,"absolute_offset":0,"submatches":[]}}
+{"data":{"elapsed_total":{"human":"0.01s","nanos":1,"secs":0},"stats":{}},"type":"summary"}
+`
+	var files []grepFile
+	err := parseRipgrepJSON(strings.NewReader(output), "/repo", func(file grepFile) bool {
+		files = append(files, file)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []grepFile{
+		{
+			path: "/repo/a.go",
+			lines: []grepLine{
+				{line: 1, text: "package a"},
+				{line: 2, text: "func One() {", match: true},
+				{line: 3, text: "\treturn", match: true},
+			},
+			count: 2,
+		},
+		{
+			path:  "/repo/b.txt",
+			lines: []grepLine{{line: 7, text: "\xffneedle", match: true}},
+			count: 1,
+		},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("got %+v, want %+v", files, want)
+	}
+
+	// Reading stops at the first file when handle says so.
+	files = nil
+	err = parseRipgrepJSON(strings.NewReader(output), "/repo", func(file grepFile) bool {
+		files = append(files, file)
+		return false
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != 1 || files[0].path != "/repo/a.go" {
+		t.Errorf("got %+v, want only a.go", files)
+	}
+}
+
+func TestWalkOrderLess(t *testing.T) {
+	paths := []string{"b.txt", "a/z.txt", "a.txt", "a/b/c.txt", "a-b.txt", "A.txt"}
+	sort.Slice(paths, func(i, j int) bool { return walkOrderLess(paths[i], paths[j]) })
+
+	want := []string{"A.txt", "a/b/c.txt", "a/z.txt", "a-b.txt", "a.txt", "b.txt"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got %v, want %v", paths, want)
+	}
+}
+
+func TestRipgrepPage(t *testing.T) {
+	file := func(path string, count int) grepFile {
+		f := grepFile{path: path, count: count}
+		for i := 1; i <= count; i++ {
+			f.lines = append(f.lines, grepLine{line: i, text: "x", match: true})
+		}
+		return f
+	}
+	page := &ripgrepPage{search: &grepSearch{mode: grepModeContent, offset: 1, limit: 2}}
+	for _, f := range []grepFile{file("/d/c", 1), file("/d/a/x", 1), file("/d/e", 3), file("/d/b", 1), file("/d/a/y", 2)} {
+		page.add(f)
+	}
+
+	// Only the files up to the first result past the page are held.
+	var held []string
+	for _, f := range page.files {
+		held = append(held, f.path)
+	}
+	if want := []string{"/d/a/x", "/d/a/y", "/d/b"}; !reflect.DeepEqual(held, want) {
+		t.Errorf("held %v, want %v", held, want)
+	}
+
+	result := page.result()
+	if len(result.files) != 1 || result.files[0].path != "/d/a/y" || result.entries != 2 || !result.truncated {
+		t.Errorf("unexpected page: %+v", result)
+	}
+}
+
+func TestGrepSearch_RipgrepFallsBack(t *testing.T) {
+	tests := []struct {
+		pattern, include string
+		want             bool
+	}{
+		{pattern: "func \\w+", want: false},
+		{pattern: "\\bword", want: false},
+		{pattern: "a\\.b", include: "*.go", want: true},
+		{pattern: "x", include: "src/*.go", want: false},
+		{pattern: "x", include: "*.{ts,js}", want: true},
+		{pattern: "x", include: "!*.go", want: false},
+	}
+	for _, tt := range tests {
+		s := &grepSearch{regex: regexp.MustCompile(tt.pattern)}
+		if tt.include != "" {
+			s.include, _ = compileGlob(tt.include, false)
+		}
+		if got := s.ripgrepCompatible(); got != tt.want {
+			t.Errorf("ripgrepCompatible(%q, %q) = %v, want %v", tt.pattern, tt.include, got, tt.want)
+		}
+	}
+}
+
+// TestGrepEngines_Parity checks that ripgrep and the built-in engine give
+// the same results, down to the formatted output.
+func TestGrepEngines_Parity(t *testing.T) {
+	rgPath := lookRipgrep(t)
+
+	dir := t.TempDir()
+	long := strings.Repeat("y", 100*1024)
+	writeTree(t, dir, map[string]string{
+		".git/HEAD":              "ref: needle\n",
+		".gitignore":             "*.log\nbuild/\n",
+		".klaudkodignore":        "secret/\n",
+		".hidden/needle.txt":     "hidden needle\n",
+		"a.go":                   "package a\n\n// needle one\nfunc One() {}\n\n// needle two\n",
+		"app.log":                "needle in a log\n",
+		"b.txt":                  "needle\r\nNEEDLE\r\nend needle\r\n",
+		"binary.bin":             "needle\x00",
+		"bom.txt":                "\xef\xbb\xbfneedle with a byte order mark\n",
+		"build/out.txt":          "needle\n",
+		"long.txt":               long + "needle" + long + "\n",
+		"node_modules/x/a.js":    "needle\n",
+		"secret/key.txt":         "needle\n",
+		"src/.gitignore":         "gen.go\n!keep.log\n",
+		"src/gen.go":             "needle\n",
+		"src/keep.log":           "needle kept\n",
+		"src/lib/needle.go":      "package lib\n\nvar needle = 1\nvar other = 2\nvar needle2 = 3\n",
+		"src/lib/needle_test.go": "package lib\n",
+		"utf8.txt":               "café needle\n",
+		"z/late.txt":             "last needle\n",
+	})
+
+	grep := NewGrepTool(dir)
+	tests := []map[string]interface{}{
+		{"pattern": "needle"},
+		{"pattern": "needle", "ignoreCase": true},
+		{"pattern": "needle$"},
+		{"pattern": "needle", "context": 1.0},
+		{"pattern": "needle", "before": 2.0, "after": 1.0, "offset": 2.0, "limit": 3.0},
+		{"pattern": "needle", "outputMode": "files_with_matches"},
+		{"pattern": "needle", "outputMode": "count", "limit": 2.0},
+		{"pattern": "needle", "type": "go"},
+		{"pattern": "needle", "include": "*.txt"},
+		{"pattern": "needle", "path": "src"},
+		{"pattern": "needle", "path": "b.txt"},
+		{"pattern": "needle", "noIgnore": true},
+		{"pattern": "package [a-z]+\n\n", "multiline": true},
+		{"pattern": "a.b", "fixedStrings": true},
+	}
+	for _, args := range tests {
+		search, path, err := grep.newSearch(args)
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		builtin, err := search.run(context.Background(), path)
+		if err != nil {
+			t.Fatalf("%v: built-in search failed: %v", args, err)
+		}
+		ripgrep, ok := search.runRipgrep(context.Background(), rgPath, path)
+		if !ok {
+			t.Fatalf("%v: ripgrep didn't run the search", args)
+		}
+		if got, want := grep.format(search, ripgrep), grep.format(search, builtin); got != want {
+			t.Errorf("%v: ripgrep gave\n%s\nbuilt-in engine gave\n%s", args, got, want)
+		}
+	}
+}
+
+// TestGrepEngines_ParityEncodings checks that ripgrep searches give the
+// same results as the built-in engine in trees with text in other
+// encodings than UTF-8, which are searched by the built-in engine.
+func TestGrepEngines_ParityEncodings(t *testing.T) {
+	rgPath := lookRipgrep(t)
+
+	tests := map[string]string{
+		"Latin-1":         "caf\xe9 needle\n",
+		"UTF-16LE":        string(encodeUTF16("first\r\nsecond needle\r\n", false, false)),
+		"UTF-16BE":        string(encodeUTF16("needle\n", true, false)),
+		"UTF-16 with BOM": string(encodeUTF16("needle\n", false, true)),
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, map[string]string{
+				"a.txt":     "needle\n",
+				"binary.db": "needle\x00\xff",
+				"other.txt": content,
+				"z.txt":     "needle\n",
+			})
+
+			grep := NewGrepTool(dir)
+			for _, args := range []map[string]interface{}{
+				{"pattern": "needle"},
+				{"pattern": "needle", "limit": 2.0},
+				{"pattern": "needle", "outputMode": "count"},
+			} {
+				search, path, err := grep.newSearch(args)
+				if err != nil {
+					t.Fatal(err)
+				}
+				builtin, err := search.run(context.Background(), path)
+				if err != nil {
+					t.Fatalf("%v: built-in search failed: %v", args, err)
+				}
+				ripgrep, ok := search.runRipgrep(context.Background(), rgPath, path)
+				if !ok {
+					t.Fatalf("%v: ripgrep didn't run the search", args)
+				}
+				got, want := grep.format(search, ripgrep), grep.format(search, builtin)
+				if got != want {
+					t.Errorf("%v: ripgrep gave\n%s\nbuilt-in engine gave\n%s", args, got, want)
+				}
+				if !strings.Contains(got, "other.txt") {
+					t.Errorf("%v: other.txt is missing:\n%s", args, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRipgrepListings(t *testing.T) {
+	listings := newRipgrepListings()
+	calls := 0
+	find := func() ([]string, bool) {
+		calls++
+		return []string{"a"}, calls > 1
+	}
+
+	// Failures aren't remembered, but lists are.
+	for i, wantOK := range []bool{false, true, true} {
+		paths, ok := listings.list("key", find)
+		if ok != wantOK || ok && !reflect.DeepEqual(paths, []string{"a"}) {
+			t.Errorf("call %d: got %v %v", i, paths, ok)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("find was called %d times, want 2", calls)
+	}
+
+	// Without a cache the list is found each time.
+	var none *ripgrepListings
+	none.list("key", find)
+	if calls != 3 {
+		t.Errorf("find was called %d times, want 3", calls)
+	}
+}
+
+func benchmarkRipgrep(b *testing.B, pattern string, limit int) {
+	rgPath := lookRipgrep(b)
+	dir := benchTree(b)
+	s := &grepSearch{regex: regexp.MustCompile(pattern), mode: grepModeContent, limit: limit, workers: runtime.NumCPU()}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := s.runRipgrep(context.Background(), rgPath, dir); !ok {
+			b.Fatal("ripgrep didn't run the search")
+		}
+	}
+}
+
+// These compare with BenchmarkGrep_Rare_AllWorkers and
+// BenchmarkGrep_Common_AllWorkers.
+func BenchmarkGrep_Rare_Ripgrep(b *testing.B)   { benchmarkRipgrep(b, "needle", 100) }
+func BenchmarkGrep_Common_Ripgrep(b *testing.B) { benchmarkRipgrep(b, "handler", 100) }
@@ -0,0 +1,393 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"sync"
+)
+
+const (
+	// grepReadSize is the read buffer of each worker. Longer lines are
+	// still matched whole.
+	grepReadSize = 64 * 1024
+	// grepCancelCheckLines is how often a long file checks whether the
+	// search was cancelled.
+	grepCancelCheckLines = 4096
+	// grepMultilineMaxBytes is the largest file a multiline search reads,
+	// since it has to hold the whole file. Larger files are skipped.
+	grepMultilineMaxBytes = 16 * 1024 * 1024
+)
+
+// Output modes of a search.
+const (
+	grepModeContent = "content"
+	grepModeFiles   = "files_with_matches"
+	grepModeCount   = "count"
+)
+
+// grepLine is a matching line, or a context line shown around one.
+type grepLine struct {
+	line  int
+	text  string
+	match bool
+}
+
+// grepFile is what a search found in one file. Count is the number of
+// matching lines; lines is only filled in content mode.
+type grepFile struct {
+	path  string
+	lines []grepLine
+	count int
+}
+
+// grepResult is the outcome of a search.
+type grepResult struct {
+	files []grepFile
+	// entries is how many results were returned: matching lines in
+	// content mode, files otherwise.
+	entries int
+	// truncated is set when there were more results after the page.
+	truncated bool
+}
+
+// grepSearch searches files in parallel. Files are searched by a pool of
+// workers as the walk finds them, but results are reported in walk order,
+// so a search returns the same results however many workers it has. Once
+// a page of offset+limit results is found in order, the walk and the
+// workers stop.
+type grepSearch struct {
+	regex *regexp.Regexp
+	// include and types match the names of the files to search, when set.
+	include *globMatcher
+	types   *globMatcher
+	walk    walkOptions
+	// before and after are the context lines shown around matches.
+	before, after int
+	// multiline matches the pattern against whole files, so it can span
+	// lines.
+	multiline bool
+	mode      string
+	offset    int
+	limit     int
+	workers   int
+	// listings caches the files ripgrep lists to set up a search.
+	listings *ripgrepListings
+}
+
+func (s *grepSearch) run(ctx context.Context, root string) (grepResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type job struct {
+		seq  int
+		path string
+	}
+	type fileResult struct {
+		seq  int
+		file grepFile
+	}
+
+	workers := s.workers
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan job, workers*4)
+	results := make(chan fileResult, workers*4)
+
+	var walkErr error
+	go func() {
+		defer close(jobs)
+		seq := 0
+		walkErr = walkFiles(ctx, root, s.walk, func(path string, d fs.DirEntry) error {
+			if s.include != nil && !s.include.match(d.Name()) {
+				return nil
+			}
+			if s.types != nil && !s.types.match(d.Name()) {
+				return nil
+			}
+			select {
+			case jobs <- job{seq: seq, path: path}:
+				seq++
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reader := bufio.NewReaderSize(nil, grepReadSize)
+			for j := range jobs {
+				results <- fileResult{seq: j.seq, file: s.searchFile(ctx, j.path, reader)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Put results back in walk order, and stop everything once the page
+	// is full. Results keep being drained so no worker blocks.
+	var result grepResult
+	pending := make(map[int]fileResult)
+	next := 0
+	seen := 0
+	done := false
+	for r := range results {
+		pending[r.seq] = r
+		for !done {
+			fr, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if done = result.add(s, fr.file, &seen); done {
+				cancel()
+			}
+		}
+	}
+
+	if done {
+		return result, nil
+	}
+	if walkErr != nil {
+		return grepResult{}, walkErr
+	}
+	return result, ctx.Err()
+}
+
+// add appends the part of file that falls in the requested page, given
+// that seen results came before it, and reports whether a result past the
+// page was reached.
+func (r *grepResult) add(s *grepSearch, file grepFile, seen *int) bool {
+	kept, n, past := s.page(file, seen)
+	if n > 0 {
+		r.files = append(r.files, kept)
+		r.entries += n
+	}
+	if past {
+		r.truncated = true
+	}
+	return past
+}
+
+// page keeps the part of file that falls in the requested page, counting
+// its results in seen. It returns the kept part, how many results it holds
+// and whether a result past the page was reached.
+func (s *grepSearch) page(file grepFile, seen *int) (grepFile, int, bool) {
+	end := s.offset + s.limit
+	if file.count == 0 {
+		return file, 0, false
+	}
+	if s.mode != grepModeContent {
+		*seen++
+		if *seen > end {
+			return file, 0, true
+		}
+		if *seen <= s.offset {
+			return file, 0, false
+		}
+		return file, 1, false
+	}
+
+	// Keep the matches in the page and the context lines around them.
+	var selected []int
+	past := false
+	for _, l := range file.lines {
+		if !l.match {
+			continue
+		}
+		*seen++
+		if *seen > end {
+			past = true
+			break
+		}
+		if *seen > s.offset {
+			selected = append(selected, l.line)
+		}
+	}
+	if len(selected) == 0 {
+		return file, 0, past
+	}
+
+	kept := file
+	kept.lines = nil
+	for _, l := range file.lines {
+		for _, m := range selected {
+			if l.line == m || !l.match && l.line >= m-s.before && l.line <= m+s.after {
+				kept.lines = append(kept.lines, l)
+				break
+			}
+		}
+	}
+	kept.count = len(selected)
+	return kept, len(selected), past
+}
+
+// searchFile returns what a file holds, reading it through reader to reuse
+// its buffer. Files that can't be read or are binary hold nothing, nor do
+// files too large for a multiline search, and text in other encodings than
+// UTF-8 is searched as UTF-8.
+func (s *grepSearch) searchFile(ctx context.Context, path string, reader *bufio.Reader) grepFile {
+	file := grepFile{path: path}
+	if ctx.Err() != nil {
+		return file
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return file
+	}
+	defer f.Close()
+
+	reader.Reset(f)
+	head, _ := reader.Peek(textSniffSize)
+	enc, ok := sniffText(head)
+	if !ok {
+		return file
+	}
+	if enc != encodingUTF8 {
+		text, _, err := enc.open(f, 0)
+		if err != nil {
+			return file
+		}
+		reader.Reset(text)
+	}
+
+	lines := &contextCollector{before: s.before, after: s.after, keep: s.mode == grepModeContent}
+	if s.multiline {
+		data, err := io.ReadAll(io.LimitReader(reader, grepMultilineMaxBytes+1))
+		if err != nil || len(data) > grepMultilineMaxBytes || ctx.Err() != nil {
+			return file
+		}
+		matched := multilineMatches(s.regex, data)
+		scanLines(bufio.NewReader(bytes.NewReader(data)), func(lineNum int, line []byte) bool {
+			lines.add(lineNum, line, matched[lineNum])
+			return !(s.mode == grepModeFiles && lines.count > 0)
+		})
+	} else {
+		err = scanLines(reader, func(lineNum int, line []byte) bool {
+			if lineNum%grepCancelCheckLines == 0 && ctx.Err() != nil {
+				return false
+			}
+			lines.add(lineNum, line, s.regex.Match(line))
+			// One match is enough to list a file.
+			return !(s.mode == grepModeFiles && lines.count > 0)
+		})
+		if err != nil {
+			return file
+		}
+	}
+
+	file.lines = lines.lines
+	file.count = lines.count
+	return file
+}
+
+// multilineMatches returns the numbers of the lines covered by matches of
+// regex in data.
+func multilineMatches(regex *regexp.Regexp, data []byte) map[int]bool {
+	matched := make(map[int]bool)
+	line := 1
+	pos := 0
+	for _, loc := range regex.FindAllIndex(data, -1) {
+		start, end := loc[0], loc[1]
+		line += bytes.Count(data[pos:start], []byte("\n"))
+		pos = start
+		last := line
+		if end > start {
+			// A match ending with a newline doesn't reach the next line.
+			last += bytes.Count(data[start:end-1], []byte("\n"))
+		}
+		for l := line; l <= last; l++ {
+			matched[l] = true
+		}
+	}
+	return matched
+}
+
+// contextCollector gathers matching lines with before and after lines of
+// context around them, merging context that overlaps.
+type contextCollector struct {
+	before, after int
+	// keep is false when only the count is wanted.
+	keep      bool
+	lines     []grepLine
+	count     int
+	ring      []grepLine
+	afterLeft int
+}
+
+func (c *contextCollector) add(lineNum int, text []byte, match bool) {
+	if match {
+		c.count++
+		if c.keep {
+			c.lines = append(c.lines, c.ring...)
+			c.lines = append(c.lines, grepLine{line: lineNum, text: string(text), match: true})
+		}
+		c.ring = c.ring[:0]
+		c.afterLeft = c.after
+		return
+	}
+	if !c.keep {
+		return
+	}
+	if c.afterLeft > 0 {
+		c.lines = append(c.lines, grepLine{line: lineNum, text: string(text)})
+		c.afterLeft--
+		return
+	}
+	if c.before > 0 {
+		if len(c.ring) == c.before {
+			copy(c.ring, c.ring[1:])
+			c.ring = c.ring[:len(c.ring)-1]
+		}
+		c.ring = append(c.ring, grepLine{line: lineNum, text: string(text)})
+	}
+}
+
+// scanLines calls fn with each line of r, without its line ending, until fn
+// returns false. Unlike bufio.Scanner it has no limit on line length.
+func scanLines(r *bufio.Reader, fn func(lineNum int, line []byte) bool) error {
+	var long []byte
+	for lineNum := 1; ; lineNum++ {
+		line, err := r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			// The slice is only valid until the next read, so gather a
+			// long line in its own buffer.
+			long = append(long[:0], line...)
+			for errors.Is(err, bufio.ErrBufferFull) {
+				line, err = r.ReadSlice('\n')
+				long = append(long, line...)
+			}
+			line = long
+		}
+		if len(line) == 0 && err == io.EOF {
+			return nil
+		}
+
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if !fn(lineNum, line) {
+			return nil
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestGrepTool_LongLines(t *testing.T) {
+	dir := t.TempDir()
+	long := strings.Repeat("x", 200*1024) + "needle"
+	writeTree(t, dir, map[string]string{
+		"long.txt": "first\n" + long + "\r\nneedle at the end",
+	})
+
+	result, err := NewGrepTool(dir).Execute(context.Background(), map[string]interface{}{"pattern": "needle"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(result.Content, "long.txt:2:"+long+"\n") {
+		t.Error("line longer than the read buffer was not matched whole")
+	}
+	if !strings.Contains(result.Content, "long.txt:3:needle at the end\n") {
+		t.Errorf("line after the long line was missed:\n%.200s", result.Content[len(result.Content)-200:])
+	}
+}
+
+// matchLines flattens the matching lines of a result.
+func matchLines(result grepResult) []string {
+	var lines []string
+	for _, f := range result.files {
+		for _, l := range f.lines {
+			if l.match {
+				lines = append(lines, fmt.Sprintf("%s:%d", filepath.Base(f.path), l.line))
+			}
+		}
+	}
+	return lines
+}
+
+func TestGrepSearch_OrderAndLimit(t *testing.T) {
+	dir := t.TempDir()
+	files := make(map[string]string)
+	for i := 0; i < 50; i++ {
+		files[fmt.Sprintf("f%02d.txt", i)] = "match\nother\nmatch\n"
+	}
+	files["binary.bin"] = "match\x00"
+	writeTree(t, dir, files)
+
+	run := func(workers, offset, limit int) grepResult {
+		s := &grepSearch{regex: regexp.MustCompile("match"), mode: grepModeContent, offset: offset, limit: limit, workers: workers}
+		result, err := s.run(context.Background(), dir)
+		if err != nil {
+			t.Fatalf("search failed: %v", err)
+		}
+		return result
+	}
+
+	all := run(8, 0, 1000)
+	if all.entries != 100 || len(all.files) != 50 || all.truncated {
+		t.Fatalf("got %d matches in %d files (truncated %v), want 100 in 50", all.entries, len(all.files), all.truncated)
+	}
+	allLines := matchLines(all)
+
+	// Any number of workers returns the same page of matches in walk
+	// order.
+	for _, workers := range []int{1, 3, 16} {
+		for _, offset := range []int{0, 5} {
+			page := run(workers, offset, 7)
+			got := matchLines(page)
+			if len(got) != 7 || !page.truncated {
+				t.Fatalf("%d workers: got %d matches (truncated %v), want 7 truncated", workers, len(got), page.truncated)
+			}
+			if strings.Join(got, ",") != strings.Join(allLines[offset:offset+7], ",") {
+				t.Errorf("%d workers, offset %d: got %v, want %v", workers, offset, got, allLines[offset:offset+7])
+			}
+		}
+	}
+
+	// Exactly the limit is not truncated.
+	if exact := run(4, 0, 100); exact.truncated {
+		t.Error("search with exactly limit matches reported truncated")
+	}
+	if last := run(4, 95, 10); last.entries != 5 || last.truncated {
+		t.Errorf("last page: got %d matches (truncated %v), want 5", last.entries, last.truncated)
+	}
+}
+
+func TestGrepTool_Options(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"a.go":   "package a\n\nfunc One() {\n\treturn\n}\n\nfunc Two() {\n\treturn\n}\n",
+		"b.ts":   "export function one() {}\nconst ONE = 1;\n",
+		"c.txt":  "a.b\naxb\n",
+		"d.go":   "package d\n",
+		"e.json": "{}\n",
+	})
+	tool := NewGrepTool(dir)
+
+	tests := []struct {
+		name string
+		args map[string]interface{}
+		want string
+	}{
+		{
+			name: "context",
+			args: map[string]interface{}{"pattern": "^func", "context": 1.0},
+			want: "a.go-2-\na.go:3:func One() {\na.go-4-\treturn\n--\na.go-6-\na.go:7:func Two() {\na.go-8-\treturn\n",
+		},
+		{
+			name: "overlapping context is merged",
+			args: map[string]interface{}{"pattern": "return", "before": 2.0},
+			want: "a.go-2-\na.go-3-func One() {\na.go:4:\treturn\n--\na.go-6-\na.go-7-func Two() {\na.go:8:\treturn\n",
+		},
+		{
+			name: "ignore case",
+			args: map[string]interface{}{"pattern": "one", "ignoreCase": true, "type": "ts"},
+			want: "b.ts:1:export function one() {}\nb.ts:2:const ONE = 1;\n",
+		},
+		{
+			name: "fixed strings",
+			args: map[string]interface{}{"pattern": "a.b", "fixedStrings": true},
+			want: "c.txt:1:a.b\n\nFound 1 matches",
+		},
+		{
+			name: "multiline",
+			args: map[string]interface{}{"pattern": `One\(\) \{\n\s*return`, "multiline": true},
+			want: "a.go:3:func One() {\na.go:4:\treturn\n\nFound 2 matches",
+		},
+		{
+			name: "files with matches",
+			args: map[string]interface{}{"pattern": "package", "outputMode": "files_with_matches"},
+			want: "a.go\nd.go\n\nFound 2 matching files",
+		},
+		{
+			name: "count",
+			args: map[string]interface{}{"pattern": "return", "outputMode": "count"},
+			want: "a.go:2\n\nFound 2 matches",
+		},
+		{
+			name: "type filter",
+			args: map[string]interface{}{"pattern": "package", "type": "go", "outputMode": "files_with_matches", "offset": 1.0, "limit": 1.0},
+			want: "d.go\n\nFound 1 matching files",
+		},
+		{
+			name: "pagination",
+			args: map[string]interface{}{"pattern": "return", "limit": 1.0},
+			want: "a.go:4:\treturn\n\nFound 1 matches in 1 files (showing first 1 results; use offset 1 for more)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tool.Execute(context.Background(), tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(result.Content, "<grep_results>\n"+tt.want) {
+				t.Errorf("got:\n%s\nwant it to start with:\n%s", result.Content, tt.want)
+			}
+		})
+	}
+
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"pattern": "x", "type": "cobol"}); err == nil {
+		t.Error("expected an error for an unknown file type")
+	}
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"pattern": "x", "outputMode": "json"}); err == nil {
+		t.Error("expected an error for an unknown output mode")
+	}
+}
+
+func TestGrepSearch_Cancelled(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "match\n"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := &grepSearch{regex: regexp.MustCompile("match"), mode: grepModeContent, limit: 10, workers: 2}
+	if _, err := s.run(ctx, dir); err == nil {
+		t.Error("expected an error from a cancelled search")
+	}
+}
+
+func TestGrepTool_MultilineSkipsLargeFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"small.txt": "begin\nend\n",
+		"large.txt": "begin\nend\n" + strings.Repeat("x", grepMultilineMaxBytes),
+	})
+
+	result, err := NewGrepTool(dir).Execute(context.Background(), map[string]interface{}{"pattern": `begin\nend`, "multiline": true, "outputMode": grepModeFiles})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(result.Content, "small.txt") || strings.Contains(result.Content, "large.txt") {
+		t.Errorf("want only the small file:\n%s", result.Content)
+	}
+}
+
+func TestScanLines(t *testing.T) {
+	var lines []string
+	reader := bufio.NewReaderSize(strings.NewReader("a\r\n\nbb\nccc"), 16)
+	scanLines(reader, func(lineNum int, line []byte) bool {
+		lines = append(lines, fmt.Sprintf("%d:%s", lineNum, line))
+		return true
+	})
+	if got := strings.Join(lines, ","); got != "1:a,2:,3:bb,4:ccc" {
+		t.Errorf("lines = %s", got)
+	}
+}
+
+// grepBenchTree is built once, as writing 100k files takes a while.
+var grepBenchTree string
+
+// benchTree returns a tree of 100k small source files, or 10k with -short,
+// in nested directories. One in a thousand files contains "needle".
+func benchTree(b *testing.B) string {
+	b.Helper()
+	if grepBenchTree != "" {
+		return grepBenchTree
+	}
+
+	files := 100000
+	if testing.Short() {
+		files = 10000
+	}
+	dir, err := os.MkdirTemp("", "grep_bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	content := strings.Repeat("func handler(w http.ResponseWriter, r *http.Request) {\n\treturn\n}\n", 20)
+	for i := 0; i < files; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("d%02d", i%100), fmt.Sprintf("e%02d", i/100%100))
+		if i < 10000 {
+			if err := os.MkdirAll(sub, 0755); err != nil {
+				b.Fatal(err)
+			}
+		}
+		body := content
+		if i%1000 == 0 {
+			body += "// needle\n"
+		}
+		if err := os.WriteFile(filepath.Join(sub, fmt.Sprintf("f%d.go", i)), []byte(body), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	grepBenchTree = dir
+	return dir
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if grepBenchTree != "" {
+		os.RemoveAll(grepBenchTree)
+	}
+	os.Exit(code)
+}
+
+func benchmarkGrep(b *testing.B, pattern string, workers, limit int) {
+	dir := benchTree(b)
+	s := &grepSearch{regex: regexp.MustCompile(pattern), mode: grepModeContent, limit: limit, workers: workers}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.run(context.Background(), dir); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// The rare pattern has to search every file; the common one stops at the
+// result limit.
+func BenchmarkGrep_Rare_1Worker(b *testing.B) { benchmarkGrep(b, "needle", 1, 100) }
+func BenchmarkGrep_Rare_AllWorkers(b *testing.B) {
+	benchmarkGrep(b, "needle", runtime.NumCPU(), 100)
+}
+func BenchmarkGrep_Common_1Worker(b *testing.B) { benchmarkGrep(b, "handler", 1, 100) }
+func BenchmarkGrep_Common_AllWorkers(b *testing.B) {
+	benchmarkGrep(b, "handler", runtime.NumCPU(), 100)
+}
@@ -0,0 +1,308 @@
+package tools
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFileNames are read in every directory. .klaudkodignore comes last,
+// so it can exclude files git tracks or bring back ones git ignores.
+var ignoreFileNames = []string{".gitignore", ".klaudkodignore"}
+
+// defaultIgnores are skipped even where there are no ignore files, as
+// searching them is rarely wanted. Ignore files can re-include them.
+var defaultIgnores = "node_modules/\nvendor/\n__pycache__/\n.venv/\n"
+
+// vcsDirs are never walked into.
+var vcsDirs = map[string]bool{
+	".git": true,
+	".hg":  true,
+	".svn": true,
+}
+
+// ignoreRule is one pattern of an ignore file, in gitignore syntax.
+type ignoreRule struct {
+	// base is the slash-separated directory the rule is relative to, from
+	// the ignore root. It is empty for rules that apply everywhere.
+	base     string
+	segments []string
+	negate   bool
+	dirOnly  bool
+	// anchored rules match the whole path from base; others match the
+	// name at any depth.
+	anchored bool
+}
+
+// parseIgnoreRules parses the contents of an ignore file found in base.
+func parseIgnoreRules(data, base string) []ignoreRule {
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Trailing spaces are ignored unless escaped.
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+			line = line[:len(line)-1]
+		}
+
+		rule := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		rule.segments = strings.Split(line, "/")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// match reports whether the rule applies to rel, a slash-separated path from
+// the ignore root.
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "" {
+		rest, ok := strings.CutPrefix(rel, r.base+"/")
+		if !ok {
+			return false
+		}
+		rel = rest
+	}
+	if !r.anchored {
+		matched, _ := path.Match(r.segments[0], path.Base(rel))
+		return matched
+	}
+	return matchSegments(r.segments, strings.Split(rel, "/"))
+}
+
+// matchSegments matches path segments against pattern segments, where "**"
+// stands for any number of directories. A trailing "**" matches everything
+// inside a directory but not the directory itself.
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		if len(pattern) == 1 {
+			return len(parts) > 0
+		}
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if matched, _ := path.Match(pattern[0], parts[0]); !matched {
+		return false
+	}
+	return matchSegments(pattern[1:], parts[1:])
+}
+
+// ignoreMatcher holds the rules in effect in one directory, from lowest to
+// highest precedence.
+type ignoreMatcher struct {
+	rules []ignoreRule
+}
+
+// ignored reports whether rel is excluded. As in git, the last matching
+// rule decides.
+func (m *ignoreMatcher) ignored(rel string, isDir bool) bool {
+	for i := len(m.rules) - 1; i >= 0; i-- {
+		if m.rules[i].match(rel, isDir) {
+			return !m.rules[i].negate
+		}
+	}
+	return false
+}
+
+// with returns a matcher that adds rules on top of m's.
+func (m *ignoreMatcher) with(rules []ignoreRule) *ignoreMatcher {
+	if len(rules) == 0 {
+		return m
+	}
+	combined := make([]ignoreRule, 0, len(m.rules)+len(rules))
+	combined = append(combined, m.rules...)
+	combined = append(combined, rules...)
+	return &ignoreMatcher{rules: combined}
+}
+
+// newIgnoreMatcher returns the matcher for dir and the root its rules are
+// relative to. Inside a git repository that is the repository root, and the
+// rules are, from lowest precedence: the defaults, the global excludes
+// file, .git/info/exclude, and the ignore files of every directory from the
+// root down to dir.
+func newIgnoreMatcher(dir string) (string, *ignoreMatcher) {
+	m := &ignoreMatcher{}
+	m = m.with(parseIgnoreRules(defaultIgnores, ""))
+
+	root := dir
+	if repo, gitDir := findRepository(dir); repo != "" {
+		root = repo
+		m = m.with(readIgnoreFile(globalExcludesFile(), ""))
+		m = m.with(readIgnoreFile(filepath.Join(gitDir, "info", "exclude"), ""))
+	}
+
+	var dirs []string
+	for d := dir; ; d = filepath.Dir(d) {
+		dirs = append([]string{d}, dirs...)
+		if d == root || filepath.Dir(d) == d {
+			break
+		}
+	}
+	for _, d := range dirs {
+		m = m.with(readIgnoreFiles(root, d))
+	}
+	return root, m
+}
+
+// readIgnoreFiles reads the ignore files in dir.
+func readIgnoreFiles(root, dir string) []ignoreRule {
+	base, err := filepath.Rel(root, dir)
+	if err != nil {
+		return nil
+	}
+	base = filepath.ToSlash(base)
+	if base == "." {
+		base = ""
+	}
+	var rules []ignoreRule
+	for _, name := range ignoreFileNames {
+		rules = append(rules, readIgnoreFile(filepath.Join(dir, name), base)...)
+	}
+	return rules
+}
+
+func readIgnoreFile(path, base string) []ignoreRule {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return parseIgnoreRules(string(data), base)
+}
+
+// findRepository returns the root and git directory of the repository
+// containing dir, or empty strings outside one.
+func findRepository(dir string) (string, string) {
+	for d := dir; ; d = filepath.Dir(d) {
+		gitPath := filepath.Join(d, ".git")
+		if info, err := os.Stat(gitPath); err == nil {
+			if info.IsDir() {
+				return d, gitPath
+			}
+			return d, resolveGitDir(d, gitPath)
+		}
+		if filepath.Dir(d) == d {
+			return "", ""
+		}
+	}
+}
+
+// resolveGitDir follows the .git file of a worktree or submodule to the
+// directory holding info/exclude, which for a worktree is the main
+// repository's.
+func resolveGitDir(dir, gitFile string) string {
+	data, err := os.ReadFile(gitFile)
+	if err != nil {
+		return ""
+	}
+	gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir:")
+	if !ok {
+		return ""
+	}
+	gitDir = strings.TrimSpace(gitDir)
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(dir, gitDir)
+	}
+	if common, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir := strings.TrimSpace(string(common))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+		return filepath.Clean(commonDir)
+	}
+	return gitDir
+}
+
+// globalExcludesFile returns the user's global ignore file: core.excludesFile
+// from their git config, or git's default location.
+func globalExcludesFile() string {
+	home, _ := os.UserHomeDir()
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" && home != "" {
+		configDir = filepath.Join(home, ".config")
+	}
+
+	// ~/.gitconfig is read after the XDG file, so it wins.
+	var configs []string
+	if home != "" {
+		configs = append(configs, filepath.Join(home, ".gitconfig"))
+	}
+	if configDir != "" {
+		configs = append(configs, filepath.Join(configDir, "git", "config"))
+	}
+	for _, config := range configs {
+		if path := gitConfigValue(config, "core", "excludesfile"); path != "" {
+			if rest, ok := strings.CutPrefix(path, "~/"); ok && home != "" {
+				path = filepath.Join(home, rest)
+			}
+			return path
+		}
+	}
+
+	if configDir == "" {
+		return ""
+	}
+	return filepath.Join(configDir, "git", "ignore")
+}
+
+// gitConfigValue reads a key from a git config file. It handles the plain
+// "key = value" form, which is all excludesFile needs, not includes or
+// subsections.
+func gitConfigValue(path, section, key string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	current := ""
+	value := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			current = strings.ToLower(strings.Trim(line, "[] \t"))
+			continue
+		}
+		name, val, ok := strings.Cut(line, "=")
+		if !ok || current != section || !strings.EqualFold(strings.TrimSpace(name), key) {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(val), `"`)
+	}
+	return value
+}
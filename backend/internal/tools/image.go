@@ -0,0 +1,48 @@
+package tools
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/jack/klaudkod/backend/internal/llm"
+)
+
+// readImage returns an image file as an image for the model, checking that
+// its content matches its extension.
+func readImage(path, wantType string, size int64) (ToolResult, error) {
+	if size > llm.MaxImageBytes {
+		return ToolResult{}, fmt.Errorf("image too large: %s is %d bytes, the limit is %d", path, size, llm.MaxImageBytes)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ToolResult{}, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	mediaType := http.DetectContentType(data)
+	if mediaType != wantType {
+		return ToolResult{}, fmt.Errorf("cannot read image: %s is not a valid %s file (detected %s)", path, strings.TrimPrefix(wantType, "image/"), mediaType)
+	}
+
+	description := fmt.Sprintf("Image %s (%s, %d bytes)", path, mediaType, len(data))
+	// WebP has no standard library decoder, so its size is not reported.
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		description = fmt.Sprintf("Image %s (%s, %dx%d, %d bytes)", path, mediaType, cfg.Width, cfg.Height, len(data))
+	}
+
+	return ToolResult{
+		Content: description + " is attached.",
+		Images:  []llm.Image{{MediaType: mediaType, Data: data}},
+		Metadata: map[string]interface{}{
+			"media_type": mediaType,
+			"bytes":      len(data),
+		},
+	}, nil
+}
@@ -0,0 +1,61 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jack/klaudkod/backend/internal/llm"
+)
+
+func TestReadFileTool_Images(t *testing.T) {
+	tmpDir := t.TempDir()
+	tool := NewReadFileTool(tmpDir)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatalf("failed to encode png: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "shot.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "fake.jpg"), []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "big.gif"), make([]byte, llm.MaxImageBytes+1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("png", func(t *testing.T) {
+		result, err := tool.Execute(ctx, map[string]interface{}{"filePath": "shot.png"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(result.Images) != 1 || result.Images[0].MediaType != "image/png" || !bytes.Equal(result.Images[0].Data, buf.Bytes()) {
+			t.Fatalf("images = %+v, want the png", result.Images)
+		}
+		if !strings.Contains(result.Content, "3x2") {
+			t.Errorf("content %q does not give the dimensions", result.Content)
+		}
+	})
+
+	t.Run("wrong content", func(t *testing.T) {
+		_, err := tool.Execute(ctx, map[string]interface{}{"filePath": "fake.jpg"})
+		if err == nil || !strings.Contains(err.Error(), "not a valid jpeg") {
+			t.Errorf("expected invalid image error, got %v", err)
+		}
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		_, err := tool.Execute(ctx, map[string]interface{}{"filePath": "big.gif"})
+		if err == nil || !strings.Contains(err.Error(), "too large") {
+			t.Errorf("expected size error, got %v", err)
+		}
+	})
+}
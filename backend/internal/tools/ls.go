@@ -0,0 +1,203 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultLsDepth      = 3
+	defaultLsDirEntries = 50
+	// maxLsEntries bounds the whole listing, whatever the depth.
+	maxLsEntries = 1000
+)
+
+// LsTool lists a directory as an indented tree. It gives the model a view
+// of a project's layout within the working directory, where bash ls or
+// tree could list anything.
+type LsTool struct {
+	workingDir string
+}
+
+func NewLsTool(workingDir string) *LsTool {
+	return &LsTool{
+		workingDir: workingDir,
+	}
+}
+
+func (t *LsTool) Name() string {
+	return "ls"
+}
+
+func (t *LsTool) Description() string {
+	return "List a directory as an indented tree with file sizes, to see a project's structure. Directories end with /. Long directories are cut short with a marker saying how many entries were left out. Files excluded by .gitignore and .klaudkodignore are skipped unless noIgnore is set"
+}
+
+func (t *LsTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": "Directory to list (defaults to working directory)",
+			},
+			"depth": map[string]interface{}{
+				"type":        "integer",
+				"description": fmt.Sprintf("How many levels of the tree to show; 1 lists only the directory itself (defaults to %d)", defaultLsDepth),
+			},
+			"maxEntries": map[string]interface{}{
+				"type":        "integer",
+				"description": fmt.Sprintf("Maximum number of entries to show per directory (defaults to %d)", defaultLsDirEntries),
+			},
+			"noIgnore": noIgnoreParameter,
+		},
+	}
+}
+
+// lsNode is a directory entry in the tree being listed.
+type lsNode struct {
+	name     string
+	isDir    bool
+	size     int64
+	children []*lsNode
+	// more counts the entries left out of children.
+	more int
+}
+
+func (t *LsTool) Execute(ctx context.Context, args map[string]interface{}) (ToolResult, error) {
+	searchPath := t.workingDir
+	if path, ok := args["path"].(string); ok {
+		if !filepath.IsAbs(path) {
+			searchPath = filepath.Join(t.workingDir, path)
+		} else {
+			searchPath = path
+		}
+	}
+
+	searchPath = filepath.Clean(searchPath)
+	if !strings.HasPrefix(searchPath, t.workingDir+string(filepath.Separator)) && searchPath != t.workingDir {
+		return ToolResult{}, fmt.Errorf("access denied: path is outside working directory")
+	}
+
+	info, err := os.Stat(searchPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ToolResult{}, fmt.Errorf("path not found: %s", searchPath)
+		}
+		return ToolResult{}, fmt.Errorf("failed to stat path: %w", err)
+	}
+
+	if !info.IsDir() {
+		return ToolResult{}, fmt.Errorf("path is not a directory: %s", searchPath)
+	}
+
+	depth := defaultLsDepth
+	if d, ok := args["depth"].(float64); ok {
+		depth = int(d)
+	}
+	dirEntries := defaultLsDirEntries
+	if m, ok := args["maxEntries"].(float64); ok {
+		dirEntries = int(m)
+	}
+	if depth < 1 || dirEntries < 1 {
+		return ToolResult{}, fmt.Errorf("depth and maxEntries must be at least 1")
+	}
+
+	noIgnore, _ := args["noIgnore"].(bool)
+	opts := walkOptions{noIgnore: noIgnore, dirs: true, maxDepth: depth}
+
+	root := &lsNode{isDir: true}
+	nodes := map[string]*lsNode{searchPath: root}
+	total := 0
+	stopped := false
+	err = walkFiles(ctx, searchPath, opts, func(path string, d fs.DirEntry) error {
+		parent := nodes[filepath.Dir(path)]
+		if len(parent.children) >= dirEntries || stopped {
+			parent.more++
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if total == maxLsEntries {
+			// Keep walking only to count what was left out.
+			stopped = true
+			parent.more++
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		total++
+
+		node := &lsNode{name: d.Name(), isDir: d.IsDir()}
+		if d.IsDir() {
+			nodes[path] = node
+		} else if info, err := d.Info(); err == nil {
+			node.size = info.Size()
+		}
+		parent.children = append(parent.children, node)
+		return nil
+	})
+	if err != nil {
+		return ToolResult{}, fmt.Errorf("failed to walk directory: %w", err)
+	}
+
+	relPath, err := filepath.Rel(t.workingDir, searchPath)
+	if err != nil {
+		relPath = searchPath
+	}
+
+	var builder strings.Builder
+	builder.WriteString("<ls_results>\n")
+	builder.WriteString(filepath.ToSlash(relPath) + "/\n")
+	writeLsTree(&builder, root, 1)
+
+	builder.WriteString(fmt.Sprintf("\nListed %d entries", total))
+	if stopped {
+		builder.WriteString(fmt.Sprintf(" (stopped at %d entries; list a subdirectory or lower depth for the rest)", maxLsEntries))
+	}
+	builder.WriteString("\n</ls_results>")
+
+	return ToolResult{
+		Content: builder.String(),
+		IsError: false,
+	}, nil
+}
+
+// writeLsTree writes the entries of a directory, indented two spaces per
+// level.
+func writeLsTree(builder *strings.Builder, dir *lsNode, level int) {
+	indent := strings.Repeat("  ", level)
+	for _, node := range dir.children {
+		if node.isDir {
+			builder.WriteString(indent + node.name + "/\n")
+			writeLsTree(builder, node, level+1)
+		} else {
+			builder.WriteString(fmt.Sprintf("%s%s (%s)\n", indent, node.name, formatSize(node.size)))
+		}
+	}
+	if dir.more > 0 {
+		builder.WriteString(fmt.Sprintf("%s... %d more\n", indent, dir.more))
+	}
+}
+
+// formatSize renders a byte count in the largest unit it has at least one
+// of, as in 512 B, 1.5 KB or 12.0 MB.
+func formatSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	for _, unit := range []string{"KB", "MB", "GB"} {
+		value /= 1024
+		if value < 1024 || unit == "GB" {
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,91 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLsTool_Tree(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	files := map[string]string{
+		".gitignore":         "dist/\n",
+		"README.md":          strings.Repeat("x", 1536),
+		"dist/app.js":        "",
+		"src/main.go":        "package main\n",
+		"src/pkg/a/deep.go":  "",
+		"node_modules/x.js":  "",
+		"src/pkg/b/other.go": "",
+	}
+	for i := 0; i < 5; i++ {
+		files[fmt.Sprintf("many/f%d.txt", i)] = ""
+	}
+	writeTree(t, dir, files)
+	tool := NewLsTool(dir)
+
+	tests := []struct {
+		name string
+		args map[string]interface{}
+		want string
+	}{
+		{
+			name: "defaults",
+			args: map[string]interface{}{},
+			want: "./\n" +
+				"  .gitignore (6 B)\n" +
+				"  README.md (1.5 KB)\n" +
+				"  many/\n" +
+				"    f0.txt (0 B)\n    f1.txt (0 B)\n    f2.txt (0 B)\n    f3.txt (0 B)\n    f4.txt (0 B)\n" +
+				"  src/\n" +
+				"    main.go (13 B)\n" +
+				"    pkg/\n" +
+				"      a/\n" +
+				"      b/\n" +
+				"\nListed 13 entries",
+		},
+		{
+			name: "depth and entry cap",
+			args: map[string]interface{}{"path": "many", "depth": 1.0, "maxEntries": 2.0},
+			want: "many/\n  f0.txt (0 B)\n  f1.txt (0 B)\n  ... 3 more\n\nListed 2 entries",
+		},
+		{
+			name: "no ignore",
+			args: map[string]interface{}{"depth": 1.0, "maxEntries": 3.0, "noIgnore": true},
+			want: "./\n  .gitignore (6 B)\n  README.md (1.5 KB)\n  dist/\n  ... 3 more\n\nListed 3 entries",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tool.Execute(context.Background(), tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := "<ls_results>\n" + tt.want + "\n</ls_results>"
+			if result.Content != want {
+				t.Errorf("got\n%s\nwant\n%s", result.Content, want)
+			}
+		})
+	}
+
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"path": ".."}); err == nil {
+		t.Error("path outside the working directory was listed")
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[int64]string{
+		0:          "0 B",
+		1023:       "1023 B",
+		1024:       "1.0 KB",
+		5 << 20:    "5.0 MB",
+		3 << 30:    "3.0 GB",
+		2048 << 30: "2048.0 GB",
+	}
+	for size, want := range tests {
+		if got := formatSize(size); got != want {
+			t.Errorf("formatSize(%d) = %q, want %q", size, got, want)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxNotebookBytes is the largest notebook read or edited. Notebooks
+	// with image outputs get large.
+	maxNotebookBytes = 64 * 1024 * 1024
+	// maxNotebookOutputBytes bounds each cell output shown.
+	maxNotebookOutputBytes = 4096
+	// maxNotebookRenderBytes bounds one read of a notebook; the rest is
+	// read with offset.
+	maxNotebookRenderBytes = 128 * 1024
+)
+
+// notebookText is a multiline string of a notebook, stored either as a
+// string or as a list of lines. Values of other types, such as JSON
+// outputs, are kept as their JSON.
+type notebookText string
+
+func (t *notebookText) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*t = notebookText(s)
+		return nil
+	}
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err == nil {
+		*t = notebookText(strings.Join(lines, ""))
+		return nil
+	}
+	*t = notebookText(data)
+	return nil
+}
+
+type notebookOutput struct {
+	OutputType string                  `json:"output_type"`
+	Name       string                  `json:"name"`
+	Text       notebookText            `json:"text"`
+	Data       map[string]notebookText `json:"data"`
+	Ename      string                  `json:"ename"`
+	Evalue     string                  `json:"evalue"`
+	Traceback  []string                `json:"traceback"`
+}
+
+type notebookCell struct {
+	ID             string           `json:"id"`
+	CellType       string           `json:"cell_type"`
+	Source         notebookText     `json:"source"`
+	ExecutionCount *int             `json:"execution_count"`
+	Outputs        []notebookOutput `json:"outputs"`
+}
+
+type notebook struct {
+	Cells    []notebookCell `json:"cells"`
+	Metadata struct {
+		Kernelspec struct {
+			Language string `json:"language"`
+		} `json:"kernelspec"`
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+	} `json:"metadata"`
+}
+
+// notebookCellID returns the ID a cell is shown and edited by: its id, or
+// cell-N from its position for notebooks older than nbformat 4.5.
+func notebookCellID(id string, index int) string {
+	if id != "" {
+		return id
+	}
+	return fmt.Sprintf("cell-%d", index)
+}
+
+// ansiEscape matches the terminal colors of tracebacks.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// notebookOutputText renders an output of a code cell as text. Outputs
+// without a text form are noted by their media types.
+func notebookOutputText(output notebookOutput) string {
+	var text string
+	switch output.OutputType {
+	case "stream":
+		text = string(output.Text)
+	case "error":
+		text = output.Ename + ": " + output.Evalue
+		if len(output.Traceback) > 0 {
+			text = strings.Join(output.Traceback, "\n")
+		}
+		text = ansiEscape.ReplaceAllString(text, "")
+	default:
+		if plain, ok := output.Data["text/plain"]; ok {
+			text = string(plain)
+		}
+		var others []string
+		for mediaType := range output.Data {
+			if mediaType != "text/plain" {
+				others = append(others, mediaType)
+			}
+		}
+		if text == "" && len(others) > 0 {
+			sort.Strings(others)
+			text = fmt.Sprintf("[%s output]", strings.Join(others, ", "))
+		}
+	}
+	text, _ = truncateOutput(strings.TrimRight(text, "\n"), maxNotebookOutputBytes, 40, 20)
+	return strings.TrimRight(text, "\n")
+}
+
+// readNotebook renders the cells of a Jupyter notebook, with their types,
+// sources and text outputs. offset and limit count cells.
+func readNotebook(path string, size int64, args map[string]interface{}) (ToolResult, error) {
+	if size > maxNotebookBytes {
+		return ToolResult{}, fmt.Errorf("notebook too large to read: %s is %s, the limit is %s", path, formatSize(size), formatSize(maxNotebookBytes))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ToolResult{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	var nb notebook
+	if err := json.Unmarshal(data, &nb); err != nil {
+		return ToolResult{}, fmt.Errorf("cannot read notebook %s: invalid JSON: %w", path, err)
+	}
+
+	offset := 0
+	if o, ok := args["offset"].(float64); ok && o > 0 {
+		offset = int(o)
+	}
+	limit := len(nb.Cells)
+	if l, ok := args["limit"].(float64); ok && l > 0 {
+		limit = int(l)
+	}
+	language := nb.Metadata.LanguageInfo.Name
+	if language == "" {
+		language = nb.Metadata.Kernelspec.Language
+	}
+
+	var builder strings.Builder
+	builder.WriteString("<file>\n")
+	next := len(nb.Cells)
+	for i := offset; i < len(nb.Cells); i++ {
+		if i-offset >= limit || i > offset && builder.Len() > maxNotebookRenderBytes {
+			next = i
+			break
+		}
+		cell := nb.Cells[i]
+		attrs := fmt.Sprintf("id=%q type=%q", notebookCellID(cell.ID, i), cell.CellType)
+		if cell.ExecutionCount != nil {
+			attrs += fmt.Sprintf(" execution_count=\"%d\"", *cell.ExecutionCount)
+		}
+		builder.WriteString(fmt.Sprintf("<cell %s>\n", attrs))
+		if source := strings.TrimRight(string(cell.Source), "\n"); source != "" {
+			builder.WriteString(source)
+			builder.WriteString("\n")
+		}
+		for _, output := range cell.Outputs {
+			if text := notebookOutputText(output); text != "" {
+				builder.WriteString("<output>\n")
+				builder.WriteString(text)
+				builder.WriteString("\n</output>\n")
+			}
+		}
+		builder.WriteString("</cell>\n")
+	}
+
+	summary := fmt.Sprintf("%d cells", len(nb.Cells))
+	if language != "" {
+		summary += ", language " + language
+	}
+	if next < len(nb.Cells) {
+		builder.WriteString(fmt.Sprintf("\n(Notebook has more cells (%s). Use 'offset' parameter to read from cell %d)\n", summary, next))
+	} else {
+		builder.WriteString(fmt.Sprintf("\n(End of notebook - %s)\n", summary))
+	}
+	builder.WriteString("</file>")
+
+	return ToolResult{
+		Content: builder.String(),
+		IsError: false,
+	}, nil
+}
+
+// NotebookEditTool replaces, inserts or deletes cells of a Jupyter
+// notebook. Notebooks are JSON, which the model would otherwise have to
+// edit as text.
+type NotebookEditTool struct {
+	workingDir string
+}
+
+func NewNotebookEditTool(workingDir string) *NotebookEditTool {
+	return &NotebookEditTool{
+		workingDir: workingDir,
+	}
+}
+
+func (t *NotebookEditTool) Name() string {
+	return "notebook_edit"
+}
+
+func (t *NotebookEditTool) Description() string {
+	return "Edit a cell of a Jupyter notebook (.ipynb): replace its source, insert a new cell after it, or delete it. Cells are identified by the ids the read tool shows. Replacing the source of a code cell clears its outputs."
+}
+
+func (t *NotebookEditTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"filePath": map[string]interface{}{
+				"type":        "string",
+				"description": "The path to the notebook to edit",
+			},
+			"cellId": map[string]interface{}{
+				"type":        "string",
+				"description": "The id of the cell to replace or delete, or to insert after. Leave it out to insert at the start of the notebook",
+			},
+			"editMode": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"replace", "insert", "delete"},
+				"description": "What to do with the cell (defaults to replace)",
+			},
+			"cellType": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"code", "markdown", "raw"},
+				"description": "The type of the cell; required to insert, and changes the type of a replaced cell",
+			},
+			"source": map[string]interface{}{
+				"type":        "string",
+				"description": "The new source of the cell",
+			},
+		},
+		"required": []string{"filePath"},
+	}
+}
+
+func (t *NotebookEditTool) Execute(ctx context.Context, args map[string]interface{}) (ToolResult, error) {
+	filePath, ok := args["filePath"].(string)
+	if !ok {
+		return ToolResult{}, fmt.Errorf("filePath is required")
+	}
+	cellID, _ := args["cellId"].(string)
+	editMode := "replace"
+	if m, ok := args["editMode"].(string); ok && m != "" {
+		editMode = m
+	}
+	cellType, _ := args["cellType"].(string)
+	source, hasSource := args["source"].(string)
+
+	switch editMode {
+	case "replace", "insert":
+		if !hasSource {
+			return ToolResult{}, fmt.Errorf("source is required to %s a cell", editMode)
+		}
+	case "delete":
+	default:
+		return ToolResult{}, fmt.Errorf("invalid editMode %q: use replace, insert or delete", editMode)
+	}
+	switch cellType {
+	case "", "code", "markdown", "raw":
+	default:
+		return ToolResult{}, fmt.Errorf("invalid cellType %q: use code, markdown or raw", cellType)
+	}
+	if editMode == "insert" && cellType == "" {
+		return ToolResult{}, fmt.Errorf("cellType is required to insert a cell")
+	}
+	if editMode != "insert" && cellID == "" {
+		return ToolResult{}, fmt.Errorf("cellId is required to %s a cell", editMode)
+	}
+
+	// Resolve to absolute path
+	if !filepath.IsAbs(filePath) {
+		filePath = filepath.Join(t.workingDir, filePath)
+	}
+
+	// Clean the path
+	filePath = filepath.Clean(filePath)
+
+	// Validate path is within workingDir
+	if !strings.HasPrefix(filePath, t.workingDir+string(filepath.Separator)) && filePath != t.workingDir {
+		return ToolResult{}, fmt.Errorf("access denied: path is outside working directory")
+	}
+
+	if !strings.EqualFold(filepath.Ext(filePath), ".ipynb") {
+		return ToolResult{}, fmt.Errorf("not a notebook: %s. Use the write tool for other files", filePath)
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ToolResult{}, fmt.Errorf("file not found: %s", filePath)
+		}
+		return ToolResult{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.Size() > maxNotebookBytes {
+		return ToolResult{}, fmt.Errorf("notebook too large to edit: %s is %s, the limit is %s", filePath, formatSize(info.Size()), formatSize(maxNotebookBytes))
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return ToolResult{}, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	// Edit the notebook as generic JSON, so that fields this tool doesn't
+	// know about are kept.
+	var nb map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&nb); err != nil {
+		return ToolResult{}, fmt.Errorf("cannot edit notebook %s: invalid JSON: %w", filePath, err)
+	}
+	cells, ok := nb["cells"].([]interface{})
+	if !ok {
+		return ToolResult{}, fmt.Errorf("cannot edit notebook %s: it has no cells list", filePath)
+	}
+
+	index := -1
+	if cellID != "" {
+		index = findNotebookCell(cells, cellID)
+		if index < 0 {
+			return ToolResult{}, fmt.Errorf("cell not found: %s. Read the notebook to see its cell ids", cellID)
+		}
+	}
+
+	var message string
+	switch editMode {
+	case "replace":
+		cell, ok := cells[index].(map[string]interface{})
+		if !ok {
+			return ToolResult{}, fmt.Errorf("cannot edit notebook %s: cell %s is not an object", filePath, cellID)
+		}
+		if cellType != "" {
+			cell["cell_type"] = cellType
+		}
+		cell["source"] = notebookSourceLines(source)
+		if cell["cell_type"] == "code" {
+			// The outputs were of the old source.
+			cell["outputs"] = []interface{}{}
+			cell["execution_count"] = nil
+		} else {
+			delete(cell, "outputs")
+			delete(cell, "execution_count")
+		}
+		message = fmt.Sprintf("Replaced cell %s (%s)", cellID, cell["cell_type"])
+	case "insert":
+		cell := map[string]interface{}{
+			"cell_type": cellType,
+			"metadata":  map[string]interface{}{},
+			"source":    notebookSourceLines(source),
+		}
+		if cellType == "code" {
+			cell["outputs"] = []interface{}{}
+			cell["execution_count"] = nil
+		}
+		newID := fmt.Sprintf("cell-%d", index+1)
+		if notebookHasCellIDs(nb) {
+			newID = newNotebookCellID(cells)
+			cell["id"] = newID
+		}
+		cells = append(cells, nil)
+		copy(cells[index+2:], cells[index+1:])
+		cells[index+1] = cell
+		if index < 0 {
+			message = fmt.Sprintf("Inserted %s cell %s at the start of the notebook", cellType, newID)
+		} else {
+			message = fmt.Sprintf("Inserted %s cell %s after cell %s", cellType, newID, cellID)
+		}
+	case "delete":
+		cells = append(cells[:index], cells[index+1:]...)
+		message = fmt.Sprintf("Deleted cell %s", cellID)
+	}
+	nb["cells"] = cells
+
+	// Write the notebook the way Jupyter does: one-space indents, sorted
+	// keys and no escaping of HTML characters.
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", " ")
+	if err := encoder.Encode(nb); err != nil {
+		return ToolResult{}, fmt.Errorf("failed to encode notebook: %w", err)
+	}
+	if err := os.WriteFile(filePath, out.Bytes(), info.Mode().Perm()); err != nil {
+		return ToolResult{}, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return ToolResult{
+		Content: message,
+		IsError: false,
+	}, nil
+}
+
+// findNotebookCell returns the index of the cell with the given id, which
+// is either the cell's id or cell-N, or -1.
+func findNotebookCell(cells []interface{}, id string) int {
+	for i, c := range cells {
+		if cell, ok := c.(map[string]interface{}); ok && cell["id"] == id {
+			return i
+		}
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(id, "cell-")); err == nil && strings.HasPrefix(id, "cell-") && n >= 0 && n < len(cells) {
+		if cell, ok := cells[n].(map[string]interface{}); ok && cell["id"] == nil {
+			return n
+		}
+	}
+	return -1
+}
+
+// notebookHasCellIDs reports whether a notebook's format has cell ids,
+// which nbformat 4.5 added.
+func notebookHasCellIDs(nb map[string]interface{}) bool {
+	number := func(key string) int64 {
+		n, _ := nb[key].(json.Number)
+		v, _ := n.Int64()
+		return v
+	}
+	major, minor := number("nbformat"), number("nbformat_minor")
+	return major > 4 || major == 4 && minor >= 5
+}
+
+// newNotebookCellID returns a random cell id not used by cells.
+func newNotebookCellID(cells []interface{}) string {
+	for {
+		b := make([]byte, 4)
+		rand.Read(b)
+		id := hex.EncodeToString(b)
+		if findNotebookCell(cells, id) < 0 {
+			return id
+		}
+	}
+}
+
+// notebookSourceLines splits a cell source into lines that keep their line
+// endings, as notebooks store them.
+func notebookSourceLines(source string) []interface{} {
+	lines := []interface{}{}
+	for _, line := range strings.SplitAfter(source, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,172 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testNotebook = `{
+ "cells": [
+  {
+   "cell_type": "markdown",
+   "id": "intro",
+   "metadata": {},
+   "source": ["# Analysis\n", "Loads the data."]
+  },
+  {
+   "cell_type": "code",
+   "execution_count": 3,
+   "id": "load",
+   "metadata": {"tags": ["setup"]},
+   "outputs": [
+    {"name": "stdout", "output_type": "stream", "text": ["rows: 10\n"]},
+    {"data": {"image/png": "iVBORw0KGgo=", "text/plain": ["<Figure>"]}, "metadata": {}, "output_type": "display_data"},
+    {"data": {"image/png": "iVBORw0KGgo="}, "metadata": {}, "output_type": "display_data"},
+    {"ename": "ValueError", "evalue": "bad", "output_type": "error", "traceback": ["\u001b[0;31mValueError\u001b[0m: bad"]}
+   ],
+   "source": "df = load()"
+  }
+ ],
+ "metadata": {"kernelspec": {"language": "python", "name": "python3"}},
+ "nbformat": 4,
+ "nbformat_minor": 5
+}
+`
+
+func TestReadFileTool_Notebook(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "analysis.ipynb"), []byte(testNotebook), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tool := NewReadFileTool(dir)
+
+	result, err := tool.Execute(context.Background(), map[string]interface{}{"filePath": "analysis.ipynb"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "<file>\n" +
+		"<cell id=\"intro\" type=\"markdown\">\n# Analysis\nLoads the data.\n</cell>\n" +
+		"<cell id=\"load\" type=\"code\" execution_count=\"3\">\ndf = load()\n" +
+		"<output>\nrows: 10\n</output>\n" +
+		"<output>\n<Figure>\n</output>\n" +
+		"<output>\n[image/png output]\n</output>\n" +
+		"<output>\nValueError: bad\n</output>\n" +
+		"</cell>\n" +
+		"\n(End of notebook - 2 cells, language python)\n</file>"
+	if result.Content != want {
+		t.Errorf("got\n%s\nwant\n%s", result.Content, want)
+	}
+
+	result, err = tool.Execute(context.Background(), map[string]interface{}{"filePath": "analysis.ipynb", "limit": 1.0})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(result.Content, "id=\"load\"") || !strings.Contains(result.Content, "Use 'offset' parameter to read from cell 1") {
+		t.Errorf("limit was not applied to cells:\n%s", result.Content)
+	}
+
+	result, err = tool.Execute(context.Background(), map[string]interface{}{"filePath": "analysis.ipynb", "byteOffset": 0.0, "byteLimit": 10.0})
+	if err != nil || !strings.HasPrefix(result.Content, "<file>\n{\n \"cells\"") {
+		t.Errorf("byte range did not read the raw JSON: %v\n%s", err, result.Content)
+	}
+}
+
+func TestNotebookEditTool(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "analysis.ipynb")
+	if err := os.WriteFile(path, []byte(testNotebook), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tool := NewNotebookEditTool(dir)
+
+	edit := func(args map[string]interface{}) string {
+		t.Helper()
+		args["filePath"] = "analysis.ipynb"
+		result, err := tool.Execute(context.Background(), args)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return result.Content
+	}
+	cells := func() []map[string]interface{} {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var nb struct {
+			Cells []map[string]interface{} `json:"cells"`
+		}
+		if err := json.Unmarshal(data, &nb); err != nil {
+			t.Fatalf("notebook is not valid JSON: %v\n%s", err, data)
+		}
+		return nb.Cells
+	}
+
+	edit(map[string]interface{}{"cellId": "load", "source": "df = load(\"<path>\")\nprint(len(df))\n"})
+	got := cells()
+	if src := got[1]["source"]; len(src.([]interface{})) != 2 || src.([]interface{})[0] != "df = load(\"<path>\")\n" {
+		t.Errorf("source not replaced: %#v", src)
+	}
+	if outputs := got[1]["outputs"].([]interface{}); len(outputs) != 0 || got[1]["execution_count"] != nil {
+		t.Errorf("outputs not cleared: %#v", got[1])
+	}
+	if tags := got[1]["metadata"].(map[string]interface{})["tags"]; tags == nil {
+		t.Error("cell metadata was lost")
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "<path>") || !strings.HasPrefix(string(data), "{\n \"cells\": [\n  {\n") {
+		t.Errorf("notebook not written in Jupyter's format:\n%s", data)
+	}
+
+	message := edit(map[string]interface{}{"editMode": "insert", "cellId": "intro", "cellType": "code", "source": "x = 1"})
+	got = cells()
+	if len(got) != 3 || got[1]["cell_type"] != "code" || got[1]["id"] == nil || !strings.Contains(message, got[1]["id"].(string)) {
+		t.Errorf("cell not inserted after intro: %s\n%#v", message, got)
+	}
+
+	edit(map[string]interface{}{"editMode": "insert", "cellType": "markdown", "source": "Title"})
+	edit(map[string]interface{}{"editMode": "delete", "cellId": "intro"})
+	got = cells()
+	if len(got) != 3 || got[0]["source"].([]interface{})[0] != "Title" || got[0]["outputs"] != nil {
+		t.Errorf("expected inserted markdown cell first and intro deleted: %#v", got)
+	}
+
+	for _, args := range []map[string]interface{}{
+		{"filePath": "analysis.ipynb", "cellId": "missing", "source": "x"},
+		{"filePath": "analysis.ipynb", "editMode": "insert", "source": "x"},
+		{"filePath": "analysis.ipynb", "editMode": "move", "cellId": "load"},
+		{"filePath": "notes.txt", "cellId": "load", "source": "x"},
+		{"filePath": "../analysis.ipynb", "cellId": "load", "source": "x"},
+	} {
+		if _, err := tool.Execute(context.Background(), args); err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
+
+func TestNotebookEditTool_CellIndexes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "old.ipynb")
+	old := `{"cells": [{"cell_type": "code", "execution_count": null, "metadata": {}, "outputs": [], "source": ["a"]}], "metadata": {}, "nbformat": 4, "nbformat_minor": 2}`
+	if err := os.WriteFile(path, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tool := NewNotebookEditTool(dir)
+
+	result, err := tool.Execute(context.Background(), map[string]interface{}{"filePath": "old.ipynb", "editMode": "insert", "cellId": "cell-0", "cellType": "raw", "source": "b"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Content != "Inserted raw cell cell-1 after cell cell-0" {
+		t.Errorf("got %q", result.Content)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "\"id\"") {
+		t.Errorf("cell ids added to a notebook older than nbformat 4.5:\n%s", data)
+	}
+}
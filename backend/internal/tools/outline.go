@@ -0,0 +1,436 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxOutlineTypeLength bounds the type shown for a type declaration
+	// that isn't a struct or interface.
+	maxOutlineTypeLength = 80
+	// maxOutlineSymbols bounds how many declarations one symbol read
+	// returns.
+	maxOutlineSymbols = 20
+)
+
+// OutlineTool lists the declarations of Go source, or returns the source
+// of one of them, so that the model can find its way around a file without
+// reading all of it.
+type OutlineTool struct {
+	workingDir string
+}
+
+func NewOutlineTool(workingDir string) *OutlineTool {
+	return &OutlineTool{
+		workingDir: workingDir,
+	}
+}
+
+func (t *OutlineTool) Name() string {
+	return "outline"
+}
+
+func (t *OutlineTool) Description() string {
+	return "Outline Go source: list the top-level declarations of a .go file, or of the package in a directory, with their line ranges, signatures and the first sentence of their doc comments. Set symbol to get the source of just that declaration instead, such as a function, a type, a method as Type.Method, or a const or var. Much cheaper than reading whole files to find something."
+}
+
+func (t *OutlineTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": "A .go file, or a directory to outline the package in it (defaults to working directory)",
+			},
+			"symbol": map[string]interface{}{
+				"type":        "string",
+				"description": "The name of a declaration to return the source of, such as ParseFile, Config or Config.Load",
+			},
+			"exportedOnly": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Only list exported declarations",
+			},
+			"includeTests": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Include _test.go files when outlining a directory",
+			},
+		},
+	}
+}
+
+// outlineDecl is a top-level declaration, or one spec of a grouped const,
+// var or type declaration.
+type outlineDecl struct {
+	file string
+	// names are the names declared; recv is the receiver's base type for
+	// methods.
+	names []string
+	recv  string
+	// summary is the declaration without its body.
+	summary string
+	doc     string
+	// start and end are the first and last lines, from the doc comment
+	// on.
+	start, end int
+	// startOffset and endOffset are the byte range of the source.
+	startOffset, endOffset int
+}
+
+// outlineFile is a parsed Go file.
+type outlineFile struct {
+	rel string
+	src []byte
+	// err is a syntax error; what could be parsed is still outlined.
+	err   error
+	decls []outlineDecl
+}
+
+func (t *OutlineTool) Execute(ctx context.Context, args map[string]interface{}) (ToolResult, error) {
+	searchPath := t.workingDir
+	if path, ok := args["path"].(string); ok && path != "" {
+		if !filepath.IsAbs(path) {
+			searchPath = filepath.Join(t.workingDir, path)
+		} else {
+			searchPath = path
+		}
+	}
+
+	searchPath = filepath.Clean(searchPath)
+	if !strings.HasPrefix(searchPath, t.workingDir+string(filepath.Separator)) && searchPath != t.workingDir {
+		return ToolResult{}, fmt.Errorf("access denied: path is outside working directory")
+	}
+
+	info, err := os.Stat(searchPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ToolResult{}, fmt.Errorf("path not found: %s", searchPath)
+		}
+		return ToolResult{}, fmt.Errorf("failed to stat path: %w", err)
+	}
+
+	includeTests, _ := args["includeTests"].(bool)
+	var paths []string
+	if info.IsDir() {
+		entries, err := os.ReadDir(searchPath)
+		if err != nil {
+			return ToolResult{}, fmt.Errorf("failed to read directory: %w", err)
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !strings.HasSuffix(name, ".go") || !includeTests && strings.HasSuffix(name, "_test.go") {
+				continue
+			}
+			paths = append(paths, filepath.Join(searchPath, name))
+		}
+		if len(paths) == 0 {
+			return ToolResult{}, fmt.Errorf("no Go files found in %s", searchPath)
+		}
+	} else {
+		if !strings.HasSuffix(searchPath, ".go") {
+			return ToolResult{}, fmt.Errorf("not a Go file: %s. Outlines are only available for Go source", searchPath)
+		}
+		paths = []string{searchPath}
+	}
+
+	fset := token.NewFileSet()
+	var files []*outlineFile
+	for _, path := range paths {
+		if ctx.Err() != nil {
+			return ToolResult{}, ctx.Err()
+		}
+		file, err := t.parseFile(fset, path)
+		if err != nil {
+			return ToolResult{}, err
+		}
+		files = append(files, file)
+	}
+
+	if symbol, ok := args["symbol"].(string); ok && symbol != "" {
+		return readSymbol(files, symbol)
+	}
+
+	exportedOnly, _ := args["exportedOnly"].(bool)
+	var builder strings.Builder
+	builder.WriteString("<outline_results>\n")
+	total := 0
+	for _, file := range files {
+		var lines []string
+		if file.err != nil {
+			lines = append(lines, fmt.Sprintf("  (syntax error: %v)", file.err))
+		}
+		for _, decl := range file.decls {
+			if exportedOnly && !decl.exported() {
+				continue
+			}
+			line := fmt.Sprintf("  %d-%d %s", decl.start, decl.end, decl.summary)
+			if decl.doc != "" {
+				line += " // " + decl.doc
+			}
+			lines = append(lines, line)
+			total++
+		}
+		// Files with nothing to list are left out.
+		if len(lines) > 0 || len(files) == 1 {
+			builder.WriteString(file.rel + "\n")
+			for _, line := range lines {
+				builder.WriteString(line + "\n")
+			}
+		}
+	}
+	builder.WriteString(fmt.Sprintf("\nFound %d declarations in %d files\n", total, len(files)))
+	builder.WriteString("</outline_results>")
+
+	return ToolResult{
+		Content: builder.String(),
+		IsError: false,
+	}, nil
+}
+
+// parseFile parses a Go file and collects its declarations.
+func (t *OutlineTool) parseFile(fset *token.FileSet, path string) (*outlineFile, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	rel, err := filepath.Rel(t.workingDir, path)
+	if err != nil {
+		rel = path
+	}
+	file := &outlineFile{rel: filepath.ToSlash(rel), src: src}
+
+	f, err := parser.ParseFile(fset, path, src, parser.ParseComments|parser.SkipObjectResolution)
+	if f == nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	file.err = err
+
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			decl := outlineDecl{names: []string{d.Name.Name}, doc: docSummary(d.Doc)}
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				decl.recv = receiverType(d.Recv.List[0].Type)
+			}
+			decl.summary = nodeString(fset, &ast.FuncDecl{Recv: d.Recv, Name: d.Name, Type: d.Type})
+			file.add(fset, decl, d.Doc, d)
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT {
+				continue
+			}
+			for _, spec := range d.Specs {
+				// A lone spec's doc comment is the declaration's.
+				specDoc := d.Doc
+				var node ast.Node = d
+				if d.Lparen.IsValid() {
+					specDoc = nil
+					node = spec
+				}
+				var decl outlineDecl
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if s.Doc != nil {
+						specDoc = s.Doc
+					}
+					decl.names = []string{s.Name.Name}
+					decl.summary = "type " + s.Name.Name + typeParams(fset, s) + " " + typeSummary(fset, s)
+				case *ast.ValueSpec:
+					if s.Doc != nil {
+						specDoc = s.Doc
+					}
+					for _, name := range s.Names {
+						decl.names = append(decl.names, name.Name)
+					}
+					decl.summary = d.Tok.String() + " " + strings.Join(decl.names, ", ")
+					if s.Type != nil {
+						decl.summary += " " + nodeString(fset, s.Type)
+					}
+				}
+				decl.doc = docSummary(specDoc)
+				file.add(fset, decl, specDoc, node)
+			}
+		}
+	}
+	return file, nil
+}
+
+// add records a declaration spanning its doc comment and node.
+func (f *outlineFile) add(fset *token.FileSet, decl outlineDecl, docGroup *ast.CommentGroup, node ast.Node) {
+	start := node.Pos()
+	if docGroup != nil {
+		start = docGroup.Pos()
+	}
+	decl.file = f.rel
+	decl.start = fset.Position(start).Line
+	decl.end = fset.Position(node.End()).Line
+	decl.startOffset = fset.Position(start).Offset
+	decl.endOffset = fset.Position(node.End()).Offset
+	// Take the start of the line, for indented specs of groups.
+	for decl.startOffset > 0 && f.src[decl.startOffset-1] != '\n' {
+		decl.startOffset--
+	}
+	f.decls = append(f.decls, decl)
+}
+
+func (d outlineDecl) exported() bool {
+	if d.recv != "" && !token.IsExported(d.recv) {
+		return false
+	}
+	for _, name := range d.names {
+		if token.IsExported(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the declaration is the symbol: a name, or
+// Type.Method for a method.
+func (d outlineDecl) matches(symbol string) bool {
+	if recv, name, ok := strings.Cut(symbol, "."); ok {
+		return d.recv == recv && d.names[0] == name
+	}
+	for _, name := range d.names {
+		if name == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+// readSymbol returns the source of the declarations named symbol, with
+// line numbers as the read tool shows them.
+func readSymbol(files []*outlineFile, symbol string) (ToolResult, error) {
+	var builder strings.Builder
+	found := 0
+	for _, file := range files {
+		for _, decl := range file.decls {
+			if !decl.matches(symbol) {
+				continue
+			}
+			found++
+			if found > maxOutlineSymbols {
+				continue
+			}
+			builder.WriteString(fmt.Sprintf("<symbol file=%q lines=\"%d-%d\">\n", decl.file, decl.start, decl.end))
+			source := string(file.src[decl.startOffset:decl.endOffset])
+			for i, line := range strings.Split(source, "\n") {
+				builder.WriteString(fmt.Sprintf("%05d| %s\n", decl.start+i, line))
+			}
+			builder.WriteString("</symbol>\n")
+		}
+	}
+
+	if found == 0 {
+		var similar []string
+		for _, file := range files {
+			for _, decl := range file.decls {
+				for _, name := range decl.names {
+					if decl.recv != "" {
+						name = decl.recv + "." + name
+					}
+					if strings.Contains(strings.ToLower(name), strings.ToLower(symbol)) {
+						similar = append(similar, name)
+					}
+				}
+			}
+		}
+		if len(similar) > 0 {
+			sort.Strings(similar)
+			return ToolResult{}, fmt.Errorf("symbol not found: %s. Similar declarations: %s", symbol, strings.Join(similar, ", "))
+		}
+		return ToolResult{}, fmt.Errorf("symbol not found: %s. Outline the file or package to see its declarations", symbol)
+	}
+	if found > maxOutlineSymbols {
+		builder.WriteString(fmt.Sprintf("\n(Showing %d of %d declarations named %s. Use Type.Method or a file path to narrow it down)\n", maxOutlineSymbols, found, symbol))
+	}
+
+	return ToolResult{
+		Content: strings.TrimSuffix(builder.String(), "\n"),
+		IsError: false,
+	}, nil
+}
+
+// receiverType returns the base type name of a method receiver, without
+// pointer or type parameters.
+func receiverType(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// typeParams renders the type parameters of a type declaration.
+func typeParams(fset *token.FileSet, spec *ast.TypeSpec) string {
+	if spec.TypeParams == nil {
+		return ""
+	}
+	var params []string
+	for _, field := range spec.TypeParams.List {
+		var names []string
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+		params = append(params, strings.Join(names, ", ")+" "+nodeString(fset, field.Type))
+	}
+	return "[" + strings.Join(params, ", ") + "]"
+}
+
+// typeSummary describes the type of a type declaration: struct and
+// interface types by their kind, and other types in full unless long.
+func typeSummary(fset *token.FileSet, spec *ast.TypeSpec) string {
+	prefix := ""
+	if spec.Assign.IsValid() {
+		prefix = "= "
+	}
+	switch spec.Type.(type) {
+	case *ast.StructType:
+		return prefix + "struct"
+	case *ast.InterfaceType:
+		return prefix + "interface"
+	}
+	s := nodeString(fset, spec.Type)
+	if len(s) > maxOutlineTypeLength {
+		s = truncateRunesPrefix(s, maxOutlineTypeLength) + "..."
+	}
+	return prefix + s
+}
+
+// nodeString prints a node on one line.
+func nodeString(fset *token.FileSet, node interface{}) string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, node); err != nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+// docSummary returns the first sentence of a doc comment.
+func docSummary(group *ast.CommentGroup) string {
+	if group == nil {
+		return ""
+	}
+	return new(doc.Package).Synopsis(group.Text())
+}
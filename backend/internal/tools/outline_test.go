@@ -0,0 +1,165 @@
+package tools
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const outlineSource = `package shapes
+
+import "math"
+
+// Pi is used by Circle.
+const Pi = math.Pi
+
+const (
+	// Small is the smallest size.
+	Small = iota
+	Large
+)
+
+// Shape is anything with an area.
+type Shape interface {
+	Area() float64
+}
+
+// Circle is a round shape. It has a radius.
+type Circle struct {
+	R float64
+}
+
+// Area returns the area of the circle.
+func (c *Circle) Area() float64 {
+	return Pi * c.R * c.R
+}
+
+type list[T any] []T
+
+func (l list[T]) Area() float64 { return 0 }
+
+var defaultCircle, unitCircle Circle
+
+func newCircle(
+	r float64,
+) *Circle {
+	return &Circle{R: r}
+}
+`
+
+func TestOutlineTool(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"shapes/shapes.go":      outlineSource,
+		"shapes/shapes_test.go": "package shapes\n\nfunc TestArea() {}\n",
+		"shapes/broken.go":      "package shapes\n\nfunc Square() int { return 4 }\n\nfunc broken( {\n",
+		"notes.txt":             "",
+	})
+	tool := NewOutlineTool(dir)
+
+	tests := []struct {
+		name string
+		args map[string]interface{}
+		want string
+	}{
+		{
+			name: "file",
+			args: map[string]interface{}{"path": "shapes/shapes.go"},
+			want: "<outline_results>\nshapes/shapes.go\n" +
+				"  5-6 const Pi // Pi is used by Circle.\n" +
+				"  9-10 const Small // Small is the smallest size.\n" +
+				"  11-11 const Large\n" +
+				"  14-17 type Shape interface // Shape is anything with an area.\n" +
+				"  19-22 type Circle struct // Circle is a round shape.\n" +
+				"  24-27 func (c *Circle) Area() float64 // Area returns the area of the circle.\n" +
+				"  29-29 type list[T any] []T\n" +
+				"  31-31 func (l list[T]) Area() float64\n" +
+				"  33-33 var defaultCircle, unitCircle Circle\n" +
+				"  35-39 func newCircle( r float64, ) *Circle\n" +
+				"\nFound 10 declarations in 1 files\n</outline_results>",
+		},
+		{
+			name: "exported declarations of a package",
+			args: map[string]interface{}{"path": "shapes", "exportedOnly": true},
+			want: "<outline_results>\nshapes/broken.go\n" +
+				"  (syntax error: " + dirSyntaxError(t, tool) + ")\n" +
+				"  3-3 func Square() int\n" +
+				"shapes/shapes.go\n" +
+				"  5-6 const Pi // Pi is used by Circle.\n" +
+				"  9-10 const Small // Small is the smallest size.\n" +
+				"  11-11 const Large\n" +
+				"  14-17 type Shape interface // Shape is anything with an area.\n" +
+				"  19-22 type Circle struct // Circle is a round shape.\n" +
+				"  24-27 func (c *Circle) Area() float64 // Area returns the area of the circle.\n" +
+				"\nFound 7 declarations in 2 files\n</outline_results>",
+		},
+		{
+			name: "method",
+			args: map[string]interface{}{"path": "shapes", "symbol": "Circle.Area"},
+			want: "<symbol file=\"shapes/shapes.go\" lines=\"24-27\">\n" +
+				"00024| // Area returns the area of the circle.\n" +
+				"00025| func (c *Circle) Area() float64 {\n" +
+				"00026| \treturn Pi * c.R * c.R\n" +
+				"00027| }\n</symbol>",
+		},
+		{
+			name: "spec of a group",
+			args: map[string]interface{}{"path": "shapes", "symbol": "Small"},
+			want: "<symbol file=\"shapes/shapes.go\" lines=\"9-10\">\n" +
+				"00009| \t// Small is the smallest size.\n" +
+				"00010| \tSmall = iota\n</symbol>",
+		},
+		{
+			name: "test files",
+			args: map[string]interface{}{"path": "shapes", "symbol": "TestArea", "includeTests": true},
+			want: "<symbol file=\"shapes/shapes_test.go\" lines=\"3-3\">\n00003| func TestArea() {}\n</symbol>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tool.Execute(context.Background(), tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Content != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", result.Content, tt.want)
+			}
+		})
+	}
+
+	result, err := tool.Execute(context.Background(), map[string]interface{}{"path": "shapes", "symbol": "Area"})
+	if err != nil || strings.Count(result.Content, "<symbol ") != 2 {
+		t.Errorf("expected both Area methods: %v\n%s", err, result.Content)
+	}
+
+	for _, tt := range []struct {
+		args map[string]interface{}
+		want string
+	}{
+		{args: map[string]interface{}{"path": "shapes", "symbol": "circle"}, want: "Similar declarations: Circle, Circle.Area, defaultCircle, newCircle, unitCircle"},
+		{args: map[string]interface{}{"path": "shapes", "symbol": "TestArea"}, want: "symbol not found"},
+		{args: map[string]interface{}{"path": "notes.txt"}, want: "not a Go file"},
+		{args: map[string]interface{}{"path": "."}, want: "no Go files"},
+		{args: map[string]interface{}{"path": "../"}, want: "access denied"},
+	} {
+		if _, err := tool.Execute(context.Background(), tt.args); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%v: got error %v, want %q", tt.args, err, tt.want)
+		}
+	}
+}
+
+// dirSyntaxError returns the syntax error the outline of the shapes
+// package reports for broken.go.
+func dirSyntaxError(t *testing.T, tool *OutlineTool) string {
+	t.Helper()
+	result, err := tool.Execute(context.Background(), map[string]interface{}{"path": "shapes/broken.go"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, rest, _ := strings.Cut(result.Content, "(syntax error: ")
+	msg, _, _ := strings.Cut(rest, ")\n")
+	if msg == "" {
+		t.Fatalf("no syntax error reported:\n%s", result.Content)
+	}
+	return msg
+}
@@ -0,0 +1,1149 @@
+package tools
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// This file extracts the text of PDF files with a small parser of its own.
+// It reads what text extraction needs: objects, including those packed in
+// object streams, the page tree, content streams and the fonts' mappings to
+// Unicode. Objects are found by scanning the file rather than through the
+// cross-reference table, which also copes with files whose table is
+// damaged. Encrypted files aren't supported.
+
+const (
+	// maxPDFBytes is the largest PDF file read.
+	maxPDFBytes = 64 * 1024 * 1024
+	// maxPDFStreamBytes bounds a decoded stream.
+	maxPDFStreamBytes = 64 * 1024 * 1024
+	// maxPDFFormDepth bounds nested form XObjects.
+	maxPDFFormDepth = 8
+	// maxPDFPages is how many pages one read returns.
+	maxPDFPages = 20
+	// maxPDFRenderBytes bounds one read of a PDF; the rest is read with
+	// pages.
+	maxPDFRenderBytes = 128 * 1024
+)
+
+type pdfName string
+
+type pdfRef struct {
+	num, gen int
+}
+
+// pdfKeyword is a bare word: an operator in a content stream, or a word such
+// as obj or R in the file.
+type pdfKeyword string
+
+type pdfDict map[pdfName]interface{}
+
+// pdfStream is a stream object: its dictionary and its raw data.
+type pdfStream struct {
+	dict pdfDict
+	data []byte
+}
+
+// pdfLexer reads PDF objects from data.
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if isPDFSpace(c) {
+			l.pos++
+		} else if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		} else {
+			return
+		}
+	}
+}
+
+// errPDFEnd is returned when the lexer runs out of data.
+var errPDFEnd = fmt.Errorf("unexpected end of PDF data")
+
+// token reads the next token: a value, or a pdfKeyword for delimiters of
+// arrays and dictionaries and for bare words.
+func (l *pdfLexer) token() (interface{}, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, errPDFEnd
+	}
+	c := l.data[l.pos]
+	switch {
+	case c == '(':
+		return l.literalString(), nil
+	case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+		l.pos += 2
+		return pdfKeyword("<<"), nil
+	case c == '>' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '>':
+		l.pos += 2
+		return pdfKeyword(">>"), nil
+	case c == '<':
+		return l.hexString(), nil
+	case c == '[' || c == ']' || c == '{' || c == '}':
+		l.pos++
+		return pdfKeyword(c), nil
+	case c == '/':
+		l.pos++
+		return l.name(), nil
+	}
+
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	if l.pos == start {
+		// A stray delimiter such as ).
+		l.pos++
+		return pdfKeyword(l.data[start:l.pos]), nil
+	}
+	word := string(l.data[start:l.pos])
+	if n, err := strconv.ParseFloat(word, 64); err == nil && (word[0] == '-' || word[0] == '+' || word[0] == '.' || word[0] >= '0' && word[0] <= '9') {
+		return n, nil
+	}
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	return pdfKeyword(word), nil
+}
+
+func (l *pdfLexer) name() pdfName {
+	var name []byte
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		c := l.data[l.pos]
+		if c == '#' && l.pos+2 < len(l.data) {
+			if b, err := hex.DecodeString(string(l.data[l.pos+1 : l.pos+3])); err == nil {
+				name = append(name, b[0])
+				l.pos += 3
+				continue
+			}
+		}
+		name = append(name, c)
+		l.pos++
+	}
+	return pdfName(name)
+}
+
+func (l *pdfLexer) literalString() []byte {
+	l.pos++
+	var s []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return s
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return s
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				s = append(s, '\n')
+			case 'r':
+				s = append(s, '\r')
+			case 't':
+				s = append(s, '\t')
+			case 'b':
+				s = append(s, '\b')
+			case 'f':
+				s = append(s, '\f')
+			case '\r':
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					s = append(s, byte(v))
+				} else {
+					s = append(s, e)
+				}
+			}
+			continue
+		}
+		s = append(s, c)
+	}
+	return s
+}
+
+func (l *pdfLexer) hexString() []byte {
+	l.pos++
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		if c := l.data[l.pos]; !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	s, _ := hex.DecodeString(string(digits))
+	return s
+}
+
+// object reads a whole object: arrays and dictionaries are read to their
+// end, and "n g R" becomes a pdfRef.
+func (l *pdfLexer) object() (interface{}, error) {
+	tok, err := l.token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case pdfKeyword("["):
+		var array []interface{}
+		for {
+			l.skipSpace()
+			if l.pos < len(l.data) && l.data[l.pos] == ']' {
+				l.pos++
+				return array, nil
+			}
+			v, err := l.object()
+			if err != nil {
+				return array, err
+			}
+			array = append(array, v)
+		}
+	case pdfKeyword("<<"):
+		dict := pdfDict{}
+		for {
+			key, err := l.token()
+			if err != nil {
+				return dict, err
+			}
+			if key == pdfKeyword(">>") {
+				return dict, nil
+			}
+			name, ok := key.(pdfName)
+			if !ok {
+				continue
+			}
+			v, err := l.object()
+			if err != nil {
+				return dict, err
+			}
+			dict[name] = v
+		}
+	}
+
+	if n, ok := tok.(float64); ok {
+		// Look ahead for "gen R".
+		save := l.pos
+		if gen, err := l.token(); err == nil {
+			if g, ok := gen.(float64); ok {
+				if r, err := l.token(); err == nil && r == pdfKeyword("R") {
+					return pdfRef{int(n), int(g)}, nil
+				}
+			}
+		}
+		l.pos = save
+	}
+	return tok, nil
+}
+
+// pdfDocument holds the objects of a PDF file.
+type pdfDocument struct {
+	objects map[int]interface{}
+}
+
+var pdfObjectStart = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// parsePDF finds the objects of a PDF file.
+func parsePDF(data []byte) (*pdfDocument, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data[:min(len(data), 1024)], "\x00\t\n\r "), []byte("%PDF-")) {
+		return nil, fmt.Errorf("not a PDF file")
+	}
+	doc := &pdfDocument{objects: make(map[int]interface{})}
+
+	// Later definitions of an object, from incremental updates, replace
+	// earlier ones.
+	for _, loc := range pdfObjectStart.FindAllSubmatchIndex(data, -1) {
+		num, _ := strconv.Atoi(string(data[loc[2]:loc[3]]))
+		l := &pdfLexer{data: data, pos: loc[1]}
+		obj, err := l.object()
+		if err != nil && obj == nil {
+			continue
+		}
+		if dict, ok := obj.(pdfDict); ok {
+			if stream, ok := l.streamData(doc, dict); ok {
+				obj = stream
+			}
+		}
+		doc.objects[num] = obj
+	}
+	if _, ok := doc.trailerValue("Encrypt", data); ok {
+		return nil, fmt.Errorf("encrypted PDF files are not supported")
+	}
+
+	// Objects in object streams don't override objects defined directly.
+	for _, obj := range doc.objects {
+		stream, ok := obj.(*pdfStream)
+		if !ok || stream.dict["Type"] != pdfName("ObjStm") {
+			continue
+		}
+		doc.unpackObjectStream(stream)
+	}
+	return doc, nil
+}
+
+// streamData reads the data of a stream whose dictionary the lexer has just
+// read, if one follows.
+func (l *pdfLexer) streamData(doc *pdfDocument, dict pdfDict) (*pdfStream, bool) {
+	save := l.pos
+	tok, err := l.token()
+	if err != nil || tok != pdfKeyword("stream") {
+		l.pos = save
+		return nil, false
+	}
+	start := l.pos
+	if start < len(l.data) && l.data[start] == '\r' {
+		start++
+	}
+	if start < len(l.data) && l.data[start] == '\n' {
+		start++
+	}
+
+	// Trust a direct Length that ends at endstream; otherwise look for it.
+	end := -1
+	if n, ok := dict["Length"].(float64); ok {
+		e := start + int(n)
+		if e <= len(l.data) && e >= start {
+			rest := bytes.TrimLeft(l.data[e:min(len(l.data), e+32)], "\r\n \t")
+			if bytes.HasPrefix(rest, []byte("endstream")) {
+				end = e
+			}
+		}
+	}
+	if end < 0 {
+		i := bytes.Index(l.data[start:], []byte("endstream"))
+		if i < 0 {
+			return nil, false
+		}
+		end = start + i
+		for end > start && (l.data[end-1] == '\n' || l.data[end-1] == '\r') {
+			end--
+		}
+	}
+	l.pos = end
+	return &pdfStream{dict: dict, data: l.data[start:end]}, true
+}
+
+func (doc *pdfDocument) unpackObjectStream(stream *pdfStream) {
+	data, err := doc.decodeStream(stream)
+	if err != nil {
+		return
+	}
+	n, _ := doc.resolve(stream.dict["N"]).(float64)
+	first, _ := doc.resolve(stream.dict["First"]).(float64)
+	header := &pdfLexer{data: data}
+	for i := 0; i < int(n); i++ {
+		num, err1 := header.token()
+		offset, err2 := header.token()
+		if err1 != nil || err2 != nil {
+			return
+		}
+		objNum, ok1 := num.(float64)
+		objOffset, ok2 := offset.(float64)
+		if !ok1 || !ok2 {
+			return
+		}
+		if _, defined := doc.objects[int(objNum)]; defined {
+			continue
+		}
+		pos := int(first) + int(objOffset)
+		if pos < 0 || pos >= len(data) {
+			continue
+		}
+		l := &pdfLexer{data: data, pos: pos}
+		if obj, err := l.object(); err == nil {
+			doc.objects[int(objNum)] = obj
+		}
+	}
+}
+
+// trailerValue finds a key of the trailer, which is either a trailer
+// dictionary or the dictionary of a cross-reference stream.
+func (doc *pdfDocument) trailerValue(key pdfName, data []byte) (interface{}, bool) {
+	if i := bytes.LastIndex(data, []byte("trailer")); i >= 0 {
+		l := &pdfLexer{data: data, pos: i + len("trailer")}
+		if dict, ok := mustObject(l).(pdfDict); ok {
+			if v, ok := dict[key]; ok {
+				return v, true
+			}
+		}
+	}
+	for _, obj := range doc.objects {
+		if stream, ok := obj.(*pdfStream); ok && stream.dict["Type"] == pdfName("XRef") {
+			if v, ok := stream.dict[key]; ok {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func mustObject(l *pdfLexer) interface{} {
+	obj, _ := l.object()
+	return obj
+}
+
+// resolve follows references to the object they point to.
+func (doc *pdfDocument) resolve(obj interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		ref, ok := obj.(pdfRef)
+		if !ok {
+			return obj
+		}
+		obj = doc.objects[ref.num]
+	}
+	return nil
+}
+
+func (doc *pdfDocument) dict(obj interface{}) pdfDict {
+	switch v := doc.resolve(obj).(type) {
+	case pdfDict:
+		return v
+	case *pdfStream:
+		return v.dict
+	}
+	return nil
+}
+
+// decodeStream applies the filters of a stream to its data.
+func (doc *pdfDocument) decodeStream(stream *pdfStream) ([]byte, error) {
+	data := stream.data
+	var filters []interface{}
+	switch f := doc.resolve(stream.dict["Filter"]).(type) {
+	case pdfName:
+		filters = []interface{}{f}
+	case []interface{}:
+		filters = f
+	}
+	for _, f := range filters {
+		var err error
+		switch doc.resolve(f) {
+		case pdfName("FlateDecode"), pdfName("Fl"):
+			data, err = inflate(data)
+		case pdfName("ASCIIHexDecode"), pdfName("AHx"):
+			l := &pdfLexer{data: append(append([]byte{'<'}, data...), '>')}
+			data = l.hexString()
+		case pdfName("ASCII85Decode"), pdfName("A85"):
+			data, err = decodeASCII85(data)
+		default:
+			return nil, fmt.Errorf("unsupported PDF filter %v", f)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if params := doc.dict(stream.dict["DecodeParms"]); params != nil {
+		if p, _ := params["Predictor"].(float64); p >= 10 {
+			columns, _ := params["Columns"].(float64)
+			data = unpredictPNG(data, int(columns))
+		}
+	}
+	return data, nil
+}
+
+func inflate(data []byte) ([]byte, error) {
+	var r io.Reader
+	if zr, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+		r = zr
+	} else {
+		// Some files have raw deflate data without the zlib header.
+		r = flate.NewReader(bytes.NewReader(data))
+	}
+	out, err := io.ReadAll(io.LimitReader(r, maxPDFStreamBytes))
+	if len(out) > 0 {
+		// Truncated streams are common; keep what was decoded.
+		return out, nil
+	}
+	return out, err
+}
+
+func decodeASCII85(data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~"))
+	if i := bytes.Index(data, []byte("~>")); i >= 0 {
+		data = data[:i]
+	}
+	out := make([]byte, 4*len(data)/5+4)
+	n, _, err := ascii85.Decode(out, data, true)
+	return out[:n], err
+}
+
+// unpredictPNG undoes the PNG predictors of a stream with one byte per
+// component, which is how cross-reference and object streams use them.
+func unpredictPNG(data []byte, columns int) []byte {
+	if columns < 1 {
+		columns = 1
+	}
+	row := columns + 1
+	var out []byte
+	prev := make([]byte, columns)
+	for i := 0; i+row <= len(data); i += row {
+		filter, line := data[i], append([]byte(nil), data[i+1:i+row]...)
+		for j := range line {
+			var left, up, upLeft byte
+			if j > 0 {
+				left, upLeft = line[j-1], prev[j-1]
+			}
+			up = prev[j]
+			switch filter {
+			case 1:
+				line[j] += left
+			case 2:
+				line[j] += up
+			case 3:
+				line[j] += byte((int(left) + int(up)) / 2)
+			case 4:
+				line[j] += paeth(left, up, upLeft)
+			}
+		}
+		out = append(out, line...)
+		prev = line
+	}
+	return out
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// pdfPage is a page with the resources it inherits.
+type pdfPage struct {
+	dict      pdfDict
+	resources pdfDict
+}
+
+// pages returns the pages of the document in order.
+func (doc *pdfDocument) pages(data []byte) []pdfPage {
+	var pages []pdfPage
+	// Page tree nodes are referenced objects; a node seen twice is a cycle.
+	seen := make(map[pdfRef]bool)
+	var walk func(node interface{}, resources pdfDict, depth int)
+	walk = func(node interface{}, resources pdfDict, depth int) {
+		if ref, ok := node.(pdfRef); ok {
+			if seen[ref] {
+				return
+			}
+			seen[ref] = true
+		}
+		dict := doc.dict(node)
+		if dict == nil || depth > 64 {
+			return
+		}
+		if r := doc.dict(dict["Resources"]); r != nil {
+			resources = r
+		}
+		if kids, ok := doc.resolve(dict["Kids"]).([]interface{}); ok {
+			for _, kid := range kids {
+				walk(kid, resources, depth+1)
+			}
+			return
+		}
+		if dict["Type"] == pdfName("Page") || dict["Contents"] != nil {
+			pages = append(pages, pdfPage{dict: dict, resources: resources})
+		}
+	}
+
+	if root, ok := doc.trailerValue("Root", data); ok {
+		if catalog := doc.dict(root); catalog != nil {
+			walk(catalog["Pages"], nil, 0)
+		}
+	}
+	if len(pages) > 0 {
+		return pages
+	}
+
+	// Without a usable page tree, take the page objects in object order.
+	var nums []int
+	for num, obj := range doc.objects {
+		if d, ok := obj.(pdfDict); ok && d["Type"] == pdfName("Page") {
+			nums = append(nums, num)
+		}
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		d := doc.objects[num].(pdfDict)
+		pages = append(pages, pdfPage{dict: d, resources: doc.dict(d["Resources"])})
+	}
+	return pages
+}
+
+// contents returns the decoded content stream of a page.
+func (doc *pdfDocument) contents(page pdfDict) []byte {
+	var parts []interface{}
+	switch c := doc.resolve(page["Contents"]).(type) {
+	case []interface{}:
+		parts = c
+	default:
+		parts = []interface{}{page["Contents"]}
+	}
+	var content []byte
+	for _, part := range parts {
+		stream, ok := doc.resolve(part).(*pdfStream)
+		if !ok {
+			continue
+		}
+		data, err := doc.decodeStream(stream)
+		if err != nil {
+			continue
+		}
+		content = append(content, data...)
+		content = append(content, '\n')
+	}
+	return content
+}
+
+// pdfFont decodes the strings shown with a font.
+type pdfFont struct {
+	// codeBytes is the length of character codes: 2 for composite fonts,
+	// 1 otherwise.
+	codeBytes int
+	toUnicode map[uint32]string
+	// encoding maps single byte codes when there's no ToUnicode map.
+	encoding map[byte]rune
+}
+
+func (doc *pdfDocument) font(obj interface{}) *pdfFont {
+	dict := doc.dict(obj)
+	font := &pdfFont{codeBytes: 1}
+	if dict == nil {
+		return font
+	}
+	if dict["Subtype"] == pdfName("Type0") {
+		font.codeBytes = 2
+	}
+	if stream, ok := doc.resolve(dict["ToUnicode"]).(*pdfStream); ok {
+		if data, err := doc.decodeStream(stream); err == nil {
+			font.toUnicode = parseToUnicode(data)
+		}
+	}
+	if font.codeBytes == 1 {
+		font.encoding = doc.simpleEncoding(dict["Encoding"])
+	}
+	return font
+}
+
+// simpleEncoding builds the encoding of a simple font from its base
+// encoding and differences. Standard and WinAnsi encodings are close enough
+// to Windows-1252 for text extraction.
+func (doc *pdfDocument) simpleEncoding(obj interface{}) map[byte]rune {
+	encoding := make(map[byte]rune)
+	for i := 0; i < 256; i++ {
+		encoding[byte(i)] = rune(i)
+	}
+	for code, r := range cp1252 {
+		encoding[code] = r
+	}
+	if base, ok := doc.resolve(obj).(pdfName); ok && base == "MacRomanEncoding" {
+		for code, r := range macRoman {
+			encoding[code] = r
+		}
+	}
+	dict := doc.dict(obj)
+	if dict == nil {
+		return encoding
+	}
+	if base, ok := dict["BaseEncoding"].(pdfName); ok && base == "MacRomanEncoding" {
+		for code, r := range macRoman {
+			encoding[code] = r
+		}
+	}
+	if diffs, ok := doc.resolve(dict["Differences"]).([]interface{}); ok {
+		code := 0
+		for _, d := range diffs {
+			switch v := doc.resolve(d).(type) {
+			case float64:
+				code = int(v)
+			case pdfName:
+				if r, ok := glyphRune(string(v)); ok && code < 256 {
+					encoding[byte(code)] = r
+				}
+				code++
+			}
+		}
+	}
+	return encoding
+}
+
+// decode converts a shown string to text.
+func (f *pdfFont) decode(s []byte) string {
+	var out strings.Builder
+	if f.codeBytes == 2 {
+		for i := 0; i+1 < len(s); i += 2 {
+			code := uint32(s[i])<<8 | uint32(s[i+1])
+			if text, ok := f.toUnicode[code]; ok {
+				out.WriteString(text)
+			}
+		}
+		return out.String()
+	}
+	for _, b := range s {
+		if text, ok := f.toUnicode[uint32(b)]; ok {
+			out.WriteString(text)
+		} else if r, ok := f.encoding[b]; ok && r != 0 {
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+// parseToUnicode reads the bfchar and bfrange mappings of a ToUnicode CMap.
+func parseToUnicode(data []byte) map[uint32]string {
+	mapping := make(map[uint32]string)
+	l := &pdfLexer{data: data}
+	var operands []interface{}
+	for {
+		tok, err := l.token()
+		if err != nil {
+			return mapping
+		}
+		switch tok {
+		case pdfKeyword("beginbfchar"), pdfKeyword("beginbfrange"):
+			operands = nil
+		case pdfKeyword("endbfchar"):
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].([]byte)
+				dst, ok2 := operands[i+1].([]byte)
+				if ok1 && ok2 {
+					mapping[codeValue(src)] = utf16BE(dst)
+				}
+			}
+			operands = nil
+		case pdfKeyword("endbfrange"):
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].([]byte)
+				hi, ok2 := operands[i+1].([]byte)
+				if !ok1 || !ok2 {
+					continue
+				}
+				start, end := codeValue(lo), codeValue(hi)
+				if end < start || end-start > 0xFFFF {
+					continue
+				}
+				switch dst := operands[i+2].(type) {
+				case []byte:
+					base := []rune(utf16BE(dst))
+					if len(base) == 0 {
+						continue
+					}
+					for c := start; c <= end; c++ {
+						r := append([]rune(nil), base...)
+						r[len(r)-1] += rune(c - start)
+						mapping[c] = string(r)
+					}
+				case []interface{}:
+					for j, d := range dst {
+						if b, ok := d.([]byte); ok && start+uint32(j) <= end {
+							mapping[start+uint32(j)] = utf16BE(b)
+						}
+					}
+				}
+			}
+			operands = nil
+		case pdfKeyword("["):
+			l.pos--
+			array, _ := l.object()
+			operands = append(operands, array)
+		default:
+			operands = append(operands, tok)
+		}
+	}
+}
+
+func codeValue(b []byte) uint32 {
+	var v uint32
+	for _, c := range b {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}
+
+func utf16BE(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	if len(b)%2 == 1 {
+		units = append(units, uint16(b[len(b)-1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// pdfTextWriter puts the text a content stream shows into lines.
+type pdfTextWriter struct {
+	out strings.Builder
+	// lineEmpty is set at the start of a line.
+	lineEmpty bool
+}
+
+func (w *pdfTextWriter) text(s string) {
+	if s == "" {
+		return
+	}
+	w.out.WriteString(s)
+	w.lineEmpty = false
+}
+
+func (w *pdfTextWriter) space() {
+	if !w.lineEmpty && !strings.HasSuffix(w.out.String(), " ") {
+		w.out.WriteByte(' ')
+	}
+}
+
+func (w *pdfTextWriter) newline() {
+	if !w.lineEmpty {
+		w.out.WriteByte('\n')
+		w.lineEmpty = true
+	}
+}
+
+// extractText runs a content stream, writing the text it shows.
+func (doc *pdfDocument) extractText(content []byte, resources pdfDict, w *pdfTextWriter, depth int) {
+	fonts := doc.dict(resources["Font"])
+	xobjects := doc.dict(resources["XObject"])
+	cache := make(map[pdfName]*pdfFont)
+	font := &pdfFont{codeBytes: 1, encoding: doc.simpleEncoding(nil)}
+	var lineY float64
+
+	l := &pdfLexer{data: content}
+	var operands []interface{}
+	for {
+		tok, err := l.token()
+		if err != nil {
+			return
+		}
+		op, isOp := tok.(pdfKeyword)
+		if !isOp || op == "[" || op == "<<" {
+			if isOp {
+				l.pos -= len(op)
+				tok, _ = l.object()
+			}
+			operands = append(operands, tok)
+			continue
+		}
+
+		switch op {
+		case "BI":
+			// Skip inline image data.
+			if i := bytes.Index(content[l.pos:], []byte("EI")); i >= 0 {
+				l.pos += i + 2
+			}
+		case "BT":
+			lineY = 0
+		case "ET":
+			w.newline()
+		case "Tf":
+			if len(operands) >= 2 {
+				if name, ok := operands[0].(pdfName); ok {
+					if cache[name] == nil {
+						cache[name] = doc.font(fonts[name])
+					}
+					font = cache[name]
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				tx, _ := operands[0].(float64)
+				ty, _ := operands[1].(float64)
+				if ty != 0 {
+					w.newline()
+				} else if tx != 0 {
+					w.space()
+				}
+			}
+		case "Tm":
+			if len(operands) >= 6 {
+				y, _ := operands[5].(float64)
+				if y != lineY {
+					w.newline()
+				} else {
+					w.space()
+				}
+				lineY = y
+			}
+		case "T*":
+			w.newline()
+		case "Tj":
+			if len(operands) >= 1 {
+				if s, ok := operands[0].([]byte); ok {
+					w.text(font.decode(s))
+				}
+			}
+		case "'", "\"":
+			w.newline()
+			if len(operands) >= 1 {
+				if s, ok := operands[len(operands)-1].([]byte); ok {
+					w.text(font.decode(s))
+				}
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				items, _ := operands[0].([]interface{})
+				for _, item := range items {
+					switch v := item.(type) {
+					case []byte:
+						w.text(font.decode(v))
+					case float64:
+						// A large negative adjustment is a gap between
+						// words.
+						if v < -200 {
+							w.space()
+						}
+					}
+				}
+			}
+		case "Do":
+			if len(operands) >= 1 && depth < maxPDFFormDepth {
+				if name, ok := operands[0].(pdfName); ok {
+					if form, ok := doc.resolve(xobjects[name]).(*pdfStream); ok && form.dict["Subtype"] == pdfName("Form") {
+						if data, err := doc.decodeStream(form); err == nil {
+							formResources := doc.dict(form.dict["Resources"])
+							if formResources == nil {
+								formResources = resources
+							}
+							doc.extractText(data, formResources, w, depth+1)
+						}
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// openPDF parses a PDF file and finds its pages, without decoding what's on
+// them.
+func openPDF(data []byte) (*pdfDocument, []pdfPage, error) {
+	doc, err := parsePDF(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	pages := doc.pages(data)
+	if len(pages) == 0 {
+		return nil, nil, fmt.Errorf("no pages found in PDF file")
+	}
+	return doc, pages, nil
+}
+
+// pageText returns the text of a page.
+func (doc *pdfDocument) pageText(page pdfPage) string {
+	w := &pdfTextWriter{lineEmpty: true}
+	doc.extractText(doc.contents(page.dict), page.resources, w, 0)
+	var lines []string
+	for _, line := range strings.Split(w.out.String(), "\n") {
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// glyphRune maps a glyph name from a font's encoding differences to the
+// character it draws: uniXXXX names, single letters and digits, and common
+// punctuation.
+func glyphRune(name string) (rune, bool) {
+	if strings.HasPrefix(name, "uni") && len(name) == 7 {
+		if v, err := strconv.ParseUint(name[3:], 16, 32); err == nil {
+			return rune(v), true
+		}
+	}
+	if len(name) == 1 {
+		return rune(name[0]), true
+	}
+	r, ok := glyphNames[name]
+	return r, ok
+}
+
+var glyphNames = map[string]rune{
+	"space": ' ', "exclam": '!', "quotedbl": '"', "numbersign": '#', "dollar": '$',
+	"percent": '%', "ampersand": '&', "quotesingle": '\'', "quoteright": '’',
+	"quoteleft": '‘', "parenleft": '(', "parenright": ')', "asterisk": '*',
+	"plus": '+', "comma": ',', "hyphen": '-', "period": '.', "slash": '/',
+	"zero": '0', "one": '1', "two": '2', "three": '3', "four": '4', "five": '5',
+	"six": '6', "seven": '7', "eight": '8', "nine": '9', "colon": ':',
+	"semicolon": ';', "less": '<', "equal": '=', "greater": '>', "question": '?',
+	"at": '@', "bracketleft": '[', "backslash": '\\', "bracketright": ']',
+	"asciicircum": '^', "underscore": '_', "grave": '`', "braceleft": '{',
+	"bar": '|', "braceright": '}', "asciitilde": '~', "bullet": '•',
+	"endash": '–', "emdash": '—', "quotedblleft": '“',
+	"quotedblright": '”', "ellipsis": '…', "fi": 'ﬁ', "fl": 'ﬂ',
+	"ff": 'ﬀ', "ffi": 'ﬃ', "ffl": 'ﬄ', "degree": '°',
+	"copyright": '©', "registered": '®', "trademark": '™',
+	"eacute": 'é', "egrave": 'è', "agrave": 'à', "ccedilla": 'ç', "udieresis": 'ü',
+	"odieresis": 'ö', "adieresis": 'ä', "germandbls": 'ß', "minus": '−',
+}
+
+// cp1252 holds the characters of Windows-1252 that differ from Latin-1.
+var cp1252 = map[byte]rune{
+	0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„', 0x85: '…', 0x86: '†', 0x87: '‡',
+	0x88: 'ˆ', 0x89: '‰', 0x8A: 'Š', 0x8B: '‹', 0x8C: 'Œ', 0x8E: 'Ž', 0x91: '‘',
+	0x92: '’', 0x93: '“', 0x94: '”', 0x95: '•', 0x96: '–', 0x97: '—', 0x98: '˜',
+	0x99: '™', 0x9A: 'š', 0x9B: '›', 0x9C: 'œ', 0x9E: 'ž', 0x9F: 'Ÿ',
+}
+
+// macRoman holds the common characters of Mac OS Roman above ASCII.
+var macRoman = map[byte]rune{
+	0x80: 'Ä', 0x81: 'Å', 0x82: 'Ç', 0x83: 'É', 0x84: 'Ñ', 0x85: 'Ö', 0x86: 'Ü',
+	0x87: 'á', 0x88: 'à', 0x89: 'â', 0x8A: 'ä', 0x8B: 'ã', 0x8C: 'å', 0x8D: 'ç',
+	0x8E: 'é', 0x8F: 'è', 0x90: 'ê', 0x91: 'ë', 0x92: 'í', 0x93: 'ì', 0x94: 'î',
+	0x95: 'ï', 0x96: 'ñ', 0x97: 'ó', 0x98: 'ò', 0x99: 'ô', 0x9A: 'ö', 0x9B: 'õ',
+	0x9C: 'ú', 0x9D: 'ù', 0x9E: 'û', 0x9F: 'ü', 0xA5: '•', 0xD0: '–', 0xD1: '—',
+	0xD2: '“', 0xD3: '”', 0xD4: '‘', 0xD5: '’', 0xC9: '…',
+}
+
+// parsePageRange parses a page range such as "3", "2-5" or "4-" of a
+// document with total pages, returning its 1-based first and last pages.
+// Without a range it returns the first pages, up to maxPDFPages.
+func parsePageRange(s string, total int) (int, int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 1, min(total, maxPDFPages), nil
+	}
+	from, to, isRange := strings.Cut(s, "-")
+	first, err := strconv.Atoi(strings.TrimSpace(from))
+	if err != nil || first < 1 {
+		return 0, 0, fmt.Errorf("invalid page range %q: use a page such as \"3\" or a range such as \"2-5\"", s)
+	}
+	last := first
+	if isRange {
+		last = first + maxPDFPages - 1
+		if to = strings.TrimSpace(to); to != "" {
+			if last, err = strconv.Atoi(to); err != nil || last < first {
+				return 0, 0, fmt.Errorf("invalid page range %q: use a page such as \"3\" or a range such as \"2-5\"", s)
+			}
+		}
+	}
+	if first > total {
+		return 0, 0, fmt.Errorf("page %d is past the end of the document, which has %d pages", first, total)
+	}
+	if last > total {
+		last = total
+	}
+	if last-first >= maxPDFPages {
+		last = first + maxPDFPages - 1
+	}
+	return first, last, nil
+}
+
+// readPDF returns the text of a range of pages of a PDF file. The parser
+// reads untrusted files, so a bug it hits on a malformed one fails the read
+// rather than the server.
+func readPDF(path string, size int64, pages string) (result ToolResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PDF parser panicked on %s: %v\n%s", path, r, debug.Stack())
+			result, err = ToolResult{}, fmt.Errorf("cannot read PDF file %s: the file is malformed", path)
+		}
+	}()
+	if size > maxPDFBytes {
+		return ToolResult{}, fmt.Errorf("PDF file too large to read: %s is %s, the limit is %s", path, formatSize(size), formatSize(maxPDFBytes))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ToolResult{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	doc, docPages, err := openPDF(data)
+	if err != nil {
+		return ToolResult{}, fmt.Errorf("cannot read PDF file %s: %w", path, err)
+	}
+	first, last, err := parsePageRange(pages, len(docPages))
+	if err != nil {
+		return ToolResult{}, err
+	}
+
+	// Only the pages in the range are decoded, and once the output is
+	// large the rest are left for another read.
+	var builder strings.Builder
+	builder.WriteString("<file>\n")
+	for page := first; page <= last; page++ {
+		if page > first && builder.Len() > maxPDFRenderBytes {
+			last = page - 1
+			break
+		}
+		builder.WriteString(fmt.Sprintf("--- Page %d ---\n", page))
+		text := doc.pageText(docPages[page-1])
+		switch {
+		case text == "":
+			builder.WriteString("(No text on this page; it may be a scanned image)\n")
+		case len(text) > maxPDFRenderBytes:
+			builder.WriteString(truncateRunesPrefix(text, maxPDFRenderBytes))
+			builder.WriteString(fmt.Sprintf("\n(Page text cut at %s)\n", formatSize(maxPDFRenderBytes)))
+		default:
+			builder.WriteString(text)
+			builder.WriteString("\n")
+		}
+	}
+	if last < len(docPages) {
+		builder.WriteString(fmt.Sprintf("\n(Pages %d to %d of %d. Use 'pages' parameter to read beyond page %d)\n", first, last, len(docPages), last))
+	} else {
+		builder.WriteString(fmt.Sprintf("\n(End of document - total %d pages)\n", len(docPages)))
+	}
+	builder.WriteString("</file>")
+
+	return ToolResult{
+		Content: builder.String(),
+		IsError: false,
+	}, nil
+}
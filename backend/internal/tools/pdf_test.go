@@ -0,0 +1,221 @@
+package tools
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// buildPDF assembles a PDF file from the bodies of objects 1 to n, with a
+// cross-reference table and a trailer whose root is object 1. Empty bodies
+// are objects defined elsewhere, such as in object streams.
+func buildPDF(objects []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, body := range objects {
+		if body == "" {
+			continue
+		}
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, body)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+// pdfStreamObject returns a stream object, compressed if flate is set.
+func pdfStreamObject(dict, data string, flate bool) string {
+	if flate {
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write([]byte(data))
+		w.Close()
+		data = buf.String()
+		dict += " /Filter /FlateDecode"
+	}
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+func testPDF() []byte {
+	cmap := "/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"1 begincodespacerange <0000> <FFFF> endcodespacerange\n" +
+		"1 beginbfchar\n<0001> <00DC>\nendbfchar\n" +
+		"1 beginbfrange\n<0002> <0004> <0041>\nendbfrange\n" +
+		"endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend"
+	// Object 9, the composite font, is packed in an object stream.
+	objStm := "9 0 << /Type /Font /Subtype /Type0 /BaseFont /Test /Encoding /Identity-H /ToUnicode 8 0 R >>"
+
+	return buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		// The page tree lists the pages out of object order.
+		"<< /Type /Pages /Kids [4 0 R 3 0 R 11 0 R] /Count 3 /Resources << /Font << /F1 5 0 R /F2 9 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 7 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents [6 0 R 12 0 R] >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding << /Differences [39 /quoteright] >> >>",
+		pdfStreamObject("", "BT /F1 12 Tf 72 720 Td (Hello, PDF \\(v1\\)) Tj 0 -14 Td [(Second)-300(line)] TJ", false),
+		pdfStreamObject("", "BT /F2 10 Tf 1 0 0 1 72 700 Tm <0001000200030004> Tj ET", true),
+		pdfStreamObject("", cmap, true),
+		"",
+		pdfStreamObject("/Type /ObjStm /N 1 /First 4", objStm, true),
+		"<< /Type /Page /Parent 2 0 R >>",
+		pdfStreamObject("", "T* (It's <done>) Tj ET", false),
+	})
+}
+
+func TestPDFPageText(t *testing.T) {
+	doc, pages, err := openPDF(testPDF())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var texts []string
+	for _, page := range pages {
+		texts = append(texts, doc.pageText(page))
+	}
+	want := []string{
+		"Hello, PDF (v1)\nSecond line\nIt’s <done>",
+		"ÜABC",
+		"",
+	}
+	if len(texts) != len(want) {
+		t.Fatalf("got %d pages, want %d: %q", len(texts), len(want), texts)
+	}
+	for i := range want {
+		if texts[i] != want[i] {
+			t.Errorf("page %d: got %q, want %q", i+1, texts[i], want[i])
+		}
+	}
+
+	if _, _, err := openPDF([]byte("not a pdf")); err == nil {
+		t.Error("expected an error for a file that isn't a PDF")
+	}
+}
+
+func TestParsePageRange(t *testing.T) {
+	tests := []struct {
+		pages       string
+		first, last int
+		wantErr     bool
+	}{
+		{pages: "", first: 1, last: 20},
+		{pages: "3", first: 3, last: 3},
+		{pages: "2-5", first: 2, last: 5},
+		{pages: " 4 - 6 ", first: 4, last: 6},
+		{pages: "30-", first: 30, last: 30},
+		{pages: "10-100", first: 10, last: 29},
+		{pages: "25-40", first: 25, last: 30},
+		{pages: "31", wantErr: true},
+		{pages: "0", wantErr: true},
+		{pages: "5-2", wantErr: true},
+		{pages: "a", wantErr: true},
+	}
+	for _, tt := range tests {
+		first, last, err := parsePageRange(tt.pages, 30)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.pages)
+			}
+			continue
+		}
+		if err != nil || first != tt.first || last != tt.last {
+			t.Errorf("%q: got %d-%d (%v), want %d-%d", tt.pages, first, last, err, tt.first, tt.last)
+		}
+	}
+}
+
+func TestReadFileTool_PDF(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "spec.pdf"), testPDF(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tool := NewReadFileTool(dir)
+
+	result, err := tool.Execute(context.Background(), map[string]interface{}{"filePath": "spec.pdf", "pages": "2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "<file>\n--- Page 2 ---\nÜABC\n\n(Pages 2 to 2 of 3. Use 'pages' parameter to read beyond page 2)\n</file>"
+	if result.Content != want {
+		t.Errorf("got\n%s\nwant\n%s", result.Content, want)
+	}
+
+	result, err = tool.Execute(context.Background(), map[string]interface{}{"filePath": "spec.pdf"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, part := range []string{"--- Page 1 ---\nHello, PDF (v1)\n", "--- Page 3 ---\n(No text on this page", "(End of document - total 3 pages)"} {
+		if !strings.Contains(result.Content, part) {
+			t.Errorf("missing %q in\n%s", part, result.Content)
+		}
+	}
+
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"filePath": "spec.pdf", "pages": "4"}); err == nil {
+		t.Error("expected an error for a page past the end")
+	}
+}
+
+func TestReadFileTool_PDFOutputBudget(t *testing.T) {
+	page := strings.Repeat("word ", 12*1024)
+	huge := strings.Repeat("word ", 40*1024)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [4 0 R 5 0 R 6 0 R 7 0 R] /Count 4 /Resources << /Font << /F1 3 0 R >> >> >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}
+	for i := 0; i < 4; i++ {
+		objects = append(objects, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /Contents %d 0 R >>", 8+i))
+	}
+	for _, text := range []string{page, page, page, huge} {
+		objects = append(objects, pdfStreamObject("", "BT /F1 12 Tf ("+text+") Tj ET", true))
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "long.pdf"), buildPDF(objects), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tool := NewReadFileTool(dir)
+
+	result, err := tool.Execute(context.Background(), map[string]interface{}{"filePath": "long.pdf"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(result.Content, "--- Page 3 ---") || strings.Contains(result.Content, "--- Page 4 ---") {
+		t.Errorf("read didn't stop after page 3:\n%.200s", result.Content)
+	}
+	if !strings.Contains(result.Content, "(Pages 1 to 3 of 4. Use 'pages' parameter to read beyond page 3)") {
+		t.Errorf("missing hint to read on in\n%s", result.Content[len(result.Content)-200:])
+	}
+
+	result, err = tool.Execute(context.Background(), map[string]interface{}{"filePath": "long.pdf", "pages": "4"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Content) > maxPDFRenderBytes+1024 || !strings.Contains(result.Content, "(Page text cut at 128.0 KB)") {
+		t.Errorf("long page wasn't cut: %d bytes\n%s", len(result.Content), result.Content[len(result.Content)-200:])
+	}
+}
+
+// FuzzPDF checks that no file, however malformed, makes the parser panic.
+func FuzzPDF(f *testing.F) {
+	f.Add(testPDF())
+	f.Add([]byte("%PDF-1.7\n1 0 obj\n<< /Type /Page /Contents 2 0 R >>\nendobj\n2 0 obj\n<< /Length 99 >>\nstream\nBT (x) Tj ET\nendstream\nendobj\n"))
+	f.Add([]byte("%PDF-1.4\ntrailer\n<< /Root 1 0 R >>\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		doc, pages, err := openPDF(data)
+		if err != nil {
+			return
+		}
+		for _, page := range pages {
+			doc.pageText(page)
+		}
+	})
+}
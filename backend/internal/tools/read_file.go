@@ -1,20 +1,33 @@
 package tools
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/jack/klaudkod/backend/internal/llm"
 )
 
 const (
 	defaultReadLimit = 2000
 	maxLineLength    = 2000
+	// maxReadFileSize is the largest file reads scan forward through. The
+	// start of a file, its tail and byte ranges are read at any size, but
+	// reading lines far past what the line index knows scans up to them.
+	maxReadFileSize  = 256 * 1024 * 1024
+	defaultByteLimit = 32 * 1024
+	maxByteLimit     = 256 * 1024
 )
 
 type ReadFileTool struct {
-	workingDir string
+	workingDir  string
+	allowedDirs []string
+	indexes     lineIndexCache
 }
 
 func NewReadFileTool(workingDir string) *ReadFileTool {
@@ -23,12 +36,18 @@ func NewReadFileTool(workingDir string) *ReadFileTool {
 	}
 }
 
+// AllowDir lets the tool read files under dir in addition to the working
+// directory. It is used for scratch files written by other tools.
+func (t *ReadFileTool) AllowDir(dir string) {
+	t.allowedDirs = append(t.allowedDirs, filepath.Clean(dir))
+}
+
 func (t *ReadFileTool) Name() string {
 	return "read"
 }
 
 func (t *ReadFileTool) Description() string {
-	return "Read the contents of a file. Supports pagination with offset and limit parameters. Returns file content with line numbers."
+	return "Read the contents of a file. Supports pagination with offset and limit parameters, and tail to read the last lines of a file such as a log. Returns file content with line numbers. Lines longer than 2000 characters are cut; use byteOffset and byteLimit to read any part of a file as raw text instead. PNG, JPEG, GIF and WebP images are returned as images for you to look at. PDFs are returned as the text of their pages, 20 pages at a time; use pages to pick which. Jupyter notebooks are returned as their cells with ids, types, sources and outputs; offset and limit count cells, and the notebook_edit tool edits them."
 }
 
 func (t *ReadFileTool) Parameters() map[string]interface{} {
@@ -47,6 +66,22 @@ func (t *ReadFileTool) Parameters() map[string]interface{} {
 				"type":        "integer",
 				"description": "The number of lines to read (defaults to 2000)",
 			},
+			"tail": map[string]interface{}{
+				"type":        "integer",
+				"description": "Read this many lines from the end of the file instead of from offset",
+			},
+			"byteOffset": map[string]interface{}{
+				"type":        "integer",
+				"description": "Read raw text starting at this byte (0-based) instead of lines",
+			},
+			"byteLimit": map[string]interface{}{
+				"type":        "integer",
+				"description": fmt.Sprintf("The number of bytes to read from byteOffset (defaults to %d, at most %d)", defaultByteLimit, maxByteLimit),
+			},
+			"pages": map[string]interface{}{
+				"type":        "string",
+				"description": fmt.Sprintf("For PDF files, the page or pages to read, such as \"3\" or \"2-5\" (1-based; at most %d pages, defaults to the first %d)", maxPDFPages, maxPDFPages),
+			},
 		},
 		"required": []string{"filePath"},
 	}
@@ -66,8 +101,8 @@ func (t *ReadFileTool) Execute(ctx context.Context, args map[string]interface{})
 	// Clean the path
 	filePath = filepath.Clean(filePath)
 
-	// Validate path is within workingDir
-	if !strings.HasPrefix(filePath, t.workingDir+string(filepath.Separator)) && filePath != t.workingDir {
+	// Validate path is within workingDir or an allowed directory
+	if !t.isAllowedPath(filePath) {
 		return ToolResult{}, fmt.Errorf("access denied: path is outside working directory")
 	}
 
@@ -113,33 +148,56 @@ func (t *ReadFileTool) Execute(ctx context.Context, args map[string]interface{})
 		return ToolResult{}, fmt.Errorf("access denied: cannot read .env files")
 	}
 
-	// Check for binary files by extension
+	// Images are returned as images rather than text
 	ext := strings.ToLower(filepath.Ext(filePath))
-	binaryExts := map[string]bool{
-		".zip": true, ".tar": true, ".gz": true, ".exe": true, ".dll": true,
-		".so": true, ".class": true, ".jar": true, ".war": true, ".7z": true,
-		".doc": true, ".docx": true, ".xls": true, ".xlsx": true, ".ppt": true,
-		".pptx": true, ".odt": true, ".ods": true, ".odp": true, ".bin": true,
-		".dat": true, ".obj": true, ".o": true, ".a": true, ".lib": true,
-		".wasm": true, ".pyc": true, ".pyo": true,
-	}
-	if binaryExts[ext] {
-		return ToolResult{}, fmt.Errorf("cannot read binary file: %s", filePath)
+	if mediaType, ok := llm.ImageTypeForExtension(ext); ok {
+		return readImage(filePath, mediaType, info.Size())
 	}
 
-	// Read file content
-	content, err := os.ReadFile(filePath)
+	// PDFs and notebooks are rendered as text; byte ranges read notebooks'
+	// JSON as is
+	pages, pagesSet := args["pages"].(string)
+	if ext == ".pdf" {
+		return readPDF(filePath, info.Size(), pages)
+	}
+	if pagesSet {
+		return ToolResult{}, fmt.Errorf("pages only applies to PDF files")
+	}
+	_, byteOffsetSet := args["byteOffset"].(float64)
+	_, byteLimitSet := args["byteLimit"].(float64)
+	if ext == ".ipynb" && !byteOffsetSet && !byteLimitSet {
+		if _, ok := args["tail"]; ok {
+			return ToolResult{}, fmt.Errorf("tail does not apply to notebooks; use offset and limit, which count cells")
+		}
+		return readNotebook(filePath, info.Size(), args)
+	}
+
+	f, err := os.Open(filePath)
 	if err != nil {
 		return ToolResult{}, fmt.Errorf("failed to read file: %w", err)
 	}
+	defer f.Close()
 
-	// Check for null bytes (binary file indicator)
-	if strings.Contains(string(content), "\x00") {
+	// Tell text from binary by content, and find the text's encoding
+	head := make([]byte, textSniffSize)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return ToolResult{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	enc, ok := sniffText(head[:n])
+	if !ok {
 		return ToolResult{}, fmt.Errorf("cannot read binary file: %s", filePath)
 	}
 
-	// Split into lines
-	lines := strings.Split(string(content), "\n")
+	if byteOffsetSet || byteLimitSet {
+		if _, ok := args["offset"]; ok {
+			return ToolResult{}, fmt.Errorf("use either offset and limit or byteOffset and byteLimit")
+		}
+		if _, ok := args["tail"]; ok {
+			return ToolResult{}, fmt.Errorf("use either tail or byteOffset and byteLimit")
+		}
+		return t.readBytes(f, enc, info.Size(), args)
+	}
 
 	// Get offset and limit
 	offset := 0
@@ -155,36 +213,193 @@ func (t *ReadFileTool) Execute(ctx context.Context, args map[string]interface{})
 	if offset < 0 {
 		offset = 0
 	}
-	if offset > len(lines) {
-		offset = len(lines)
+
+	idx := t.indexes.get(filePath, info, enc)
+	defer t.indexes.put(filePath, idx)
+	scanTooLarge := func() error {
+		return fmt.Errorf("file too large to read this far into: %s is %s, and reading past line %d scans files up to %s. Read it in windows from the start, use tail or byteOffset, or use grep to find what you need in it", filePath, formatSize(info.Size()), len(idx.offsets)*lineIndexStride, formatSize(maxReadFileSize))
 	}
 
-	// Apply offset and limit
-	end := offset + limit
-	if end > len(lines) {
-		end = len(lines)
+	// Tail reads the last lines. Their line numbers need the line count,
+	// which large files only have once they have been read through.
+	if tail, ok := args["tail"].(float64); ok {
+		if _, ok := args["offset"]; ok {
+			return ToolResult{}, fmt.Errorf("use either offset or tail")
+		}
+		if tail < 1 {
+			return ToolResult{}, fmt.Errorf("tail must be at least 1")
+		}
+		limit = int(tail)
+		if idx.total < 0 && info.Size() >= lineIndexMinSize {
+			start, err := tailStart(ctx, f, enc, info.Size(), limit)
+			if err != nil {
+				return ToolResult{}, fmt.Errorf("failed to read file: %w", err)
+			}
+			if start > int64(enc.bom) {
+				return t.readTail(ctx, f, enc, start, limit)
+			}
+		}
+		if idx.total < 0 && info.Size() > maxReadFileSize {
+			return ToolResult{}, scanTooLarge()
+		}
+		if err := countLines(ctx, f, enc, idx); err != nil {
+			return ToolResult{}, fmt.Errorf("failed to read file: %w", err)
+		}
+		offset = idx.total - limit
+		if offset < 0 {
+			offset = 0
+		}
+	} else if info.Size() > maxReadFileSize && offset >= len(idx.offsets)*lineIndexStride {
+		// The read would skip more than one stride of lines the index
+		// doesn't know.
+		return ToolResult{}, scanTooLarge()
 	}
-	selectedLines := lines[offset:end]
 
 	// Format output with line numbers
 	var builder strings.Builder
 	builder.WriteString("<file>\n")
 
-	for i, line := range selectedLines {
-		lineNum := offset + i + 1
-		if len(line) > maxLineLength {
-			line = line[:maxLineLength] + "..."
+	lastReadLine := offset
+	more, err := readLines(ctx, f, enc, idx, offset, limit, func(lineNum int, line []byte, cut bool) error {
+		if bytes.IndexByte(line, 0) >= 0 {
+			return errBinaryFile
+		}
+		text := string(line)
+		if cut {
+			text += "..."
 		}
-		builder.WriteString(fmt.Sprintf("%05d| %s\n", lineNum, line))
+		builder.WriteString(fmt.Sprintf("%05d| %s\n", lineNum, text))
+		lastReadLine = lineNum
+		return nil
+	})
+	if errors.Is(err, errBinaryFile) {
+		return ToolResult{}, fmt.Errorf("cannot read binary file: %s", filePath)
+	}
+	if err != nil {
+		return ToolResult{}, fmt.Errorf("failed to read file: %w", err)
 	}
 
 	// Add file end information
-	totalLines := len(lines)
-	lastReadLine := offset + len(selectedLines)
-	if lastReadLine < totalLines {
+	if more {
 		builder.WriteString(fmt.Sprintf("\n(File has more lines. Use 'offset' parameter to read beyond line %d)\n", lastReadLine))
 	} else {
-		builder.WriteString(fmt.Sprintf("\n(End of file - total %d lines)\n", totalLines))
+		builder.WriteString(fmt.Sprintf("\n(End of file - total %d lines)\n", idx.total))
+	}
+	if enc.transcoded() {
+		builder.WriteString(fmt.Sprintf("(Decoded from %s)\n", enc.name))
+	}
+	builder.WriteString("</file>")
+
+	return ToolResult{
+		Content: builder.String(),
+		IsError: false,
+	}, nil
+}
+
+// readTail returns the lines of a file from byte offset start to its end,
+// without line numbers, since counting the lines before them would read the
+// whole file.
+func (t *ReadFileTool) readTail(ctx context.Context, f *os.File, enc *textEncoding, start int64, limit int) (ToolResult, error) {
+	text, start, err := enc.open(f, start)
+	if err != nil {
+		return ToolResult{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	lr := newLineReader(text, 0, start)
+
+	var builder strings.Builder
+	builder.WriteString("<file>\n")
+	for lr.line < limit {
+		if lr.line%grepCancelCheckLines == 0 && ctx.Err() != nil {
+			return ToolResult{}, ctx.Err()
+		}
+		line, cut, err := lr.next(maxLineLength)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ToolResult{}, fmt.Errorf("failed to read file: %w", err)
+		}
+		if bytes.IndexByte(line, 0) >= 0 {
+			return ToolResult{}, fmt.Errorf("cannot read binary file: %s", f.Name())
+		}
+		builder.WriteString("     | ")
+		builder.Write(line)
+		if cut {
+			builder.WriteString("...")
+		}
+		builder.WriteString("\n")
+	}
+
+	builder.WriteString(fmt.Sprintf("\n(End of file - last %d lines, starting at byte %d. Line numbers are left out as the file is too large to count its lines. Use 'byteOffset' and 'byteLimit' to read before them)\n", lr.line, start))
+	if enc.transcoded() {
+		builder.WriteString(fmt.Sprintf("(Decoded from %s)\n", enc.name))
+	}
+	builder.WriteString("</file>")
+
+	return ToolResult{
+		Content: builder.String(),
+		IsError: false,
+	}, nil
+}
+
+// errBinaryFile stops a read that runs into binary content.
+var errBinaryFile = errors.New("binary file")
+
+// readBytes returns a byte range of a file as raw text, decoded from enc.
+func (t *ReadFileTool) readBytes(f *os.File, enc *textEncoding, size int64, args map[string]interface{}) (ToolResult, error) {
+	var offset int64
+	if o, ok := args["byteOffset"].(float64); ok {
+		offset = int64(o)
+	}
+	limit := int64(defaultByteLimit)
+	if l, ok := args["byteLimit"].(float64); ok {
+		limit = int64(l)
+	}
+	if offset < 0 || limit < 1 {
+		return ToolResult{}, fmt.Errorf("byteOffset must not be negative, and byteLimit must be at least 1")
+	}
+	if limit > maxByteLimit {
+		limit = maxByteLimit
+	}
+	if offset > size {
+		offset = size
+	}
+	if offset < int64(enc.bom) {
+		offset = int64(enc.bom)
+	}
+	if offset+limit > size {
+		limit = size - offset
+	}
+	if enc.unit > 1 {
+		// Read whole code units.
+		offset -= (offset - int64(enc.bom)) % int64(enc.unit)
+		limit -= limit % int64(enc.unit)
+	}
+
+	data := make([]byte, limit)
+	if _, err := f.ReadAt(data, offset); err != nil && err != io.EOF {
+		return ToolResult{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	if enc.transcoded() {
+		decoded, err := io.ReadAll(enc.decode(bytes.NewReader(data)))
+		if err != nil {
+			return ToolResult{}, fmt.Errorf("failed to read file: %w", err)
+		}
+		data = decoded
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return ToolResult{}, fmt.Errorf("cannot read binary file: %s", f.Name())
+	}
+
+	var builder strings.Builder
+	builder.WriteString("<file>\n")
+	// The range may start or end within a character.
+	builder.WriteString(strings.ToValidUTF8(string(data), "\uFFFD"))
+	end := offset + limit
+	if end < size {
+		builder.WriteString(fmt.Sprintf("\n(Bytes %d to %d of %d. Use 'byteOffset' parameter to read beyond byte %d)\n", offset, end, size, end))
+	} else {
+		builder.WriteString(fmt.Sprintf("\n(End of file - bytes %d to %d of %d)\n", offset, end, size))
 	}
 	builder.WriteString("</file>")
 
@@ -192,4 +407,13 @@ func (t *ReadFileTool) Execute(ctx context.Context, args map[string]interface{})
 		Content: builder.String(),
 		IsError: false,
 	}, nil
-}
\ No newline at end of file
+}
+
+func (t *ReadFileTool) isAllowedPath(path string) bool {
+	for _, dir := range append([]string{t.workingDir}, t.allowedDirs...) {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) || path == dir {
+			return true
+		}
+	}
+	return false
+}
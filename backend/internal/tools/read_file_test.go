@@ -2,8 +2,10 @@ package tools
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -80,3 +82,231 @@ func TestReadFileTool_EnvFileBlocking(t *testing.T) {
 		})
 	}
 }
+
+func TestReadFileTool_Windows(t *testing.T) {
+	dir := t.TempDir()
+	long := strings.Repeat("y", 100*1024)
+	writeTree(t, dir, map[string]string{
+		"lines.txt": "one\r\ntwo\nthree\nfour\nfive\n",
+		"long.txt":  "short\n" + long + "\nend",
+		"empty.txt": "",
+		"nul.txt":   "text\n" + strings.Repeat("x", 1024) + "\x00\n",
+	})
+	tool := NewReadFileTool(dir)
+
+	tests := []struct {
+		name string
+		args map[string]interface{}
+		want string
+	}{
+		{
+			name: "whole file",
+			args: map[string]interface{}{"filePath": "lines.txt"},
+			want: "00001| one\n00002| two\n00003| three\n00004| four\n00005| five\n\n(End of file - total 5 lines)\n",
+		},
+		{
+			name: "window",
+			args: map[string]interface{}{"filePath": "lines.txt", "offset": 1.0, "limit": 2.0},
+			want: "00002| two\n00003| three\n\n(File has more lines. Use 'offset' parameter to read beyond line 3)\n",
+		},
+		{
+			name: "offset past the end",
+			args: map[string]interface{}{"filePath": "lines.txt", "offset": 10.0},
+			want: "\n(End of file - total 5 lines)\n",
+		},
+		{
+			name: "tail",
+			args: map[string]interface{}{"filePath": "lines.txt", "tail": 2.0},
+			want: "00004| four\n00005| five\n\n(End of file - total 5 lines)\n",
+		},
+		{
+			name: "tail longer than the file",
+			args: map[string]interface{}{"filePath": "lines.txt", "tail": 9.0},
+			want: "00001| one\n00002| two\n00003| three\n00004| four\n00005| five\n\n(End of file - total 5 lines)\n",
+		},
+		{
+			name: "long line is cut",
+			args: map[string]interface{}{"filePath": "long.txt"},
+			want: "00001| short\n00002| " + long[:maxLineLength] + "...\n00003| end\n\n(End of file - total 3 lines)\n",
+		},
+		{
+			name: "empty file",
+			args: map[string]interface{}{"filePath": "empty.txt"},
+			want: "\n(End of file - total 0 lines)\n",
+		},
+		{
+			name: "byte range",
+			args: map[string]interface{}{"filePath": "long.txt", "byteOffset": 3.0, "byteLimit": 5.0},
+			want: "rt\nyy\n(Bytes 3 to 8 of 102410. Use 'byteOffset' parameter to read beyond byte 8)\n",
+		},
+		{
+			name: "byte range at the end",
+			args: map[string]interface{}{"filePath": "long.txt", "byteOffset": 102405.0},
+			want: "y\nend\n(End of file - bytes 102405 to 102410 of 102410)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tool.Execute(context.Background(), tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := "<file>\n" + tt.want + "</file>"
+			if result.Content != want {
+				t.Errorf("got\n%.300s\nwant\n%.300s", result.Content, want)
+			}
+		})
+	}
+
+	for _, args := range []map[string]interface{}{
+		{"filePath": "nul.txt"},
+		{"filePath": "lines.txt", "offset": 1.0, "tail": 1.0},
+		{"filePath": "lines.txt", "tail": 1.0, "byteOffset": 0.0},
+	} {
+		if _, err := tool.Execute(context.Background(), args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestReadFileTool_LineIndex(t *testing.T) {
+	dir := t.TempDir()
+	var content strings.Builder
+	for i := 1; i <= 100000; i++ {
+		content.WriteString(strings.Repeat("x", i%40) + " line " + strconv.Itoa(i) + "\n")
+	}
+	path := filepath.Join(dir, "big.log")
+	if err := os.WriteFile(path, []byte(content.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tool := NewReadFileTool(dir)
+
+	read := func(args map[string]interface{}) string {
+		t.Helper()
+		args["filePath"] = "big.log"
+		result, err := tool.Execute(context.Background(), args)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return result.Content
+	}
+
+	if got := read(map[string]interface{}{"offset": 50000.0, "limit": 1.0}); !strings.Contains(got, "50001| "+strings.Repeat("x", 50001%40)+" line 50001\n") {
+		t.Errorf("unexpected window:\n%s", got)
+	}
+	idx := tool.indexes.indexes[path]
+	if idx == nil || len(idx.offsets) != 50000/lineIndexStride+1 || idx.total != -1 {
+		t.Fatalf("index was not recorded: %+v", idx)
+	}
+
+	// Tail reads from the end, and can only number lines once the index
+	// has the line count.
+	if got := read(map[string]interface{}{"tail": 2.0}); !strings.Contains(got, "     | "+strings.Repeat("x", 99999%40)+" line 99999\n     |  line 100000\n") || !strings.Contains(got, "last 2 lines") {
+		t.Errorf("unexpected tail:\n%s", got)
+	}
+	if idx := tool.indexes.indexes[path]; idx.total != -1 {
+		t.Errorf("tail counted the lines: %+v", idx)
+	}
+	read(map[string]interface{}{"offset": 99999.0})
+	if got := read(map[string]interface{}{"tail": 1.0}); !strings.Contains(got, "100000| ") || !strings.Contains(got, "total 100000 lines") {
+		t.Errorf("unexpected tail:\n%s", got)
+	}
+	if idx := tool.indexes.indexes[path]; idx.total != 100000 {
+		t.Errorf("index total is %d", idx.total)
+	}
+
+	// Reads through the index land on the same lines as reads from the top.
+	for _, offset := range []float64{0, 4095, 4096, 4097, 81920, 99999} {
+		got := read(map[string]interface{}{"offset": offset, "limit": 1.0})
+		n := int(offset) + 1
+		if want := fmt.Sprintf("%05d| %s line %d\n", n, strings.Repeat("x", n%40), n); !strings.Contains(got, want) {
+			t.Errorf("offset %v: got\n%s\nwant %q", offset, got, want)
+		}
+	}
+
+	// A changed file isn't read through its old index.
+	if err := os.WriteFile(path, []byte("new\n"+content.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(map[string]interface{}{"offset": 81920.0, "limit": 1.0}); !strings.Contains(got, "81921| "+strings.Repeat("x", 81920%40)+" line 81920\n") {
+		t.Errorf("stale index was used:\n%s", got)
+	}
+}
+
+func TestTailStart(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		enc     *textEncoding
+		n       int
+		want    int64
+	}{
+		{name: "trailing newline", content: []byte("a\nb\nc\n"), enc: encodingUTF8, n: 2, want: 2},
+		{name: "no trailing newline", content: []byte("a\nb\nc"), enc: encodingUTF8, n: 1, want: 4},
+		{name: "more lines than the file", content: []byte("a\nb\n"), enc: encodingUTF8, n: 5, want: 0},
+		{name: "empty lines", content: []byte("a\n\n\n"), enc: encodingUTF8, n: 2, want: 2},
+		{name: "byte order mark", content: []byte("\xef\xbb\xbfa\nb\n"), enc: encodingUTF8BOM, n: 9, want: 3},
+		{name: "UTF-16LE", content: encodeUTF16("a\nb\nc\n", false, true), enc: encodingUTF16(false, 2), n: 1, want: 10},
+		{name: "UTF-16BE", content: encodeUTF16("\u0a00\nb\n", true, false), enc: encodingUTF16(true, 0), n: 1, want: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file.txt")
+			if err := os.WriteFile(path, tt.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			got, err := tailStart(context.Background(), f, tt.enc, int64(len(tt.content)), tt.n)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadFileTool_LargeFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "huge.log")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A sparse file past the size limit, with lines at both ends.
+	size := int64(maxReadFileSize + 1024*1024)
+	end := "\nlast line\n"
+	// The start has to be text for the file to be read as text.
+	if _, err := f.WriteString("one\ntwo\nthree\n" + strings.Repeat("filler\n", textSniffSize/7+1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte(end), size-int64(len(end))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	tool := NewReadFileTool(dir)
+
+	read := func(args map[string]interface{}) (string, error) {
+		args["filePath"] = "huge.log"
+		result, err := tool.Execute(context.Background(), args)
+		return result.Content, err
+	}
+
+	if got, err := read(map[string]interface{}{"limit": 2.0}); err != nil || !strings.Contains(got, "00001| one\n00002| two\n") {
+		t.Errorf("head: %v\n%s", err, got)
+	}
+	if got, err := read(map[string]interface{}{"tail": 1.0}); err != nil || !strings.Contains(got, "     | last line\n") {
+		t.Errorf("tail: %v\n%s", err, got)
+	}
+	if got, err := read(map[string]interface{}{"byteOffset": float64(size - 10)}); err != nil || !strings.Contains(got, "last line") {
+		t.Errorf("byte range: %v\n%s", err, got)
+	}
+	if _, err := read(map[string]interface{}{"offset": 100000.0}); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("expected a read far into the file to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,308 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// readBufferSize is the read buffer of a file being read; lines longer
+	// than it are cut without being held whole.
+	readBufferSize = 64 * 1024
+	// lineIndexMinSize is the size from which files get a line index.
+	lineIndexMinSize = 1024 * 1024
+	// lineIndexStride is how many lines apart the index records offsets.
+	lineIndexStride = 4096
+	// maxLineIndexes is how many files' line indexes are kept.
+	maxLineIndexes = 16
+)
+
+// lineReader reads a file line by line with a bounded buffer, keeping
+// track of line numbers and byte offsets.
+type lineReader struct {
+	r *bufio.Reader
+	// line is the 0-based number of the next line, and pos its offset.
+	line int
+	pos  int64
+	buf  []byte
+}
+
+func newLineReader(r io.Reader, line int, pos int64) *lineReader {
+	return &lineReader{r: bufio.NewReaderSize(r, readBufferSize), line: line, pos: pos}
+}
+
+// next reads the next line, without its line ending, keeping at most
+// maxLen bytes of it. It reports whether the line was cut, and returns
+// io.EOF after the last line. The line is only valid until the next call.
+func (lr *lineReader) next(maxLen int) ([]byte, bool, error) {
+	lr.buf = lr.buf[:0]
+	read := 0
+	newline := false
+	for {
+		chunk, err := lr.r.ReadSlice('\n')
+		read += len(chunk)
+		if keep := maxLen - len(lr.buf); keep > 0 {
+			if keep > len(chunk) {
+				keep = len(chunk)
+			}
+			lr.buf = append(lr.buf, chunk[:keep]...)
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return nil, false, err
+		}
+		if read == 0 {
+			return nil, false, io.EOF
+		}
+		newline = err == nil
+		break
+	}
+	lr.line++
+	lr.pos += int64(read)
+
+	length := read
+	if newline {
+		length--
+		if len(lr.buf) > length {
+			lr.buf = lr.buf[:length]
+		}
+		if len(lr.buf) == length && length > 0 && lr.buf[length-1] == '\r' {
+			lr.buf = lr.buf[:length-1]
+			length--
+		}
+	}
+	return lr.buf, len(lr.buf) < length, nil
+}
+
+// lineIndex records where lines start in a large file, so reads far into
+// it can seek rather than scan from the top. It grows as reads go further
+// into the file.
+type lineIndex struct {
+	modTime time.Time
+	size    int64
+	// offsets[i] is the byte offset of line i*lineIndexStride (0-based).
+	offsets []int64
+	// total is the number of lines once the whole file has been read, or
+	// -1.
+	total int
+	// transcoded is set for text read in another encoding than UTF-8.
+	// Offsets into the decoded text aren't offsets into the file, so only
+	// the start of the file is recorded.
+	transcoded bool
+}
+
+func newLineIndex(info os.FileInfo, enc *textEncoding) *lineIndex {
+	return &lineIndex{
+		modTime:    info.ModTime(),
+		size:       info.Size(),
+		offsets:    []int64{0},
+		total:      -1,
+		transcoded: enc.transcoded(),
+	}
+}
+
+// start returns the indexed line nearest before line, and its offset.
+func (idx *lineIndex) start(line int) (int, int64) {
+	i := line / lineIndexStride
+	if i >= len(idx.offsets) {
+		i = len(idx.offsets) - 1
+	}
+	return i * lineIndexStride, idx.offsets[i]
+}
+
+// record notes the offset of the line lr is about to read if it's the
+// next one the index needs.
+func (idx *lineIndex) record(lr *lineReader) {
+	if !idx.transcoded && lr.line == len(idx.offsets)*lineIndexStride {
+		idx.offsets = append(idx.offsets, lr.pos)
+	}
+}
+
+// lineIndexCache holds the line indexes of the files read most recently.
+// An index is dropped once its file changes.
+type lineIndexCache struct {
+	mu      sync.Mutex
+	indexes map[string]*lineIndex
+	order   []string
+}
+
+// get returns a copy of the index of a file, or a new one. Files too small
+// to be worth indexing and transcoded files get a throwaway index.
+func (c *lineIndexCache) get(path string, info os.FileInfo, enc *textEncoding) *lineIndex {
+	fresh := newLineIndex(info, enc)
+	if info.Size() < lineIndexMinSize || fresh.transcoded {
+		return fresh
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	idx, ok := c.indexes[path]
+	if !ok || !idx.modTime.Equal(info.ModTime()) || idx.size != info.Size() {
+		return fresh
+	}
+	copied := *idx
+	copied.offsets = append([]int64(nil), idx.offsets...)
+	return &copied
+}
+
+// put stores the index of a file, unless a further one is already stored.
+func (c *lineIndexCache) put(path string, idx *lineIndex) {
+	if idx.size < lineIndexMinSize || idx.transcoded {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.indexes == nil {
+		c.indexes = make(map[string]*lineIndex)
+	}
+	old, ok := c.indexes[path]
+	if ok && old.modTime.Equal(idx.modTime) && old.size == idx.size && len(old.offsets) > len(idx.offsets) {
+		return
+	}
+	if !ok {
+		c.order = append(c.order, path)
+		if len(c.order) > maxLineIndexes {
+			delete(c.indexes, c.order[0])
+			c.order = c.order[1:]
+		}
+	}
+	c.indexes[path] = idx
+}
+
+// countLines reads the file from the furthest indexed line to the end, so
+// that idx knows how many lines it has.
+func countLines(ctx context.Context, f *os.File, enc *textEncoding, idx *lineIndex) error {
+	if idx.total >= 0 {
+		return nil
+	}
+	line, pos := idx.start(len(idx.offsets) * lineIndexStride)
+	text, pos, err := enc.open(f, pos)
+	if err != nil {
+		return err
+	}
+	lr := newLineReader(text, line, pos)
+	for {
+		if lr.line%grepCancelCheckLines == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		idx.record(lr)
+		if _, _, err := lr.next(0); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	idx.total = lr.line
+	return nil
+}
+
+// tailStart finds where the last n lines of a file in enc start by reading
+// backwards from its end, a block at a time, so that the rest of the file
+// isn't read. It returns the byte offset of the first of those lines, which
+// is the start of the text when the file has no more than n lines.
+func tailStart(ctx context.Context, f *os.File, enc *textEncoding, size int64, n int) (int64, error) {
+	newline := enc.newline
+	if newline == nil {
+		newline = []byte{'\n'}
+	}
+	unit := int64(len(newline))
+	start := int64(enc.bom)
+	end := size - (size-start)%unit
+
+	// A line feed that ends the file doesn't start another line.
+	last := make([]byte, unit)
+	if end-unit >= start {
+		if _, err := f.ReadAt(last, end-unit); err != nil && err != io.EOF {
+			return 0, err
+		}
+		if bytes.Equal(last, newline) {
+			end -= unit
+		}
+	}
+
+	block := make([]byte, readBufferSize)
+	found := 0
+	for end > start {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		from := end - int64(len(block))
+		if from < start {
+			from = start
+		}
+		// Keep blocks aligned to code units.
+		from += (end - from) % unit
+		data := block[:end-from]
+		if _, err := f.ReadAt(data, from); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := int64(len(data)) - unit; i >= 0; i -= unit {
+			if !bytes.Equal(data[i:i+unit], newline) {
+				continue
+			}
+			if found++; found == n {
+				return from + i + unit, nil
+			}
+		}
+		end = from
+	}
+	return start, nil
+}
+
+// readLines reads up to limit lines of text in enc starting at line offset
+// (0-based), cutting each to maxLineLength bytes. It reports whether the
+// file has more lines after them, and fills in idx as it goes.
+func readLines(ctx context.Context, f *os.File, enc *textEncoding, idx *lineIndex, offset, limit int, fn func(lineNum int, line []byte, cut bool) error) (bool, error) {
+	line, pos := idx.start(offset)
+	text, pos, err := enc.open(f, pos)
+	if err != nil {
+		return false, err
+	}
+	lr := newLineReader(text, line, pos)
+
+	for lr.line < offset {
+		if lr.line%grepCancelCheckLines == 0 && ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		idx.record(lr)
+		if _, _, err := lr.next(0); err == io.EOF {
+			idx.total = lr.line
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+
+	for n := 0; n < limit; n++ {
+		idx.record(lr)
+		text, cut, err := lr.next(maxLineLength)
+		if err == io.EOF {
+			idx.total = lr.line
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if err := fn(lr.line, text, cut); err != nil {
+			return false, err
+		}
+	}
+
+	idx.record(lr)
+	if _, _, err := lr.next(0); err == io.EOF {
+		idx.total = lr.line
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
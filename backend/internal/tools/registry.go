@@ -4,9 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-
-	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/shared"
 )
 
 type Registry struct {
@@ -32,22 +29,6 @@ func (r *Registry) Get(name string) (Tool, bool) {
 	return tool, exists
 }
 
-func (r *Registry) GetOpenAITools() []openai.ChatCompletionToolParam {
-	var tools []openai.ChatCompletionToolParam
-	
-	for _, tool := range r.tools {
-		tools = append(tools, openai.ChatCompletionToolParam{
-			Function: shared.FunctionDefinitionParam{
-				Name:        tool.Name(),
-				Description: openai.String(tool.Description()),
-				Parameters:  shared.FunctionParameters(tool.Parameters()),
-			},
-		})
-	}
-	
-	return tools
-}
-
 func (r *Registry) Execute(ctx context.Context, name string, argsJSON string) (ToolResult, error) {
 	var args map[string]interface{}
 	if err := json.Unmarshal([]byte(argsJSON), &args); err != nil {
@@ -0,0 +1,62 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// pruneScratchDir removes saved bash output from dir that is older than
+// maxAge, then the oldest of what is left until the files fit in maxBytes.
+// The file named keep, usually the one just written, is never removed. Zero
+// limits are ignored, and errors are too: pruning is best effort.
+func pruneScratchDir(dir string, maxBytes int64, maxAge time.Duration, keep string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	type scratchFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []scratchFile
+	var total int64
+	now := time.Now()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "bash-") || !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if path != keep && maxAge > 0 && now.Sub(info.ModTime()) > maxAge {
+			os.Remove(path)
+			continue
+		}
+		files = append(files, scratchFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+
+	if maxBytes <= 0 {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		if total <= maxBytes {
+			break
+		}
+		if f.path == keep {
+			continue
+		}
+		if os.Remove(f.path) == nil {
+			total -= f.size
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPruneScratchDir(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	files := []struct {
+		name string
+		age  time.Duration
+	}{
+		{"bash-stale.log", 48 * time.Hour},
+		{"bash-old.log", 3 * time.Hour},
+		{"bash-mid.log", 2 * time.Hour},
+		{"bash-new.log", time.Hour},
+		{"notes.txt", 48 * time.Hour},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if err := os.WriteFile(path, []byte(strings.Repeat("x", 100)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-f.age), now.Add(-f.age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The oldest file is kept because it was just written; the next oldest
+	// makes way for it.
+	pruneScratchDir(dir, 250, 24*time.Hour, filepath.Join(dir, "bash-old.log"))
+
+	for name, want := range map[string]bool{
+		"bash-stale.log": false,
+		"bash-old.log":   true,
+		"bash-mid.log":   false,
+		"bash-new.log":   true,
+		"notes.txt":      true,
+	} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != want {
+			t.Errorf("%s: exists = %v, want %v", name, exists, want)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	// textSniffSize is how much of the start of a file is looked at to
+	// tell text from binary and find its encoding.
+	textSniffSize = 8192
+	// maxControlRatio is the share of control characters above which a
+	// file is taken to be binary.
+	maxControlRatio = 0.1
+)
+
+// textEncoding is an encoding text files are read in.
+type textEncoding struct {
+	name string
+	// bom is the length of the byte order mark the file starts with.
+	bom int
+	// unit is the size of the encoding's code units if it's more than a
+	// byte.
+	unit int
+	// newline is how the encoding writes a line feed, if not as one byte.
+	newline []byte
+	// decode converts the encoding to UTF-8. It is nil for UTF-8.
+	decode func(io.Reader) io.Reader
+}
+
+var (
+	encodingUTF8    = &textEncoding{name: "UTF-8"}
+	encodingUTF8BOM = &textEncoding{name: "UTF-8", bom: 3}
+	encodingLatin1  = &textEncoding{name: "Latin-1", decode: newLatin1Reader}
+)
+
+func encodingUTF16(bigEndian bool, bom int) *textEncoding {
+	name, newline := "UTF-16LE", []byte{'\n', 0}
+	if bigEndian {
+		name, newline = "UTF-16BE", []byte{0, '\n'}
+	}
+	return &textEncoding{name: name, bom: bom, unit: 2, newline: newline, decode: func(r io.Reader) io.Reader {
+		return newUTF16Reader(r, bigEndian)
+	}}
+}
+
+// sniffText looks at the start of a file and returns its encoding, or
+// false if it looks binary. A byte order mark settles the encoding.
+// Otherwise the text is UTF-16 if every other byte is mostly NUL, UTF-8 if
+// it's valid UTF-8, and Latin-1 if not, and binary if it has other NUL
+// bytes or too many control characters.
+func sniffText(head []byte) (*textEncoding, bool) {
+	if len(head) > textSniffSize {
+		head = head[:textSniffSize]
+	}
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		return encodingUTF8BOM, true
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return encodingUTF16(false, 2), true
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return encodingUTF16(true, 2), true
+	}
+
+	if bytes.IndexByte(head, 0) >= 0 {
+		if bigEndian, ok := looksUTF16(head); ok {
+			return encodingUTF16(bigEndian, 0), true
+		}
+		return nil, false
+	}
+
+	if validUTF8Prefix(head) {
+		if controlRatio(head, false) > maxControlRatio {
+			return nil, false
+		}
+		return encodingUTF8, true
+	}
+	if controlRatio(head, true) > maxControlRatio {
+		return nil, false
+	}
+	return encodingLatin1, true
+}
+
+// looksUTF16 reports whether text without a byte order mark is UTF-16, as
+// text that's mostly ASCII is: with NUL in nearly every high byte and in
+// almost no low bytes.
+func looksUTF16(head []byte) (bigEndian bool, ok bool) {
+	units := len(head) / 2
+	if units == 0 {
+		return false, false
+	}
+	var evenNUL, oddNUL int
+	for i := 0; i+1 < len(head); i += 2 {
+		if head[i] == 0 {
+			evenNUL++
+		}
+		if head[i+1] == 0 {
+			oddNUL++
+		}
+	}
+	switch {
+	case oddNUL*10 >= units*7 && evenNUL*20 <= units:
+		return false, true
+	case evenNUL*10 >= units*7 && oddNUL*20 <= units:
+		return true, true
+	}
+	return false, false
+}
+
+// validUTF8Prefix reports whether head is valid UTF-8, allowing for a
+// character cut off at its end.
+func validUTF8Prefix(head []byte) bool {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(head); i++ {
+		if utf8.RuneStart(head[len(head)-i]) {
+			if !utf8.FullRune(head[len(head)-i:]) {
+				head = head[:len(head)-i]
+			}
+			break
+		}
+	}
+	return utf8.Valid(head)
+}
+
+// controlRatio returns the share of bytes in head that are control
+// characters other than whitespace, backspace and escape, which logs with
+// terminal colors have. With latin1 set, the C1 controls of Latin-1 count
+// as well.
+func controlRatio(head []byte, latin1 bool) float64 {
+	if len(head) == 0 {
+		return 0
+	}
+	controls := 0
+	for _, b := range head {
+		switch {
+		case b == '\t' || b == '\n' || b == '\r' || b == '\f' || b == '\v' || b == '\b' || b == 0x1b:
+		case b < 0x20 || b == 0x7f:
+			controls++
+		case latin1 && b >= 0x80 && b < 0xa0:
+			controls++
+		}
+	}
+	return float64(controls) / float64(len(head))
+}
+
+// open returns a reader of the text of f as UTF-8 from byte offset pos,
+// skipping the byte order mark at the start, and the offset it starts at.
+func (e *textEncoding) open(f io.ReadSeeker, pos int64) (io.Reader, int64, error) {
+	if pos < int64(e.bom) {
+		pos = int64(e.bom)
+	}
+	if _, err := f.Seek(pos, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	if e.decode == nil {
+		return f, pos, nil
+	}
+	return e.decode(f), pos, nil
+}
+
+// transcoded reports whether text is converted as it's read, so that
+// offsets into what's read aren't offsets into the file.
+func (e *textEncoding) transcoded() bool {
+	return e.decode != nil
+}
+
+// latin1Reader converts Latin-1 to UTF-8.
+type latin1Reader struct {
+	r       *bufio.Reader
+	pending []byte
+}
+
+func newLatin1Reader(r io.Reader) io.Reader {
+	return &latin1Reader{r: bufio.NewReader(r)}
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(l.pending) > 0 {
+			c := copy(p[n:], l.pending)
+			l.pending = l.pending[c:]
+			n += c
+			continue
+		}
+		if n > 0 && l.r.Buffered() == 0 {
+			break
+		}
+		b, err := l.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		if b < utf8.RuneSelf {
+			p[n] = b
+			n++
+			continue
+		}
+		l.pending = utf8.AppendRune(l.pending[:0], rune(b))
+	}
+	return n, nil
+}
+
+// utf16Reader converts UTF-16 to UTF-8. Unpaired surrogates and a trailing
+// odd byte become U+FFFD.
+type utf16Reader struct {
+	r         *bufio.Reader
+	bigEndian bool
+	pending   []byte
+}
+
+func newUTF16Reader(r io.Reader, bigEndian bool) io.Reader {
+	return &utf16Reader{r: bufio.NewReader(r), bigEndian: bigEndian}
+}
+
+func (u *utf16Reader) unit() (uint16, error) {
+	var pair [2]byte
+	n, err := io.ReadFull(u.r, pair[:])
+	if n == 1 {
+		return utf8.RuneError, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if u.bigEndian {
+		return uint16(pair[0])<<8 | uint16(pair[1]), nil
+	}
+	return uint16(pair[1])<<8 | uint16(pair[0]), nil
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(u.pending) > 0 {
+			c := copy(p[n:], u.pending)
+			u.pending = u.pending[c:]
+			n += c
+			continue
+		}
+		if n > 0 && u.r.Buffered() < 2 {
+			break
+		}
+		unit, err := u.unit()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		r := rune(unit)
+		if utf16.IsSurrogate(r) {
+			// Look at the next unit without consuming it, so that an
+			// unpaired surrogate doesn't swallow the character after it.
+			next, err := u.r.Peek(2)
+			if err == nil {
+				var low uint16
+				if u.bigEndian {
+					low = uint16(next[0])<<8 | uint16(next[1])
+				} else {
+					low = uint16(next[1])<<8 | uint16(next[0])
+				}
+				if decoded := utf16.DecodeRune(r, rune(low)); decoded != utf8.RuneError {
+					u.r.Discard(2)
+					r = decoded
+				} else {
+					r = utf8.RuneError
+				}
+			} else {
+				r = utf8.RuneError
+			}
+		}
+		u.pending = utf8.AppendRune(u.pending[:0], r)
+	}
+	return n, nil
+}
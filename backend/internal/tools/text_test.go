@@ -0,0 +1,149 @@
+package tools
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// encodeUTF16 encodes s as UTF-16, with a byte order mark if bom is set.
+func encodeUTF16(s string, bigEndian, bom bool) []byte {
+	units := utf16.Encode([]rune(s))
+	if bom {
+		units = append([]uint16{0xFEFF}, units...)
+	}
+	data := make([]byte, 0, 2*len(units))
+	for _, u := range units {
+		if bigEndian {
+			data = append(data, byte(u>>8), byte(u))
+		} else {
+			data = append(data, byte(u), byte(u>>8))
+		}
+	}
+	return data
+}
+
+func TestSniffText(t *testing.T) {
+	source := "package main\n\nfunc main() {\n\tprintln(\"héllo\")\n}\n"
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{name: "UTF-8", head: []byte(source), want: "UTF-8"},
+		{name: "empty", head: nil, want: "UTF-8"},
+		{name: "UTF-8 with BOM", head: append([]byte{0xEF, 0xBB, 0xBF}, source...), want: "UTF-8"},
+		{name: "UTF-8 cut within a character", head: []byte(strings.Repeat("a", textSniffSize-1) + "é"), want: "UTF-8"},
+		{name: "UTF-16LE with BOM", head: encodeUTF16(source, false, true), want: "UTF-16LE"},
+		{name: "UTF-16BE with BOM", head: encodeUTF16(source, true, true), want: "UTF-16BE"},
+		{name: "UTF-16LE without BOM", head: encodeUTF16(source, false, false), want: "UTF-16LE"},
+		{name: "UTF-16BE without BOM", head: encodeUTF16(source, true, false), want: "UTF-16BE"},
+		{name: "Latin-1", head: []byte("caf\xe9 cr\xe8me br\xfbl\xe9e\n"), want: "Latin-1"},
+		{name: "terminal colors", head: []byte("\x1b[32mok\x1b[0m test\n\x1b[31mfail\x1b[0m\n"), want: "UTF-8"},
+		{name: "NUL", head: []byte("text\x00more text"), want: ""},
+		{name: "PNG", head: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x01\x00"), want: ""},
+		{name: "SQLite", head: []byte("SQLite format 3\x00\x10\x00\x01\x01\x00@  "), want: ""},
+		{name: "control characters", head: []byte("a\x01b\x02c\x03d\x04e\x05f"), want: ""},
+		{name: "high bytes with C1 controls", head: []byte("\x81\x92\x83\x94\x85\x96abc\x87\x98"), want: ""},
+	}
+	for _, tt := range tests {
+		enc, ok := sniffText(tt.head)
+		got := ""
+		if ok {
+			got = enc.name
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTextEncoding_Decode(t *testing.T) {
+	tests := []struct {
+		name string
+		enc  *textEncoding
+		data []byte
+		want string
+	}{
+		{name: "Latin-1", enc: encodingLatin1, data: []byte("caf\xe9\n\xa9 2024"), want: "café\n© 2024"},
+		{name: "UTF-16LE", enc: encodingUTF16(false, 0), data: encodeUTF16("héllo 😀\n", false, false), want: "héllo 😀\n"},
+		{name: "UTF-16BE", enc: encodingUTF16(true, 0), data: encodeUTF16("héllo 😀\n", true, false), want: "héllo 😀\n"},
+		{name: "unpaired surrogate", enc: encodingUTF16(false, 0), data: []byte{0x3d, 0xd8, 'a', 0}, want: "�a"},
+		{name: "odd byte", enc: encodingUTF16(false, 0), data: []byte{'a', 0, 'b'}, want: "a�"},
+	}
+	for _, tt := range tests {
+		// A small read buffer exercises characters split across reads.
+		data, err := io.ReadAll(&oneByteReader{r: tt.enc.decode(strings.NewReader(string(tt.data)))})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, data, tt.want)
+		}
+	}
+}
+
+// oneByteReader reads one byte at a time.
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o *oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(p[:1])
+}
+
+func TestTools_ReadEncodings(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"utf16.txt":  encodeUTF16("first\r\nsecond needle\r\n", false, true),
+		"latin1.txt": []byte("caf\xe9 needle\n"),
+		"bom.txt":    []byte("\xef\xbb\xbfneedle\n"),
+		"data.db":    []byte("SQLite format 3\x00needle"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read := NewReadFileTool(dir)
+	result, err := read.Execute(context.Background(), map[string]interface{}{"filePath": "utf16.txt"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "<file>\n00001| first\n00002| second needle\n\n(End of file - total 2 lines)\n(Decoded from UTF-16LE)\n</file>"
+	if result.Content != want {
+		t.Errorf("got\n%s\nwant\n%s", result.Content, want)
+	}
+
+	result, err = read.Execute(context.Background(), map[string]interface{}{"filePath": "bom.txt"})
+	if err != nil || !strings.Contains(result.Content, "00001| needle\n") {
+		t.Errorf("byte order mark was not skipped: %v\n%s", err, result.Content)
+	}
+
+	if _, err := read.Execute(context.Background(), map[string]interface{}{"filePath": "data.db"}); err == nil || !strings.Contains(err.Error(), "binary") {
+		t.Errorf("binary file was read: %v", err)
+	}
+
+	grep := NewGrepTool(dir)
+	grep.rgPath = ""
+	result, err = grep.Execute(context.Background(), map[string]interface{}{"pattern": "needle"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, line := range []string{"bom.txt:1:needle\n", "latin1.txt:1:café needle\n", "utf16.txt:2:second needle\n"} {
+		if !strings.Contains(result.Content, line) {
+			t.Errorf("missing %q in\n%s", line, result.Content)
+		}
+	}
+	if strings.Contains(result.Content, "data.db") {
+		t.Errorf("binary file was searched:\n%s", result.Content)
+	}
+}
@@ -0,0 +1,116 @@
+package tools
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// truncateOutput shortens s so that it fits in maxBytes, keeping at most the
+// first headLines and the last tailLines lines. Whatever falls in between is
+// replaced by a marker saying how many lines were dropped. The head and tail
+// each get half of the byte budget and are cut on line boundaries; a single
+// line longer than its budget is cut on a rune boundary instead, and when no
+// whole line was dropped the marker says how many bytes were cut from it. The
+// second return value reports whether anything was removed.
+func truncateOutput(s string, maxBytes, headLines, tailLines int) (string, bool) {
+	lines := strings.SplitAfter(s, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(s) <= maxBytes && len(lines) <= headLines+tailLines {
+		return s, false
+	}
+
+	budget := maxBytes / 2
+
+	var head []string
+	used, cut := 0, 0
+	for i := 0; i < len(lines) && i < headLines; i++ {
+		line := lines[i]
+		if used+len(line) > budget {
+			if i == 0 {
+				head = append(head, truncateRunesPrefix(line, budget)+"…\n")
+				used = budget
+				cut++
+			}
+			break
+		}
+		head = append(head, line)
+		used += len(line)
+	}
+
+	var tail []string
+	used = 0
+	for i := len(lines) - 1; i >= len(head) && len(tail) < tailLines; i-- {
+		line := lines[i]
+		if used+len(line) > budget {
+			if len(tail) == 0 {
+				tail = append(tail, "…"+truncateRunesSuffix(line, budget))
+				used = budget
+				cut++
+			}
+			break
+		}
+		tail = append(tail, line)
+		used += len(line)
+	}
+
+	var b strings.Builder
+	for _, line := range head {
+		b.WriteString(line)
+	}
+	if len(head) > 0 && !strings.HasSuffix(head[len(head)-1], "\n") {
+		b.WriteString("\n")
+	}
+
+	kept := b.Len()
+	for _, line := range tail {
+		kept += len(line)
+	}
+	omittedLines := len(lines) - len(head) - len(tail)
+	omittedBytes := len(s) - kept
+	if omittedBytes < 0 {
+		omittedBytes = 0
+	}
+	switch {
+	case omittedLines == 0 && cut == 1:
+		b.WriteString(fmt.Sprintf("\n... [%d bytes cut from a long line] ...\n\n", omittedBytes))
+	case omittedLines == 0:
+		b.WriteString(fmt.Sprintf("\n... [%d bytes cut from %d long lines] ...\n\n", omittedBytes, cut))
+	default:
+		b.WriteString(fmt.Sprintf("\n... [%d lines omitted, %d bytes] ...\n\n", omittedLines, omittedBytes))
+	}
+
+	for i := len(tail) - 1; i >= 0; i-- {
+		b.WriteString(tail[i])
+	}
+
+	return b.String(), true
+}
+
+// truncateRunesPrefix returns the longest prefix of s that is at most n bytes
+// and does not split a UTF-8 sequence.
+func truncateRunesPrefix(s string, n int) string {
+	if n >= len(s) {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// truncateRunesSuffix returns the longest suffix of s that is at most n bytes
+// and does not split a UTF-8 sequence.
+func truncateRunesSuffix(s string, n int) string {
+	if n >= len(s) {
+		return s
+	}
+	start := len(s) - n
+	for start < len(s) && !utf8.RuneStart(s[start]) {
+		start++
+	}
+	return s[start:]
+}
@@ -0,0 +1,88 @@
+package tools
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateOutput(t *testing.T) {
+	numbered := func(n int) string {
+		var b strings.Builder
+		for i := 1; i <= n; i++ {
+			fmt.Fprintf(&b, "line %d\n", i)
+		}
+		return b.String()
+	}
+
+	tests := []struct {
+		name          string
+		input         string
+		maxBytes      int
+		head, tail    int
+		wantTruncated bool
+		wantContains  []string
+		wantMissing   []string
+	}{
+		{
+			name:     "short output untouched",
+			input:    numbered(5),
+			maxBytes: 1000, head: 10, tail: 10,
+		},
+		{
+			name:     "keeps head and tail lines",
+			input:    numbered(100),
+			maxBytes: 100000, head: 3, tail: 2,
+			wantTruncated: true,
+			wantContains:  []string{"line 1\n", "line 3\n", "line 99\n", "line 100\n", "[95 lines omitted"},
+			wantMissing:   []string{"line 4\n", "line 98\n"},
+		},
+		{
+			name:     "byte budget limits kept lines",
+			input:    numbered(100),
+			maxBytes: 40, head: 50, tail: 50,
+			wantTruncated: true,
+			wantContains:  []string{"line 1\n", "line 100\n"},
+			wantMissing:   []string{"line 50\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, truncated := truncateOutput(tt.input, tt.maxBytes, tt.head, tt.tail)
+			if truncated != tt.wantTruncated {
+				t.Fatalf("truncated = %v, want %v", truncated, tt.wantTruncated)
+			}
+			if !truncated && got != tt.input {
+				t.Errorf("untruncated output changed: %q", got)
+			}
+			for _, s := range tt.wantContains {
+				if !strings.Contains(got, s) {
+					t.Errorf("output missing %q:\n%s", s, got)
+				}
+			}
+			for _, s := range tt.wantMissing {
+				if strings.Contains(got, s) {
+					t.Errorf("output should not contain %q:\n%s", s, got)
+				}
+			}
+		})
+	}
+}
+
+func TestTruncateOutput_RuneBoundaries(t *testing.T) {
+	// A single long line of multi-byte runes must be cut without splitting
+	// any of them.
+	input := strings.Repeat("世界", 1000)
+	got, truncated := truncateOutput(input, 101, 10, 10)
+	if !truncated {
+		t.Fatal("expected output to be truncated")
+	}
+	if !utf8.ValidString(got) {
+		t.Errorf("truncated output is not valid UTF-8: %q", got)
+	}
+	if !strings.Contains(got, "bytes cut from a long line]") || strings.Contains(got, "lines omitted") {
+		t.Errorf("marker should report the bytes cut from the line:\n%s", got)
+	}
+}
@@ -1,6 +1,10 @@
 package tools
 
-import "context"
+import (
+	"context"
+
+	"github.com/jack/klaudkod/backend/internal/llm"
+)
 
 type Tool interface {
 	Name() string
@@ -16,9 +20,11 @@ type ToolCall struct {
 }
 
 type ToolResult struct {
-	ToolCallID string `json:"tool_call_id"`
-	Content    string `json:"content"`
-	IsError    bool   `json:"is_error"`
+	ToolCallID string                 `json:"tool_call_id"`
+	Content    string                 `json:"content"`
+	IsError    bool                   `json:"is_error"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Images     []llm.Image            `json:"images,omitempty"`
 }
 
 type ToolContext struct {
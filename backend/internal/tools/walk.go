@@ -0,0 +1,104 @@
+package tools
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// walkOptions control walkFiles.
+type walkOptions struct {
+	// noIgnore walks files excluded by ignore files and the defaults too.
+	// Version control directories are skipped either way.
+	noIgnore bool
+	// dirs passes directories to fn as well as files.
+	dirs bool
+	// maxDepth, when positive, is how many levels below the root are
+	// walked. Entries directly in the root are at depth 1.
+	maxDepth int
+}
+
+// noIgnoreParameter is the parameter tools backed by walkFiles take to set
+// walkOptions.noIgnore.
+var noIgnoreParameter = map[string]interface{}{
+	"type":        "boolean",
+	"description": "Include files excluded by .gitignore, .klaudkodignore and similar files, and dependency directories such as node_modules (defaults to false)",
+}
+
+// walkFiles calls fn for every file under root in lexical order. It skips
+// version control directories and whatever .gitignore files, the
+// repository's info/exclude, the global excludes file and .klaudkodignore
+// files exclude, unless opts.noIgnore is set. Returning filepath.SkipAll
+// from fn stops the walk, and returning filepath.SkipDir for a directory
+// skips its contents. A root that is a file is passed to fn as is.
+func walkFiles(ctx context.Context, root string, opts walkOptions, fn func(path string, d fs.DirEntry) error) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fn(root, fs.FileInfoToDirEntry(info))
+	}
+
+	var ignoreRoot string
+	var matchers map[string]*ignoreMatcher
+	if !opts.noIgnore {
+		var m *ignoreMatcher
+		ignoreRoot, m = newIgnoreMatcher(root)
+		matchers = map[string]*ignoreMatcher{root: m}
+	}
+
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil || path == root {
+			// Unreadable entries are skipped.
+			return nil
+		}
+		if d.IsDir() && vcsDirs[d.Name()] {
+			return filepath.SkipDir
+		}
+
+		if matchers != nil {
+			parent := matchers[filepath.Dir(path)]
+			rel, err := filepath.Rel(ignoreRoot, path)
+			if err != nil {
+				return nil
+			}
+			if parent.ignored(filepath.ToSlash(rel), d.IsDir()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				matchers[path] = parent.with(readIgnoreFiles(ignoreRoot, path))
+			}
+		}
+
+		if !d.IsDir() {
+			return fn(path, d)
+		}
+		if opts.dirs {
+			if err := fn(path, d); err != nil {
+				return err
+			}
+		}
+		if opts.maxDepth > 0 && walkDepth(root, path) >= opts.maxDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// walkDepth is how many levels below root path is.
+func walkDepth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return 0
+	}
+	return strings.Count(filepath.ToSlash(rel), "/") + 1
+}
@@ -0,0 +1,166 @@
+package tools
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeTree creates files under dir from slash-separated paths.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func walkedFiles(t *testing.T, root string, opts walkOptions) []string {
+	t.Helper()
+	var files []string
+	err := walkFiles(context.Background(), root, opts, func(path string, d fs.DirEntry) error {
+		rel, _ := filepath.Rel(root, path)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk failed: %v", err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestWalkFiles_Ignores(t *testing.T) {
+	// Keep the user's own git config out of the test.
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	writeTree(t, home, map[string]string{".config/git/ignore": "*.swp\n"})
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".git/HEAD":               "ref: refs/heads/main\n",
+		".git/info/exclude":       "local/\n",
+		".gitignore":              "*.log\n/build/\n!keep.log\ndocs/**/*.tmp\n",
+		".klaudkodignore":         "fixtures/big.json\n",
+		"main.go":                 "",
+		"debug.log":               "",
+		"keep.log":                "",
+		"main.go.swp":             "",
+		"build/out.bin":           "",
+		"src/build/gen.go":        "",
+		"local/notes.txt":         "",
+		"docs/a/b/c.tmp":          "",
+		"docs/a/readme.md":        "",
+		"fixtures/big.json":       "",
+		"fixtures/small.json":     "",
+		"node_modules/x/index.js": "",
+		"pkg/.gitignore":          "*.gen.go\n!keep.gen.go\n",
+		"pkg/api.gen.go":          "",
+		"pkg/keep.gen.go":         "",
+		"pkg/api.go":              "",
+	})
+
+	want := []string{
+		".gitignore",
+		".klaudkodignore",
+		"docs/a/readme.md",
+		"fixtures/small.json",
+		"keep.log",
+		"main.go",
+		"pkg/.gitignore",
+		"pkg/api.go",
+		"pkg/keep.gen.go",
+		"src/build/gen.go",
+	}
+	if got := walkedFiles(t, dir, walkOptions{}); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("walked:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Rules from parent directories apply when walking a subdirectory.
+	if got := walkedFiles(t, filepath.Join(dir, "pkg"), walkOptions{}); strings.Join(got, ",") != ".gitignore,api.go,keep.gen.go" {
+		t.Errorf("walked pkg: %v", got)
+	}
+
+	all := walkedFiles(t, dir, walkOptions{noIgnore: true})
+	if len(all) != 18 {
+		t.Errorf("noIgnore walked %d files, want every file but .git's: %v", len(all), all)
+	}
+	for _, f := range all {
+		if strings.HasPrefix(f, ".git/") {
+			t.Errorf("noIgnore walked into .git: %s", f)
+		}
+	}
+}
+
+func TestIgnoreRule_Match(t *testing.T) {
+	tests := []struct {
+		pattern string
+		base    string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{pattern: "*.log", path: "a/b/c.log", want: true},
+		{pattern: "/*.log", path: "a/c.log", want: false},
+		{pattern: "/*.log", path: "c.log", want: true},
+		{pattern: "out/", path: "a/out", isDir: true, want: true},
+		{pattern: "out/", path: "a/out", want: false},
+		{pattern: "a/**/z", path: "a/z", want: true},
+		{pattern: "a/**/z", path: "a/b/c/z", want: true},
+		{pattern: "**/z", path: "a/b/z", want: true},
+		{pattern: "a/**", path: "a", isDir: true, want: false},
+		{pattern: "a/**", path: "a/b", want: true},
+		{pattern: "gen.go", base: "pkg", path: "pkg/x/gen.go", want: true},
+		{pattern: "gen.go", base: "pkg", path: "cmd/gen.go", want: false},
+		{pattern: "/x", base: "pkg", path: "pkg/x", want: true},
+		{pattern: `\#notes`, path: "#notes", want: true},
+	}
+
+	for _, tt := range tests {
+		rules := parseIgnoreRules(tt.pattern, tt.base)
+		if len(rules) != 1 {
+			t.Fatalf("%q parsed to %d rules", tt.pattern, len(rules))
+		}
+		if got := rules[0].match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("%q (base %q) on %q: got %v, want %v", tt.pattern, tt.base, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestGlobTool_RespectsIgnores(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".gitignore":                "dist/\n",
+		"src/app.ts":                "",
+		"dist/app.ts":               "",
+		"node_modules/lib/index.ts": "",
+	})
+
+	tool := NewGlobTool(dir)
+	result, err := tool.Execute(context.Background(), map[string]interface{}{"pattern": "**/*.ts"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(result.Content, "src/app.ts") || strings.Contains(result.Content, "dist/") || strings.Contains(result.Content, "node_modules") {
+		t.Errorf("unexpected results:\n%s", result.Content)
+	}
+
+	result, err = tool.Execute(context.Background(), map[string]interface{}{"pattern": "**/*.ts", "noIgnore": true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(result.Content, "Found 3 matches") {
+		t.Errorf("noIgnore results:\n%s", result.Content)
+	}
+}
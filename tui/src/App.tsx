@@ -1,4 +1,6 @@
 import React, { useState, useCallback, useEffect } from 'react';
+import { readFileSync } from 'node:fs';
+import { basename, resolve } from 'node:path';
 import { Box, Text, useInput, useApp } from 'ink';
 import { Chat } from './components/Chat.js';
 import { Input } from './components/Input.js';
@@ -6,10 +8,24 @@ import { StatusBar } from './components/StatusBar.js';
 import { useWebSocket } from './hooks/useWebSocket.js';
 import { useChat, ToolResult } from './hooks/useChat.js';
 
-export function App() {
+interface Attachment {
+  name: string;
+  data: string;
+}
+
+interface AppProps {
+  profile?: string;
+}
+
+export function App({ profile }: AppProps) {
   const { exit } = useApp();
   const [inputValue, setInputValue] = useState('');
   const [toolResults, setToolResults] = useState<Map<string, ToolResult>>(new Map());
+  const [status, setStatus] = useState<string | undefined>();
+  const [title, setTitle] = useState<string | undefined>();
+  const [sessionCost, setSessionCost] = useState<number | undefined>();
+  const [showReasoning, setShowReasoning] = useState(false);
+  const [attachments, setAttachments] = useState<Attachment[]>([]);
 
   const { connected, send, onMessage } = useWebSocket('ws://localhost:8080/ws');
   const { 
@@ -17,6 +33,9 @@ export function App() {
     activeToolCalls,
     addMessage, 
     updateLastMessage,
+    appendReasoning,
+    trimLastMessage,
+    trimLastReasoning,
     addToolCall,
     updateToolCallStatus,
     addToolResult,
@@ -26,11 +45,39 @@ export function App() {
   const handleSubmit = useCallback((value: string) => {
     if (!value.trim()) return;
 
-    addMessage({ role: 'user', content: value });
-    send({ type: 'prompt', content: value });
+    if (value.trim() === '/continue') {
+      send({ type: 'continue' });
+      setInputValue('');
+      return;
+    }
+
+    if (value.trim() === '/compact') {
+      send({ type: 'compact' });
+      setInputValue('');
+      setStatus('Compacting conversation...');
+      return;
+    }
+
+    if (value.trim().startsWith('/attach ')) {
+      const path = value.trim().slice('/attach '.length).trim();
+      try {
+        // The backend checks the type and size of the image.
+        const data = readFileSync(resolve(path)).toString('base64');
+        setAttachments(prev => [...prev, { name: basename(path), data }]);
+      } catch (err: any) {
+        addMessage({ role: 'system', content: `Error: cannot attach ${path}: ${err.message}` });
+      }
+      setInputValue('');
+      return;
+    }
+
+    const names = attachments.map(a => a.name).join(', ');
+    addMessage({ role: 'user', content: names ? `${value}\n[attached: ${names}]` : value });
+    send({ type: 'prompt', content: value, profile, attachments });
+    setAttachments([]);
     setInputValue('');
     setToolResults(new Map());
-  }, [addMessage, send]);
+  }, [addMessage, send, profile, attachments]);
 
   useEffect(() => {
     if (!onMessage) return;
@@ -38,12 +85,21 @@ export function App() {
     onMessage((data: any) => {
       switch (data.type) {
         case 'chunk':
+          setStatus(undefined);
           if (data.isFirst) {
             addMessage({ role: 'assistant', content: '' });
           }
           updateLastMessage(data.content);
           break;
 
+        case 'reasoning':
+          setStatus(undefined);
+          if (data.isFirst) {
+            addMessage({ role: 'assistant', content: '' });
+          }
+          appendReasoning(data.content);
+          break;
+
         case 'tool_call':
           const toolCall = data.toolCall;
           addToolCall({
@@ -65,21 +121,72 @@ export function App() {
           addToolResult(toolResult);
           break;
 
+        case 'retrying':
+          if (data.retry?.discarded) {
+            trimLastMessage(data.retry.discarded);
+          }
+          if (data.retry?.discardedReasoning) {
+            trimLastReasoning(data.retry.discardedReasoning);
+          }
+          setStatus(
+            `Retrying (attempt ${data.retry?.attempt}/${data.retry?.maxAttempts}) in ${Math.round((data.retry?.delayMs ?? 0) / 1000)}s: ${data.error}`
+          );
+          break;
+
+        case 'fallback':
+          if (data.fallback?.discarded) {
+            trimLastMessage(data.fallback.discarded);
+          }
+          if (data.fallback?.discardedReasoning) {
+            trimLastReasoning(data.fallback.discardedReasoning);
+          }
+          setStatus(`Switched from ${data.fallback?.from} to ${data.fallback?.to}: ${data.error}`);
+          break;
+
+        case 'usage':
+          setSessionCost(data.usage?.session?.cost_usd);
+          break;
+
+        case 'step_limit':
+          addMessage({ role: 'system', content: `${data.content} Type /continue to keep going.` });
+          break;
+
+        case 'schema_invalid':
+          addMessage({ role: 'system', content: `Answer does not match the response schema, asking for a correction:\n${data.content}` });
+          break;
+
+        case 'compacted':
+          setStatus(undefined);
+          addMessage({
+            role: 'system',
+            content: `Conversation compacted: ~${data.compaction?.tokensBefore} → ~${data.compaction?.tokensAfter} tokens`
+          });
+          break;
+
+        case 'title':
+          setTitle(data.content);
+          break;
+
         case 'done':
+          setStatus(undefined);
           clearToolCalls();
           break;
 
         case 'error':
+          setStatus(undefined);
           addMessage({ role: 'system', content: `Error: ${data.message}` });
           break;
       }
     });
-  }, [onMessage, addMessage, updateLastMessage, addToolCall, addToolResult, clearToolCalls]);
+  }, [onMessage, addMessage, updateLastMessage, appendReasoning, trimLastMessage, trimLastReasoning, addToolCall, addToolResult, clearToolCalls]);
 
   useInput((input, key) => {
     if (key.ctrl && input === 'c') {
       exit();
     }
+    if (key.ctrl && input === 'r') {
+      setShowReasoning(prev => !prev);
+    }
   });
 
   return (
@@ -89,6 +196,7 @@ export function App() {
           messages={messages} 
           activeToolCalls={activeToolCalls}
           toolResults={toolResults}
+          showReasoning={showReasoning}
         />
       </Box>
 
@@ -101,7 +209,13 @@ export function App() {
         />
       </Box>
 
-      <StatusBar connected={connected} />
+      <StatusBar
+        connected={connected}
+        status={status}
+        title={title}
+        cost={sessionCost}
+        attachments={attachments.map(a => a.name)}
+      />
     </Box>
   );
 }
\ No newline at end of file
@@ -7,9 +7,10 @@ interface ChatProps {
   messages: Message[];
   activeToolCalls: ToolCall[];
   toolResults: Map<string, ToolResult>;
+  showReasoning: boolean;
 }
 
-export function Chat({ messages, activeToolCalls, toolResults }: ChatProps) {
+export function Chat({ messages, activeToolCalls, toolResults, showReasoning }: ChatProps) {
   if (messages.length === 0) {
     return (
       <Box flexDirection="column" alignItems="center" justifyContent="center" flexGrow={1}>
@@ -22,7 +23,7 @@ export function Chat({ messages, activeToolCalls, toolResults }: ChatProps) {
   return (
     <Box flexDirection="column" gap={0}>
       {messages.map((message, index) => (
-        <MessageBubble key={`msg-${index}`} message={message} />
+        <MessageBubble key={`msg-${index}`} message={message} showReasoning={showReasoning} />
       ))}
       {activeToolCalls.length > 0 && (
         <ToolsPanel toolCalls={activeToolCalls} toolResults={toolResults} />
@@ -31,7 +32,7 @@ export function Chat({ messages, activeToolCalls, toolResults }: ChatProps) {
   );
 }
 
-function MessageBubble({ message }: { message: Message }) {
+function MessageBubble({ message, showReasoning }: { message: Message; showReasoning: boolean }) {
   const isUser = message.role === 'user';
 
   return (
@@ -40,6 +41,15 @@ function MessageBubble({ message }: { message: Message }) {
         {isUser ? 'You' : 'Assistant'}
       </Text>
       <Box flexDirection="column" paddingLeft={2}>
+        {message.reasoning && (
+          showReasoning ? (
+            <Text color="gray" italic wrap="wrap">{message.reasoning}</Text>
+          ) : (
+            <Text color="gray" dimColor>
+              ▸ Thinking ({message.reasoning.split(/\s+/).filter(Boolean).length} words, Ctrl+R to show)
+            </Text>
+          )
+        )}
         <Text wrap="wrap">{message.content}</Text>
         {message.toolCalls && message.toolCalls.length > 0 && (
           <Text color="gray" dimColor>
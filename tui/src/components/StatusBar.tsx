@@ -3,12 +3,21 @@ import { Box, Text } from 'ink';
 
 interface StatusBarProps {
   connected: boolean;
+  status?: string;
+  title?: string;
+  cost?: number;
+  attachments?: string[];
 }
 
-export function StatusBar({ connected }: StatusBarProps) {
+export function StatusBar({ connected, status, title, cost, attachments }: StatusBarProps) {
   return (
     <Box paddingX={1} justifyContent="space-between">
-      <Text color="gray">Klaudkod v0.1.0</Text>
+      <Text color="gray">Klaudkod v0.1.0{title ? ` · ${title}` : ''}</Text>
+      {status && <Text color="yellow">{status}</Text>}
+      {attachments && attachments.length > 0 && (
+        <Text color="cyan">Attached: {attachments.join(', ')}</Text>
+      )}
+      {cost !== undefined && <Text color="gray">${cost.toFixed(4)}</Text>}
       <Text color={connected ? 'green' : 'red'}>
         {connected ? '● Connected' : '○ Disconnected'}
       </Text>
@@ -16,6 +16,7 @@ export interface ToolResult {
 export interface Message {
   role: 'user' | 'assistant' | 'system';
   content: string;
+  reasoning?: string;
   toolCalls?: ToolCall[];
   toolResult?: ToolResult;
 }
@@ -40,6 +41,47 @@ export function useChat() {
     });
   }, []);
 
+  const appendReasoning = useCallback((reasoning: string) => {
+    setMessages((prev) => {
+      if (prev.length === 0) return prev;
+      const updated = [...prev];
+      const last = updated[updated.length - 1];
+      updated[updated.length - 1] = {
+        ...last,
+        reasoning: (last.reasoning ?? '') + reasoning,
+      };
+      return updated;
+    });
+  }, []);
+
+  const trimLastReasoning = useCallback((text: string) => {
+    setMessages((prev) => {
+      if (prev.length === 0) return prev;
+      const last = prev[prev.length - 1];
+      if (!last.reasoning?.endsWith(text)) return prev;
+      const updated = [...prev];
+      updated[updated.length - 1] = {
+        ...last,
+        reasoning: last.reasoning.slice(0, last.reasoning.length - text.length),
+      };
+      return updated;
+    });
+  }, []);
+
+  const trimLastMessage = useCallback((text: string) => {
+    setMessages((prev) => {
+      if (prev.length === 0) return prev;
+      const last = prev[prev.length - 1];
+      if (!last.content.endsWith(text)) return prev;
+      const updated = [...prev];
+      updated[updated.length - 1] = {
+        ...last,
+        content: last.content.slice(0, last.content.length - text.length),
+      };
+      return updated;
+    });
+  }, []);
+
   const clearMessages = useCallback(() => {
     setMessages([]);
   }, []);
@@ -82,6 +124,9 @@ export function useChat() {
     activeToolCalls,
     addMessage,
     updateLastMessage,
+    appendReasoning,
+    trimLastMessage,
+    trimLastReasoning,
     clearMessages,
     addToolCall,
     updateToolCallStatus,
@@ -3,4 +3,8 @@ import React from 'react';
 import { render } from 'ink';
 import { App } from './App.js';
 
-render(<App />);
+// --profile <name> picks the system prompt profile for the session.
+const profileFlag = process.argv.indexOf('--profile');
+const profile = profileFlag !== -1 ? process.argv[profileFlag + 1] : undefined;
+
+render(<App profile={profile} />);